@@ -0,0 +1,276 @@
+package btree
+
+import "sort"
+
+// InsertBatch adds the keys of ks, which must be in ascending order, in a
+// single pass down the tree. Each node takes all of its share of the batch at
+// once and splits at most once, into as many nodes as its keys need.
+func (t *BTree) InsertBatch(ks []Key) (err error) {
+	if err := t.checkBatch(ks); err != nil || len(ks) == 0 {
+		return err
+	}
+	defer catch(&err)
+
+	t.drain()
+	if t.accesses != nil {
+		// Each key may evict others, so they go in one at a time.
+		for _, k := range ks {
+			if err := t.insertBounded(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	t.version++
+	ns, seps := insertBatch(&t.cfg, t.root, ks)
+	for len(ns) > 1 {
+		r := t.cfg.allocInternal(t.cfg.InternalOrder)
+		ns, seps = r.rebuild(ns, seps)
+	}
+	t.root = ns[0]
+	for _, k := range ks {
+		t.notify(EventInsert, nil, k)
+	}
+	return nil
+}
+
+// RemoveBatch removes the keys of ks, which must be in ascending order, in a
+// single pass down the tree. Each node is refilled or merged at most once,
+// after all of its share of the batch has been removed.
+func (t *BTree) RemoveBatch(ks []Key) (err error) {
+	if err := t.checkBatch(ks); err != nil || len(ks) == 0 {
+		return err
+	}
+	defer catch(&err)
+
+	t.drain()
+	var old keys
+	for i, k := range ks {
+		if i == 0 || ks[i-1].Less(k) {
+			if o := t.watched(k); o != nil {
+				old = append(old, o)
+			}
+		}
+	}
+	t.rightmost = nil
+	t.version++
+	removeBatch(t.root, ks)
+	t.shrinkRoot()
+	for _, k := range ks {
+		t.forget(k)
+	}
+	for _, k := range old {
+		t.notify(EventDelete, k, nil)
+	}
+	return nil
+}
+
+func (t *BTree) checkBatch(ks []Key) error {
+	for i, k := range ks {
+		if err := t.checkKey(k); err != nil {
+			return err
+		}
+		if i > 0 && k.Less(ks[i-1]) {
+			return ErrUnsorted
+		}
+	}
+	if len(ks) > 0 {
+		t.setKeyType(ks[0])
+	}
+	return nil
+}
+
+// insertBatch inserts the sorted keys ks below n. It returns the nodes that
+// now hold the keys of n, ending with n itself, and the separators between
+// them.
+func insertBatch(c *Config, n node, ks keys) (nodes, keys) {
+	in, ok := n.(*internalNode)
+	if !ok {
+		return insertLeafBatch(c, n, ks)
+	}
+
+	ns := make(nodes, 0, len(in.nodes))
+	seps := make(keys, 0, len(in.keys))
+	for i, child := range in.nodes {
+		if i > 0 {
+			seps = append(seps, in.keys[i-1])
+		}
+		j := len(ks)
+		if i < len(in.keys) {
+			sep := in.keys[i]
+			j = sort.Search(len(ks), func(j int) bool { return !ks[j].Less(sep) })
+		}
+		if j == 0 {
+			ns = append(ns, child)
+			continue
+		}
+		cns, cseps := insertBatch(c, child, ks[:j])
+		ns = append(ns, cns...)
+		seps = append(seps, cseps...)
+		ks = ks[j:]
+	}
+	return in.rebuild(ns, seps)
+}
+
+// rebuild spreads the children ns and their separators over n and as many
+// new nodes before it as they need, leaving room for one more key in each.
+// It returns those nodes and the separators promoted between them.
+func (n *internalNode) rebuild(ns nodes, seps keys) (nodes, keys) {
+	var out nodes
+	var promoted keys
+	for _, size := range chunks(len(ns), cap(n.keys)) {
+		dst := n
+		if size < len(ns) {
+			dst = n.cfg.allocInternal(uint(cap(n.keys)))
+		}
+		dst.sliceKeys(0, 0)
+		dst.appendKeys(seps[:size-1]...)
+		dst.nodes.truncate(0)
+		dst.nodes = append(dst.nodes, ns[:size]...)
+		n.cfg.resum(dst)
+		out = append(out, dst)
+		if size < len(ns) {
+			promoted = append(promoted, seps[size-1])
+			seps = seps[size:]
+		}
+		ns = ns[size:]
+	}
+	n.cfg.observeSplits(out)
+	return out, promoted
+}
+
+// insertLeafBatch merges the sorted keys ks into the leaf n, spread over as
+// many leaves before it as they need, and returns those leaves and the
+// separators between them. The sum of n takes on those of ks, then passes
+// those of the keys moved out to the leaves that now hold them.
+func insertLeafBatch(c *Config, n node, ks keys) (nodes, keys) {
+	for _, k := range ks {
+		n.sums().add(c.hashKey(k))
+	}
+	old := n.Keys()
+	all := make(keys, 0, len(old)+len(ks))
+	for i, j := 0, 0; i < len(old) || j < len(ks); {
+		if j == len(ks) || i < len(old) && !ks[j].Less(old[i]) {
+			all = append(all, old[i])
+			i++
+		} else {
+			all = append(all, ks[j])
+			j++
+		}
+	}
+
+	var out nodes
+	var seps keys
+	for _, size := range chunks(len(all), leafCap(n)-1) {
+		leaf := n
+		if size < len(all) {
+			leaf = newLeafBefore(n)
+			seps = append(seps, splitKey(all[size-1], all[size]))
+		}
+		setLeafKeys(leaf, all[:size])
+		if leaf != n {
+			c.shift(leaf, n, 0, size)
+		}
+		out = append(out, leaf)
+		all = all[size:]
+	}
+	c.observeSplits(out)
+	return out, seps
+}
+
+// chunks splits n entries into as few runs of at most max entries as
+// possible, with sizes as even as possible.
+func chunks(n, max int) []int {
+	c := (n + max - 1) / max
+	if c <= 1 {
+		return []int{n}
+	}
+	sizes := make([]int, c)
+	for i := range sizes {
+		sizes[i] = n / c
+		if i < n%c {
+			sizes[i]++
+		}
+	}
+	return sizes
+}
+
+// newLeafBefore returns an empty leaf like n, linked in just before it.
+func newLeafBefore(n node) node {
+	switch n := n.(type) {
+	case *leafNode:
+		l := n.cfg.allocLeaf(uint(cap(n.keys)))
+		l.previous, l.next = n.previous, n
+		if n.previous != nil {
+			n.previous.next = l
+		}
+		n.previous = l
+		return l
+	case *bytesLeafNode:
+		l := n.cfg.allocBytesLeaf(uint(cap(n.suffixes)))
+		l.previous, l.next = n.previous, n
+		if n.previous != nil {
+			n.previous.next = l
+		}
+		n.previous = l
+		return l
+	case typedLeaf:
+		return n.newBefore()
+	}
+	corrupt("unexpected leaf type")
+	return nil
+}
+
+// setLeafKeys replaces the keys of the leaf n with the sorted keys ks.
+func setLeafKeys(n node, ks keys) {
+	switch n := n.(type) {
+	case *leafNode:
+		n.keys = append(n.keys[:0], ks...)
+	case *bytesLeafNode:
+		bs := make([]Bytes, len(ks))
+		for i, k := range ks {
+			bs[i] = k.(Bytes)
+		}
+		n.reset(bs)
+	case typedLeaf:
+		n.setKeys(ks)
+	default:
+		corrupt("unexpected leaf type")
+	}
+}
+
+// removeBatch removes the sorted keys ks below n, then drops, refills or
+// merges the children of n once all of them have had their keys removed.
+func removeBatch(n node, ks keys) {
+	in, ok := n.(*internalNode)
+	if !ok {
+		for _, k := range ks {
+			n.Remove(k)
+		}
+		return
+	}
+
+	for i, child := range in.nodes {
+		j := len(ks)
+		if i < len(in.keys) {
+			sep := in.keys[i]
+			j = sort.Search(len(ks), func(j int) bool { return !ks[j].Less(sep) })
+		}
+		if j > 0 {
+			removeBatch(child, ks[:j])
+			ks = ks[j:]
+		}
+	}
+	in.cfg.resum(in)
+	// Drop the emptied children first, so that no refill or merge meets
+	// one. Fixing a child only ever drops it or its right sibling, so going
+	// from right to left keeps the indexes of the children still to fix.
+	for i := len(in.nodes) - 1; i >= 0; i-- {
+		if isDrained(in.nodes[i]) {
+			in.fix(i)
+		}
+	}
+	for i := len(in.nodes) - 1; i >= 0; i-- {
+		in.fix(i)
+	}
+}
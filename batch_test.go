@@ -0,0 +1,142 @@
+package btree
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func testBatch(vs []int) []Key {
+	sort.Ints(vs)
+	ks := make([]Key, len(vs))
+	for i, v := range vs {
+		ks[i] = &testKey{value: v}
+	}
+	return ks
+}
+
+func TestInsertBatch(t *testing.T) {
+	tree := NewBTree(8)
+	want := make(map[int]bool)
+	// Sorted micro-batches of increasing keys fill leaves densely.
+	for b := 0; b < 50; b++ {
+		var vs []int
+		for i := 0; i < 100; i++ {
+			vs = append(vs, b*100+i)
+			want[b*100+i] = true
+		}
+		if err := tree.InsertBatch(testBatch(vs)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkTree(t, tree, want)
+	if _, fill := leafFill(tree); fill < 0.8 {
+		t.Fatalf("Got leaf fill %.2f after appending batches", fill)
+	}
+
+	// Batches spread over the tree split the leaves they land in.
+	r := rand.New(rand.NewSource(1))
+	for b := 0; b < 50; b++ {
+		var vs []int
+		for i := 0; i < 200; i++ {
+			if v := r.Intn(20000); !want[v] {
+				vs = append(vs, v)
+				want[v] = true
+			}
+		}
+		if err := tree.InsertBatch(testBatch(vs)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkTree(t, tree, want)
+
+	if err := tree.InsertBatch(testBatch(nil)); err != nil {
+		t.Fatalf("Got error %v for an empty batch", err)
+	}
+	unsorted := []Key{&testKey{value: 2}, &testKey{value: 1}}
+	if err := tree.InsertBatch(unsorted); err != ErrUnsorted {
+		t.Fatalf("Got error %v instead of expected error %v", err, ErrUnsorted)
+	}
+	if err := tree.InsertBatch([]Key{String("a")}); err != ErrKeyTypeMismatch {
+		t.Fatalf("Got error %v instead of expected error %v", err, ErrKeyTypeMismatch)
+	}
+	checkTree(t, tree, want)
+}
+
+func TestRemoveBatch(t *testing.T) {
+	for _, policy := range []MergePolicy{MergeEager, MergeLazy} {
+		for _, d := range []uint{3, 4, 8} {
+			tree, err := New(WithOrder(d), WithMergePolicy(policy))
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := rand.New(rand.NewSource(int64(d)))
+			want := make(map[int]bool)
+			var all []int
+			for i := 0; i < 3000; i++ {
+				all = append(all, i)
+				want[i] = true
+			}
+			tree.InsertBatch(testBatch(all))
+
+			for len(want) > 0 {
+				// Remove a run of keys, some of them already gone, and a
+				// few scattered ones.
+				var vs []int
+				start, n := r.Intn(3000), r.Intn(300)
+				for v := start; v < start+n; v++ {
+					vs = append(vs, v)
+					delete(want, v)
+				}
+				for i := 0; i < 10; i++ {
+					v := r.Intn(3000)
+					vs = append(vs, v)
+					delete(want, v)
+				}
+				if err := tree.RemoveBatch(testBatch(vs)); err != nil {
+					t.Fatal(err)
+				}
+				checkTree(t, tree, want)
+				if len(want) < 100 {
+					var rest []int
+					for v := range want {
+						rest = append(rest, v)
+					}
+					tree.RemoveBatch(testBatch(rest))
+					want = map[int]bool{}
+				}
+			}
+			checkTree(t, tree, want)
+			if _, ok := tree.root.(*leafNode); !ok {
+				t.Fatalf("Got root %T after removing every key", tree.root)
+			}
+		}
+	}
+}
+
+func BenchmarkTreeInsertBatch(b *testing.B) {
+	for _, batched := range []bool{false, true} {
+		name := "Insert"
+		if batched {
+			name = "InsertBatch"
+		}
+		b.Run(name, func(b *testing.B) {
+			tree := NewBTree(32)
+			ks := make([]Key, b.N)
+			for i := range ks {
+				ks[i] = &testKey{value: i}
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i += 1000 {
+				batch := ks[i:min(i+1000, b.N)]
+				if batched {
+					tree.InsertBatch(batch)
+					continue
+				}
+				for _, k := range batch {
+					tree.Insert(k)
+				}
+			}
+		})
+	}
+}
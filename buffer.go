@@ -0,0 +1,200 @@
+package btree
+
+// In trees built WithBuffering, inserts and removals do not descend to the
+// leaves. They are queued as messages in the buffer of the root, and a full
+// buffer is flushed a level down at once, so that each node on the way is
+// touched once per batch of messages rather than once per key. Messages for a
+// key always sit on the path to its leaf, newer ones above older ones, so Get
+// finds the latest one on its way down. Operations that walk the leaves, such
+// as Scan, first drain every buffer, which writes to the tree; BufferSize
+// lists them.
+
+// message is an insert, or a removal, of a key not yet applied to its leaf.
+type message struct {
+	k      key
+	remove bool
+}
+
+// buffering reports whether the tree queues changes in its internal nodes.
+func (c *Config) buffering() bool {
+	return c != nil && c.BufferSize > 0
+}
+
+// enqueue adds m to the buffer of the root r, flushing it when full.
+func (t *BTree) enqueue(r *internalNode, m message) {
+	r.buf = append(r.buf, m)
+	t.pending = true
+	if len(r.buf) < t.cfg.BufferSize {
+		return
+	}
+	t.version++
+	t.rightmost = nil
+	r.flush()
+	t.splitRoot()
+	t.shrinkRoot()
+}
+
+// flush passes the messages buffered in n down to its children, in order. It
+// stops early when the splits of its children fill n, which must then be
+// split in turn, or when removals empty it, in which case its parent takes
+// the rest.
+func (n *internalNode) flush() {
+	for len(n.buf) > 0 && !n.IsFull() && len(n.nodes) > 0 {
+		m := n.buf[0]
+		n.buf[0] = message{}
+		n.buf = n.buf[1:]
+		n.apply(m)
+	}
+	if len(n.buf) == 0 {
+		n.buf = nil
+	}
+}
+
+// apply passes m to the child of n it belongs to, which applies it to its
+// keys if it is a leaf, or buffers it otherwise. n must not be full.
+func (n *internalNode) apply(m message) {
+	_, nIdx := n.searchKNIndex(m.k)
+	child := n.nodes[nIdx]
+	before := *child.sums()
+	switch in, ok := child.(*internalNode); {
+	case ok:
+		in.buf = append(in.buf, m)
+		if len(in.buf) >= n.cfg.BufferSize {
+			in.flush()
+			n.sum.add(in.sum.since(before))
+		}
+		if in.IsFull() {
+			n.splitChild(nIdx)
+			return
+		}
+	case m.remove:
+		child.Remove(m.k)
+		n.sum.add(child.sums().since(before))
+	default:
+		if child.IsFull() {
+			if !m.k.Less(n.splitChild(nIdx)) {
+				nIdx++
+			}
+			child = n.nodes[nIdx]
+			before = *child.sums()
+		}
+		child.Insert(m.k)
+		n.sum.add(child.sums().since(before))
+		return
+	}
+	n.fix(nIdx)
+}
+
+// moveMessages moves the messages of from whose keys are below sep, or not
+// below it, to the end of the buffer of to, after a split or rebalance has
+// moved their children.
+func moveMessages(to, from *internalNode, sep key, below bool) {
+	if len(from.buf) == 0 {
+		return
+	}
+	rest := from.buf[:0]
+	for _, m := range from.buf {
+		if m.k.Less(sep) == below {
+			to.buf = append(to.buf, m)
+		} else {
+			rest = append(rest, m)
+		}
+	}
+	clear(from.buf[len(rest):])
+	from.buf = rest
+}
+
+// lookup returns the key in the tree equal to k as Get would find it once
+// the buffered messages for k were applied. A removal takes out the copy of k
+// inserted last, like leafNode.Remove, so going from the newest message to
+// the oldest, each removal cancels the next insert, and those left over skip
+// as many copies in the leaf.
+func (t *BTree) lookup(k key) key {
+	if !t.pending {
+		return t.root.Get(k)
+	}
+	skip := 0
+	n := t.root
+	for {
+		in, ok := n.(*internalNode)
+		if !ok {
+			break
+		}
+		for i := len(in.buf) - 1; i >= 0; i-- {
+			switch m := in.buf[i]; {
+			case m.k.Compare(k) != 0:
+			case m.remove:
+				skip++
+			case skip == 0:
+				return m.k
+			default:
+				skip--
+			}
+		}
+		_, nIdx := in.searchKNIndex(k)
+		n = in.nodes[nIdx]
+	}
+	if skip == 0 {
+		return n.Get(k)
+	}
+	if i := n.Search(k) + skip; i < leafLen(n) && leafAt(n, i).Compare(k) == 0 {
+		return leafAt(n, i)
+	}
+	return nil
+}
+
+// drain applies every buffered message to the leaves, for the operations
+// that walk them. Messages deeper in the tree are older, so they go first.
+func (t *BTree) drain() {
+	if !t.pending {
+		return
+	}
+	var levels [][]message
+	var collect func(n node, depth int)
+	collect = func(n node, depth int) {
+		in, ok := n.(*internalNode)
+		if !ok {
+			return
+		}
+		if len(in.buf) > 0 {
+			for len(levels) <= depth {
+				levels = append(levels, nil)
+			}
+			levels[depth] = append(levels[depth], in.buf...)
+			clear(in.buf)
+			in.buf = nil
+		}
+		for _, child := range in.nodes {
+			collect(child, depth+1)
+		}
+	}
+	collect(t.root, 0)
+
+	t.pending = false
+	t.version++
+	t.rightmost = nil
+	for d := len(levels) - 1; d >= 0; d-- {
+		for _, m := range levels[d] {
+			t.apply(m)
+		}
+	}
+}
+
+// tryDrain drains the tree for the operations that have no catch of their
+// own, and returns ErrCorrupt if it meets a corrupt node on the way.
+func (t *BTree) tryDrain() (err error) {
+	defer catch(&err)
+	t.drain()
+	return nil
+}
+
+// apply applies m from the root down, without buffering it.
+func (t *BTree) apply(m message) {
+	if m.remove {
+		t.root.Remove(m.k)
+		t.shrinkRoot()
+		return
+	}
+	t.root.Insert(m.k)
+	t.splitRoot()
+}
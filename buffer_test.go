@@ -0,0 +1,193 @@
+package btree
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestBufferedRandomInsertRemove(t *testing.T) {
+	for _, opts := range [][]Option{
+		{WithOrder(3), WithBuffering(2)},
+		{WithOrder(4), WithBuffering(4), WithNodePool()},
+		{WithOrder(4), WithBuffering(3), WithMergePolicy(MergeLazy)},
+		{WithLeafOrder(8), WithInternalOrder(5), WithBuffering(16), WithSplitPolicy(SplitAdaptive)},
+	} {
+		tree, err := New(opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := rand.New(rand.NewSource(1))
+		want := make(map[int]bool)
+		var h Hint
+		for i := 0; i < 5000; i++ {
+			v := r.Intn(1000)
+			k := &testKey{value: v}
+			switch op := r.Intn(10); {
+			case op < 5:
+				if tree.Get(k) == nil {
+					tree.Insert(k)
+					want[v] = true
+				}
+			case op < 9:
+				tree.Remove(k)
+				delete(want, v)
+			default:
+				if tree.GetHint(k, &h) == nil {
+					tree.InsertHint(k, &h)
+					want[v] = true
+				}
+			}
+			if got := tree.Get(k) != nil; got != want[v] {
+				t.Fatalf("Get(%d) found %v after op %d, want %v", v, got, i, want[v])
+			}
+			if i%500 == 0 {
+				checkTree(t, tree, want)
+			}
+		}
+		checkTree(t, tree, want)
+
+		// Removing everything through the buffers empties the tree.
+		for v := range want {
+			tree.Remove(&testKey{value: v})
+		}
+		checkTree(t, tree, map[int]bool{})
+		if _, ok := tree.root.(*internalNode); ok {
+			t.Fatal("Empty tree kept an internal root")
+		}
+	}
+
+	// A removal takes out the copy of a key inserted last, like
+	// leafNode.Remove, whether it is still buffered or already in a leaf, and
+	// Get finds the last inserted of the copies left. Copies only stay in one
+	// leaf, where Get sees them all, while it neither splits nor merges, so
+	// the tree is filled first and then changed too little to reshape it.
+	for _, size := range []int{2, 5} {
+		rec := &recorder{}
+		tree, _ := New(WithLeafOrder(64), WithInternalOrder(3), WithBuffering(size),
+			WithMergePolicy(MergeLazy), WithObserver(rec))
+		r := rand.New(rand.NewSource(2))
+		for _, v := range r.Perm(300) {
+			tree.Insert(&testKey{value: 10 * v})
+		}
+		tree.drain()
+		rec.events = nil
+
+		dups := r.Perm(300)[:6]
+		copies := make(map[int][]*testKey)
+		for i := 0; i < 3000; i++ {
+			v := 10*dups[r.Intn(len(dups))] + 5
+			if r.Intn(2) == 0 && len(copies[v]) < 3 {
+				k := &testKey{value: v}
+				tree.Insert(k)
+				copies[v] = append(copies[v], k)
+			} else {
+				tree.Remove(&testKey{value: v})
+				if n := len(copies[v]); n > 0 {
+					copies[v] = copies[v][:n-1]
+				}
+			}
+			var want key
+			if n := len(copies[v]); n > 0 {
+				want = copies[v][n-1]
+			}
+			if got := tree.Get(&testKey{value: v}); got != want {
+				t.Fatalf("Get(%d) after op %d found %p, want %p of %d copies", v, i, got, want, len(copies[v]))
+			}
+		}
+		if len(rec.events) > 0 {
+			t.Fatalf("Tree changed shape with %v", rec.events[0])
+		}
+	}
+
+	if _, err := New(WithBuffering(-1)); err != ErrInvalidLimit {
+		t.Fatalf("Negative buffer size returned %v", err)
+	}
+}
+
+func TestBufferedCorrupt(t *testing.T) {
+	corrupted := func() *BTree {
+		tree, _ := New(WithOrder(4), WithBuffering(100), WithMerkle(encodeTestKey))
+		for i := 0; i < 20; i++ {
+			tree.Insert(&testKey{value: i})
+		}
+		tree.drain()
+		// Move a leaf down a level, so that its siblings are of different
+		// kinds, and queue the removals that will merge across it.
+		root := tree.root.(*internalNode)
+		in := newInternalNode(4)
+		in.nodes = append(in.nodes, root.nodes[1])
+		root.nodes[1] = in
+		for i := 0; i < 20; i++ {
+			tree.Remove(&testKey{value: i})
+		}
+		return tree
+	}
+
+	// Operations that drain the buffers report the corruption they meet.
+	it := corrupted().Scan(nil, nil)
+	if it.Next() || !errors.Is(it.Err(), ErrCorrupt) {
+		t.Fatalf("Scan of a corrupt tree returned %v", it.Err())
+	}
+	if _, err := corrupted().RootHash(); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("RootHash of a corrupt tree returned %v", err)
+	}
+}
+
+func TestBufferedMerkle(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	plain, _ := New(WithOrder(4), WithMerkle(encodeInt64))
+	buffered, _ := New(WithOrder(4), WithBuffering(5), WithMerkle(encodeInt64))
+	for i := 0; i < 2000; i++ {
+		k := Int64(rng.Intn(1000))
+		if rng.Intn(3) == 0 {
+			plain.Remove(k)
+			buffered.Remove(k)
+		} else if plain.Get(k) == nil {
+			plain.Insert(k)
+			buffered.Insert(k)
+		}
+		if i%100 == 0 {
+			if rootHash(t, plain) != rootHash(t, buffered) {
+				t.Fatalf("Buffered tree hashes differently after %d ops", i)
+			}
+			checkHashes(t, buffered, rng)
+		}
+	}
+}
+
+func BenchmarkTreeInsertRandomBuffered(b *testing.B) {
+	for _, size := range []int{0, 16, 64} {
+		b.Run(fmt.Sprintf("Buffer=%d", size), func(b *testing.B) {
+			tree, _ := New(WithOrder(32), WithBuffering(size))
+			r := rand.New(rand.NewSource(1))
+			keys := make([]*testKey, b.N)
+			for i := 0; i < b.N; i += 1 {
+				keys[i] = &testKey{value: r.Int()}
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i += 1 {
+				tree.Insert(keys[i])
+			}
+		})
+	}
+}
+
+func BenchmarkTreeGetBuffered(b *testing.B) {
+	for _, size := range []int{0, 16, 64} {
+		b.Run(fmt.Sprintf("Buffer=%d", size), func(b *testing.B) {
+			tree, _ := New(WithOrder(32), WithBuffering(size))
+			r := rand.New(rand.NewSource(1))
+			keys := make([]*testKey, 100000)
+			for i := range keys {
+				keys[i] = &testKey{value: r.Int()}
+				tree.Insert(keys[i])
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i += 1 {
+				tree.Get(keys[i%len(keys)])
+			}
+		})
+	}
+}
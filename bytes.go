@@ -0,0 +1,81 @@
+package btree
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Bytes is a key compared bytewise, such as one produced by the tuple
+// package. Trees of Bytes keys compare with a single memcmp and can be
+// written to tree files with EncodeBytes.
+type Bytes []byte
+
+func (b Bytes) Less(other key) bool {
+	return bytes.Compare(b, other.(Bytes)) < 0
+}
+
+func (b Bytes) Compare(other key) int {
+	return bytes.Compare(b, other.(Bytes))
+}
+
+// separator returns the shortest prefix of b that is greater than a, for use
+// as the separator between two sibling leaves.
+func (b Bytes) separator(a key) key {
+	l := commonPrefix(a.(Bytes), b)
+	if l >= len(b)-1 {
+		return b
+	}
+	return append(Bytes(nil), b[:l+1]...)
+}
+
+// EncodeBytes is an Encoder for trees of Bytes keys. The keys are stored
+// unchanged, with no values.
+func EncodeBytes(k key) (kb, vb []byte) {
+	return k.(Bytes), nil
+}
+
+// An Entry is a key and its value, ordered bytewise by key, for trees used as
+// maps of byte strings, such as those of the server and httpapi packages.
+type Entry struct {
+	Key, Value []byte
+}
+
+func (e *Entry) Less(other key) bool {
+	return bytes.Compare(e.Key, other.(*Entry).Key) < 0
+}
+
+func (e *Entry) Compare(other key) int {
+	return bytes.Compare(e.Key, other.(*Entry).Key)
+}
+
+// EncodeEntry is an Encoder for trees of Entries, storing their keys and
+// values as they are.
+func EncodeEntry(k key) (kb, vb []byte) {
+	e := k.(*Entry)
+	return e.Key, e.Value
+}
+
+// String is a key compared bytewise as a string.
+type String string
+
+func (s String) Less(other key) bool {
+	return s < other.(String)
+}
+
+func (s String) Compare(other key) int {
+	return strings.Compare(string(s), string(other.(String)))
+}
+
+// separator returns the shortest prefix of s that is greater than a.
+func (s String) separator(a key) key {
+	l := commonPrefix([]byte(a.(String)), []byte(s))
+	if l >= len(s)-1 {
+		return s
+	}
+	return String(strings.Clone(string(s[:l+1])))
+}
+
+// EncodeString is an Encoder for trees of String keys.
+func EncodeString(k key) (kb, vb []byte) {
+	return []byte(k.(String)), nil
+}
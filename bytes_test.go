@@ -0,0 +1,74 @@
+package btree
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/highlyunavailable/btree/tuple"
+)
+
+func TestBytesTupleKeys(t *testing.T) {
+	tree := NewBTree(8)
+	for i := 0; i < 100; i++ {
+		tree.Insert(Bytes(tuple.Tuple{"user", i % 7, tuple.Desc{V: i}}.Pack()))
+	}
+
+	k := Bytes(tuple.Tuple{"user", 3, tuple.Desc{V: 52}}.Pack())
+	if got := tree.Get(k); got == nil || got.Compare(k) != 0 {
+		t.Fatalf("Got key %x instead of expected key %x", got, k)
+	}
+
+	name := filepath.Join(t.TempDir(), "tree.btf")
+	if err := tree.WriteFile(name, EncodeBytes); err != nil {
+		t.Fatalf("Failed to write tree file: %v", err)
+	}
+	f, err := Open(name)
+	if err != nil {
+		t.Fatalf("Failed to open tree file: %v", err)
+	}
+	defer f.Close()
+
+	if _, ok, err := f.Get(k); !ok || err != nil {
+		t.Fatalf("Got found %v, error %v for key %x in tree file", ok, err, k)
+	}
+
+	// Descending components come back from a scan in reverse order.
+	prev := 100
+	f.Range(tuple.Tuple{"user", 3}.Pack(), tuple.Tuple{"user", 4}.Pack(), func(kb, _ []byte) bool {
+		tup, err := tuple.Unpack(kb)
+		if err != nil {
+			t.Fatalf("Failed to unpack key %x: %v", kb, err)
+		}
+		v := int(tup[2].(tuple.Desc).V.(int64))
+		if v >= prev || v%7 != 3 {
+			t.Fatalf("Got key %v after %d", tup, prev)
+		}
+		prev = v
+		return true
+	})
+	if prev != 3 {
+		t.Fatalf("Scan ended at %d instead of expected %d", prev, 3)
+	}
+}
+
+func TestEntryFile(t *testing.T) {
+	tree := NewBTree(4)
+	for _, k := range []string{"b", "a", "c"} {
+		tree.Insert(&Entry{Key: []byte(k), Value: []byte("v" + k)})
+	}
+	if e, _ := tree.Get(&Entry{Key: []byte("b")}).(*Entry); e == nil || string(e.Value) != "vb" {
+		t.Fatalf("Got entry %v for key b", e)
+	}
+	name := filepath.Join(t.TempDir(), "entries")
+	if err := tree.WriteFile(name, EncodeEntry); err != nil {
+		t.Fatalf("Failed to write tree file: %v", err)
+	}
+	f, err := Open(name)
+	if err != nil {
+		t.Fatalf("Failed to open tree file: %v", err)
+	}
+	defer f.Close()
+	if v, ok, err := f.Get([]byte("c")); !ok || err != nil || string(v) != "vc" {
+		t.Fatalf("Got value %q, found %v, error %v for key c in tree file", v, ok, err)
+	}
+}
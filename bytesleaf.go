@@ -0,0 +1,253 @@
+package btree
+
+import (
+	"bytes"
+	"sort"
+)
+
+// NewBytesBTree returns a tree of Bytes keys whose leaves store the prefix
+// shared by all of their keys once, keeping only the remaining suffix of each
+// key. It suits long keys with common prefixes, such as URLs or packed tuples.
+// It panics if d is less than 3.
+func NewBytesBTree(d uint) *BTree {
+	t, err := New(WithOrder(d), WithPrefixCompression())
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// splitKey returns the separator promoted when a leaf is split between the
+// keys lo and hi. Keys that can produce a shorter separator do so.
+func splitKey(lo, hi key) key {
+	if s, ok := hi.(interface{ separator(key) key }); ok {
+		return s.separator(lo)
+	}
+	return hi
+}
+
+func commonPrefix(a, b []byte) int {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
+
+type bytesLeafNode struct {
+	prefix         []byte
+	suffixes       [][]byte
+	next, previous *bytesLeafNode
+	cfg            *Config
+	last           int // position of the last insert, for SplitAdaptive
+	summed
+}
+
+func newBytesLeafNode(b uint) *bytesLeafNode {
+	return &bytesLeafNode{
+		suffixes: make([][]byte, 0, b),
+	}
+}
+
+func (n *bytesLeafNode) key(i int) Bytes {
+	k := make(Bytes, len(n.prefix)+len(n.suffixes[i]))
+	copy(k, n.prefix)
+	copy(k[len(n.prefix):], n.suffixes[i])
+	return k
+}
+
+func (n *bytesLeafNode) all() []Bytes {
+	ks := make([]Bytes, len(n.suffixes))
+	for i := range n.suffixes {
+		ks[i] = n.key(i)
+	}
+	return ks
+}
+
+// reset replaces the contents of the node with the sorted keys ks, storing
+// their longest common prefix once.
+func (n *bytesLeafNode) reset(ks []Bytes) {
+	clear(n.suffixes)
+	n.suffixes = n.suffixes[:0]
+	if len(ks) == 0 {
+		n.prefix = nil
+		return
+	}
+	l := commonPrefix(ks[0], ks[len(ks)-1])
+	n.prefix = append([]byte(nil), ks[0][:l]...)
+	for _, k := range ks {
+		n.suffixes = append(n.suffixes, append([]byte(nil), k[l:]...))
+	}
+}
+
+// shrinkPrefix shortens the shared prefix to its first l bytes, moving the
+// rest onto every suffix.
+func (n *bytesLeafNode) shrinkPrefix(l int) {
+	moved := n.prefix[l:]
+	for i, s := range n.suffixes {
+		ns := make([]byte, len(moved)+len(s))
+		copy(ns, moved)
+		copy(ns[len(moved):], s)
+		n.suffixes[i] = ns
+	}
+	n.prefix = n.prefix[:l]
+}
+
+func (n *bytesLeafNode) equal(i int, k Bytes) bool {
+	p := len(n.prefix)
+	return len(k) >= p && bytes.Equal(k[:p], n.prefix) && bytes.Equal(k[p:], n.suffixes[i])
+}
+
+func (n *bytesLeafNode) Insert(k key) {
+	kb := k.(Bytes)
+	if len(n.suffixes) == 0 {
+		n.prefix = append([]byte(nil), kb...)
+	} else if l := commonPrefix(n.prefix, kb); l < len(n.prefix) {
+		n.shrinkPrefix(l)
+	}
+	i := n.Search(k)
+	n.last = i
+	n.suffixes = append(n.suffixes, nil)
+	copy(n.suffixes[i+1:], n.suffixes[i:])
+	n.suffixes[i] = append([]byte(nil), kb[len(n.prefix):]...)
+	n.sum.add(n.cfg.hashKey(k))
+}
+
+func (n *bytesLeafNode) Remove(k key) {
+	i := n.Search(k)
+
+	if i < len(n.suffixes) && n.equal(i, k.(Bytes)) {
+		copy(n.suffixes[i:], n.suffixes[i+1:])
+		// Clear the vacated slot so that it does not keep its suffix alive.
+		n.suffixes[len(n.suffixes)-1] = nil
+		n.suffixes = n.suffixes[:len(n.suffixes)-1]
+		n.sum.sub(n.cfg.hashKey(k))
+	}
+}
+
+func (n *bytesLeafNode) Search(k key) int {
+	kb := k.(Bytes)
+	p := len(n.prefix)
+	if len(kb) < p || !bytes.Equal(kb[:p], n.prefix) {
+		// k is outside the range of keys sharing the prefix.
+		if bytes.Compare(kb, n.prefix) < 0 {
+			return 0
+		}
+		return len(n.suffixes)
+	}
+	s := kb[p:]
+	return sort.Search(len(n.suffixes), func(i int) bool { return bytes.Compare(n.suffixes[i], s) >= 0 })
+}
+
+func (n *bytesLeafNode) Get(k key) key {
+	i := n.Search(k)
+	if i == len(n.suffixes) || !n.equal(i, k.(Bytes)) {
+		return nil
+	}
+	return n.key(i)
+}
+
+func (n *bytesLeafNode) GetLowestLeaf() key {
+	return n.key(0)
+}
+
+func (n *bytesLeafNode) Keys() keys {
+	ks := make(keys, len(n.suffixes))
+	for i := range n.suffixes {
+		ks[i] = n.key(i)
+	}
+	return ks
+}
+
+func (n *bytesLeafNode) Less(o node) bool {
+	return n.key(len(n.suffixes) - 1).Less(o.GetLowestLeaf())
+}
+
+func (n *bytesLeafNode) Split() (key, node, node) {
+	if len(n.suffixes) < 2 {
+		corrupt("leaf node too small to split")
+	}
+
+	mid := n.cfg.splitIndex(len(n.suffixes), n.last, func() bool { return n.next == nil })
+	ks := n.all()
+	key := splitKey(ks[mid-1], ks[mid])
+
+	left := n.cfg.allocBytesLeaf(uint(cap(n.suffixes)))
+	left.previous, left.next = n.previous, n
+	if n.previous != nil {
+		n.previous.next = left
+	}
+	left.reset(ks[:mid])
+
+	right := n
+	right.previous = left
+	right.reset(ks[mid:])
+	n.cfg.shift(left, right, 0, mid)
+	return key, left, right
+}
+
+func (n *bytesLeafNode) Merge(parent key, toMerge node) key {
+	mn := asBytesLeafNode(toMerge)
+	// Linked siblings merge in list order; unlinked ones by their keys.
+	after := n.next == mn
+	if !after && n.previous != mn {
+		after = len(n.suffixes) == 0 || len(mn.suffixes) > 0 && n.Less(mn)
+	}
+	if after {
+		n.reset(append(n.all(), mn.all()...))
+		n.next = mn.next
+		if mn.next != nil {
+			mn.next.previous = n
+		}
+	} else {
+		n.reset(append(mn.all(), n.all()...))
+		n.previous = mn.previous
+		if mn.previous != nil {
+			mn.previous.next = n
+		}
+	}
+	n.sum.add(mn.sum)
+	return n.GetLowestLeaf()
+}
+
+// Rebalances to the tail of this node, removing items from the head of other.
+func (n *bytesLeafNode) RebalanceToTail(other node) key {
+	mn := asBytesLeafNode(other)
+	ks, oks := n.all(), mn.all()
+	moveIdx := rebalanceCount(len(oks), len(ks))
+	n.reset(append(ks, oks[:moveIdx]...))
+	mn.reset(oks[moveIdx:])
+	n.cfg.shift(n, mn, len(ks), len(ks)+moveIdx)
+	return mn.GetLowestLeaf()
+}
+
+// Rebalances to the head of this node, removing items from the tail of other.
+func (n *bytesLeafNode) RebalanceToHead(other node) key {
+	mn := asBytesLeafNode(other)
+	ks, oks := n.all(), mn.all()
+	moveIdx := len(oks) - rebalanceCount(len(oks), len(ks))
+	n.reset(append(oks[moveIdx:], ks...))
+	mn.reset(oks[:moveIdx])
+	n.cfg.shift(n, mn, 0, len(oks)-moveIdx)
+	return n.GetLowestLeaf()
+}
+
+func (n *bytesLeafNode) IsFull() bool {
+	return len(n.suffixes) == cap(n.suffixes)
+}
+
+func (n *bytesLeafNode) IsEmpty() bool {
+	return n.cfg.underfull(len(n.suffixes), cap(n.suffixes))
+}
+
+func (n *bytesLeafNode) CanMerge(other node) bool {
+	if o, ok := other.(*bytesLeafNode); ok {
+		return len(n.suffixes)+len(o.suffixes) <= cap(n.suffixes)
+	}
+	return false
+}
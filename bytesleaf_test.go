@@ -0,0 +1,127 @@
+package btree
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func testURL(i int) Bytes {
+	return Bytes(fmt.Sprintf("https://example.com/users/%06d/profile", i))
+}
+
+func TestBytesLeafInsert(t *testing.T) {
+	n := newBytesLeafNode(16)
+	for _, i := range rand.New(rand.NewSource(7)).Perm(16) {
+		n.Insert(testURL(i))
+	}
+
+	if string(n.prefix) != "https://example.com/users/0000" {
+		t.Fatalf("Got prefix %q instead of expected prefix %q", n.prefix, "https://example.com/users/0000")
+	}
+	for i, k := range n.Keys() {
+		if !bytes.Equal(k.(Bytes), testURL(i)) {
+			t.Fatalf("Got key %q instead of expected key %q at keys position %d", k, testURL(i), i)
+		}
+	}
+
+	n.Insert(Bytes("https://example.com/about"))
+	if string(n.prefix) != "https://example.com/" {
+		t.Fatalf("Got prefix %q instead of expected prefix %q", n.prefix, "https://example.com/")
+	}
+	if n.Get(testURL(3)) == nil || n.Get(Bytes("https://example.com/about")) == nil {
+		t.Fatalf("Failed to find inserted keys after the prefix shrank")
+	}
+	if n.Get(Bytes("https://example.com/")) != nil || n.Get(Bytes("ftp://x")) != nil {
+		t.Fatalf("Found keys that were never inserted")
+	}
+}
+
+func TestBytesLeafRemove(t *testing.T) {
+	n := newBytesLeafNode(16)
+	for i := 0; i < 4; i++ {
+		n.Insert(testURL(i))
+	}
+	n.Remove(testURL(1))
+	n.Remove(Bytes("https://example.com/missing"))
+
+	if len(n.suffixes) != 3 || n.Get(testURL(1)) != nil || n.Get(testURL(3)) == nil {
+		t.Fatalf("Got keys %q after removing %q", n.Keys(), testURL(1))
+	}
+	// The slot past the end no longer holds a suffix.
+	if s := n.suffixes[:4][3]; s != nil {
+		t.Fatalf("Removal left suffix %q past the end", s)
+	}
+}
+
+func TestBytesLeafSplit(t *testing.T) {
+	n := newBytesLeafNode(16)
+	for i := 0; i < 16; i++ {
+		n.Insert(Bytes(fmt.Sprintf("key/%02d/suffix", i)))
+	}
+
+	pk, l, r := n.Split()
+
+	// The separator is the shortest key between "key/07/suffix" and
+	// "key/08/suffix".
+	if string(pk.(Bytes)) != "key/08" {
+		t.Fatalf("Got separator %q instead of expected separator %q", pk, "key/08")
+	}
+	if string(l.(*bytesLeafNode).prefix) != "key/0" || string(r.(*bytesLeafNode).prefix) != "key/" {
+		t.Fatalf("Got prefixes %q and %q after split", l.(*bytesLeafNode).prefix, r.(*bytesLeafNode).prefix)
+	}
+	if l.(*bytesLeafNode).next != r || r.(*bytesLeafNode).previous != l {
+		t.Fatalf("Split halves are not linked to each other")
+	}
+}
+
+func TestBytesTreeInsert(t *testing.T) {
+	tree := NewBytesBTree(8)
+	perm := rand.New(rand.NewSource(3)).Perm(2000)
+	for _, i := range perm {
+		tree.Insert(testURL(i))
+	}
+	for i := 0; i < 2000; i++ {
+		if got := tree.Get(testURL(i)); got == nil || !bytes.Equal(got.(Bytes), testURL(i)) {
+			t.Fatalf("Got key %q instead of expected key %q", got, testURL(i))
+		}
+	}
+
+	var got []string
+	var walk func(n node)
+	walk = func(n node) {
+		switch n := n.(type) {
+		case *internalNode:
+			for _, k := range n.keys {
+				if len(k.(Bytes)) >= len(testURL(0)) {
+					t.Fatalf("Separator %q was not truncated", k)
+				}
+			}
+			for _, c := range n.nodes {
+				walk(c)
+			}
+		case *bytesLeafNode:
+			for _, k := range n.Keys() {
+				got = append(got, string(k.(Bytes)))
+			}
+		}
+	}
+	walk(tree.root)
+	if len(got) != 2000 || !sort.StringsAreSorted(got) {
+		t.Fatalf("Got %d keys from the leaves, sorted %v", len(got), sort.StringsAreSorted(got))
+	}
+}
+
+func BenchmarkBytesTreeInsert(b *testing.B) {
+	tree := NewBytesBTree(64)
+	keys := make([]Bytes, b.N)
+	for i := range keys {
+		keys[i] = testURL(i)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tree.Insert(keys[i])
+	}
+}
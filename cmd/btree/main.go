@@ -0,0 +1,321 @@
+// Command btree builds, inspects and queries tree files.
+//
+// Usage:
+//
+//	btree load [-format csv|jsonl] [-order n] INPUT FILE
+//	btree get FILE KEY
+//	btree scan [--from KEY] [--to KEY] [--limit n] FILE
+//	btree stats FILE
+//	btree validate FILE
+//	btree dump [--dot | --json] FILE
+//	btree compact FILE
+//
+// load reads key and value pairs from INPUT, or standard input if it is "-",
+// and writes them to FILE sorted by key. CSV rows hold a key and an optional
+// value; JSONL lines hold objects with a "key" string and any "value", stored
+// as the string itself or else as its JSON text. Later values of a key
+// replace earlier ones. Keys are compared as bytes.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/highlyunavailable/btree"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+const usage = `usage:
+	btree load [-format csv|jsonl] [-order n] INPUT FILE
+	btree get FILE KEY
+	btree scan [--from KEY] [--to KEY] [--limit n] FILE
+	btree stats FILE
+	btree validate FILE
+	btree dump [--dot | --json] FILE
+	btree compact FILE
+`
+
+// errNotFound makes get exit with status 1 without a message.
+var errNotFound = errors.New("key not found")
+
+// run runs the command in args and returns the exit status: 0 on success, 1
+// for a missing key and 2 for any other failure.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+
+	commands := map[string]func(fs *flag.FlagSet, args []string, stdin io.Reader, stdout io.Writer) error{
+		"load":     load,
+		"get":      get,
+		"scan":     scan,
+		"stats":    stats,
+		"validate": validate,
+		"dump":     dump,
+		"compact":  compact,
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "btree: unknown command %q\n%s", args[0], usage)
+		return 2
+	}
+
+	fs := flag.NewFlagSet("btree "+args[0], flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	out := bufio.NewWriter(stdout)
+	err := cmd(fs, args[1:], stdin, out)
+	if ferr := out.Flush(); err == nil {
+		err = ferr
+	}
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, errNotFound):
+		return 1
+	case errors.Is(err, flag.ErrHelp):
+		return 2
+	}
+	fmt.Fprintf(stderr, "btree %s: %v\n", args[0], err)
+	return 2
+}
+
+// parse parses the flags of a command, which must be followed by n
+// arguments.
+func parse(fs *flag.FlagSet, args []string, n int) ([]string, error) {
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != n {
+		return nil, fmt.Errorf("want %d arguments, got %d\n%s", n, fs.NArg(), usage)
+	}
+	return fs.Args(), nil
+}
+
+func load(fs *flag.FlagSet, args []string, stdin io.Reader, stdout io.Writer) error {
+	format := fs.String("format", "csv", "input `format`, csv or jsonl")
+	order := fs.Uint("order", btree.DefaultOrder, "`order` of the tree")
+	args, err := parse(fs, args, 2)
+	if err != nil {
+		return err
+	}
+
+	in := stdin
+	if args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	tree, err := btree.New(btree.WithOrder(*order))
+	if err != nil {
+		return err
+	}
+	add := func(k, v []byte) error {
+		_, err := tree.ReplaceOrInsert(&btree.Entry{Key: k, Value: v})
+		return err
+	}
+	switch *format {
+	case "csv":
+		err = loadCSV(in, add)
+	case "jsonl":
+		err = loadJSONL(in, add)
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	if err != nil {
+		return err
+	}
+	return tree.WriteFile(args[1], btree.EncodeEntry)
+}
+
+func loadCSV(r io.Reader, add func(k, v []byte) error) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var v []byte
+		switch len(rec) {
+		case 1:
+		case 2:
+			v = []byte(rec[1])
+		default:
+			line, _ := cr.FieldPos(0)
+			return fmt.Errorf("line %d: want a key and a value, got %d fields", line, len(rec))
+		}
+		if err := add([]byte(rec[0]), v); err != nil {
+			return err
+		}
+	}
+}
+
+func loadJSONL(r io.Reader, add func(k, v []byte) error) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<24)
+	for line := 1; sc.Scan(); line++ {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		var rec struct {
+			Key   *string         `json:"key"`
+			Value json.RawMessage `json:"value"`
+		}
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		if rec.Key == nil {
+			return fmt.Errorf("line %d: no key", line)
+		}
+		v := []byte(rec.Value)
+		var s string
+		if json.Unmarshal(rec.Value, &s) == nil {
+			v = []byte(s)
+		}
+		if err := add([]byte(*rec.Key), v); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+// open opens the tree file named by the only argument of a command.
+func open(fs *flag.FlagSet, args []string) (*btree.File, error) {
+	args, err := parse(fs, args, 1)
+	if err != nil {
+		return nil, err
+	}
+	return btree.Open(args[0])
+}
+
+func get(fs *flag.FlagSet, args []string, stdin io.Reader, stdout io.Writer) error {
+	args, err := parse(fs, args, 2)
+	if err != nil {
+		return err
+	}
+	f, err := btree.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	v, ok, err := f.Get([]byte(args[1]))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errNotFound
+	}
+	fmt.Fprintf(stdout, "%s\n", v)
+	return nil
+}
+
+func scan(fs *flag.FlagSet, args []string, stdin io.Reader, stdout io.Writer) error {
+	from := fs.String("from", "", "first `key` of the range")
+	to := fs.String("to", "", "`key` ending the range, exclusive")
+	limit := fs.Int("limit", 0, "print at most `n` entries")
+	f, err := open(fs, args)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var lo, hi []byte
+	fs.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "from":
+			lo = []byte(*from)
+		case "to":
+			hi = []byte(*to)
+		}
+	})
+	n := 0
+	return f.Range(lo, hi, func(k, v []byte) bool {
+		fmt.Fprintf(stdout, "%s\t%s\n", k, v)
+		n++
+		return *limit <= 0 || n < *limit
+	})
+}
+
+func stats(fs *flag.FlagSet, args []string, stdin io.Reader, stdout io.Writer) error {
+	f, err := open(fs, args)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	st, err := f.Stats()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "entries\t%d\n", st.Entries)
+	fmt.Fprintf(stdout, "leaf nodes\t%d\n", st.LeafNodes)
+	fmt.Fprintf(stdout, "internal nodes\t%d\n", st.InternalNodes)
+	fmt.Fprintf(stdout, "pages\t%d\n", st.Pages)
+	fmt.Fprintf(stdout, "used bytes\t%d\n", st.UsedBytes)
+	fmt.Fprintf(stdout, "fragmentation\t%s\n", strconv.FormatFloat(st.Fragmentation, 'f', 3, 64))
+	fmt.Fprintf(stdout, "free pages\t%d\n", st.FreePages)
+	return nil
+}
+
+func validate(fs *flag.FlagSet, args []string, stdin io.Reader, stdout io.Writer) error {
+	f, err := open(fs, args)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := f.Verify(); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "ok: %d entries\n", f.Len())
+	return nil
+}
+
+func dump(fs *flag.FlagSet, args []string, stdin io.Reader, stdout io.Writer) error {
+	dot := fs.Bool("dot", false, "write a Graphviz graph")
+	asJSON := fs.Bool("json", false, "write JSON")
+	f, err := open(fs, args)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	format := btree.DumpText
+	switch {
+	case *dot && *asJSON:
+		return errors.New("--dot and --json are exclusive")
+	case *dot:
+		format = btree.DumpDOT
+	case *asJSON:
+		format = btree.DumpJSON
+	}
+	return f.Dump(stdout, format)
+}
+
+func compact(fs *flag.FlagSet, args []string, stdin io.Reader, stdout io.Writer) error {
+	args, err := parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	return btree.CompactFile(args[0])
+}
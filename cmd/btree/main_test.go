@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runOK runs the command in args and returns its output, failing the test
+// unless it exits with status 0.
+func runOK(t *testing.T, stdin string, args ...string) string {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	if code := run(args, strings.NewReader(stdin), &stdout, &stderr); code != 0 {
+		t.Fatalf("btree %s exited with %d: %s", strings.Join(args, " "), code, stderr.String())
+	}
+	return stdout.String()
+}
+
+func TestLoadCSV(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "tree.btf")
+	input := "b,2\na,1\nc\n\"d,e\",\"x\ny\"\nb,3\n"
+	runOK(t, input, "load", "-order", "3", "-", name)
+
+	if got := runOK(t, "", "get", name, "b"); got != "3\n" {
+		t.Fatalf("get b printed %q", got)
+	}
+	var stderr bytes.Buffer
+	if code := run([]string{"get", name, "z"}, nil, &bytes.Buffer{}, &stderr); code != 1 || stderr.Len() != 0 {
+		t.Fatalf("get of a missing key exited with %d: %s", code, stderr.String())
+	}
+
+	want := "a\t1\nb\t3\nc\t\nd,e\tx\ny\n"
+	if got := runOK(t, "", "scan", name); got != want {
+		t.Fatalf("scan printed %q, want %q", got, want)
+	}
+	if got := runOK(t, "", "scan", "--from", "b", "--to", "d", name); got != "b\t3\nc\t\n" {
+		t.Fatalf("scan of [b, d) printed %q", got)
+	}
+	if got := runOK(t, "", "scan", "--limit", "1", name); got != "a\t1\n" {
+		t.Fatalf("scan with a limit printed %q", got)
+	}
+
+	if got := runOK(t, "", "validate", name); got != "ok: 4 entries\n" {
+		t.Fatalf("validate printed %q", got)
+	}
+	if got := runOK(t, "", "stats", name); !strings.HasPrefix(got, "entries\t4\n") {
+		t.Fatalf("stats printed %q", got)
+	}
+	if got := runOK(t, "", "dump", "--dot", name); !strings.HasPrefix(got, "digraph btree {") || !strings.Contains(got, `\"d,e\"`) {
+		t.Fatalf("dump --dot printed %q", got)
+	}
+	if got := runOK(t, "", "dump", name); !strings.HasPrefix(got, "internal 0 [") {
+		t.Fatalf("dump printed %q", got)
+	}
+
+	runOK(t, "", "compact", name)
+	if got := runOK(t, "", "scan", name); got != want {
+		t.Fatalf("scan after compact printed %q, want %q", got, want)
+	}
+	if got := runOK(t, "", "validate", name); got != "ok: 4 entries\n" {
+		t.Fatalf("validate after compact printed %q", got)
+	}
+}
+
+func TestLoadJSONL(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.jsonl")
+	data := `{"key": "user:2", "value": {"name": "b"}}
+{"key": "user:1", "value": "a"}
+
+{"key": "user:3"}
+`
+	if err := os.WriteFile(input, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(dir, "tree.btf")
+	runOK(t, "", "load", "-format", "jsonl", input, name)
+
+	want := "user:1\ta\nuser:2\t{\"name\": \"b\"}\nuser:3\t\n"
+	if got := runOK(t, "", "scan", name); got != want {
+		t.Fatalf("scan printed %q, want %q", got, want)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	dir := t.TempDir()
+	for _, args := range [][]string{
+		nil,
+		{"frobnicate"},
+		{"get", "only-one"},
+		{"load", "-format", "xml", "-", filepath.Join(dir, "x")},
+		{"load", "-", filepath.Join(dir, "x")},
+		{"validate", filepath.Join(dir, "missing")},
+	} {
+		var stderr bytes.Buffer
+		if code := run(args, strings.NewReader("a,b,c\n"), &bytes.Buffer{}, &stderr); code != 2 || stderr.Len() == 0 {
+			t.Fatalf("btree %v exited with %d: %q", args, code, stderr.String())
+		}
+	}
+}
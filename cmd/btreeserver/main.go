@@ -0,0 +1,42 @@
+// Command btreeserver serves an in-memory tree to Redis clients.
+//
+// Usage:
+//
+//	btreeserver [-addr host:port] [-order n]
+//
+// See package server for the commands it supports.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/highlyunavailable/btree"
+	"github.com/highlyunavailable/btree/server"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:6380", "TCP `address` to listen on")
+	order := flag.Uint("order", btree.DefaultOrder, "`order` of the tree")
+	flag.Parse()
+
+	tree, err := btree.New(btree.WithOrder(*order))
+	if err != nil {
+		log.Fatal(err)
+	}
+	s := server.New(tree)
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	go func() {
+		<-sig
+		s.Close()
+	}()
+
+	log.Printf("serving on %s", *addr)
+	if err := s.ListenAndServe(*addr); err != server.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
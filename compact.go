@@ -0,0 +1,310 @@
+package btree
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// FileStats describes how the pages of a tree file are used.
+type FileStats struct {
+	Entries       int
+	LeafNodes     int
+	InternalNodes int
+	Pages         int     // pages holding nodes
+	UsedBytes     int64   // bytes of node records within those pages
+	Fragmentation float64 // fraction of node pages not holding node data
+	FreePages     int     // pages on the free list of a Store's file
+}
+
+// Stats walks every node of the file and reports its page usage.
+func (f *File) Stats() (FileStats, error) {
+	var st FileStats
+	err := f.nodes(func(_ uint64, p filePage) error {
+		st.Pages += int(pages(len(p)))
+		st.UsedBytes += int64(len(p))
+		if p.kind() == leafPage {
+			st.LeafNodes++
+			st.Entries += p.count()
+		} else {
+			st.InternalNodes++
+		}
+		return nil
+	})
+	if err != nil {
+		return st, err
+	}
+	runs, err := f.freeRuns()
+	for _, r := range runs {
+		st.FreePages += int(r.n)
+	}
+	st.Fragmentation = 1 - float64(st.UsedBytes)/float64(st.Pages*pageSize)
+	return st, err
+}
+
+// nodes calls fn with every node of the file, a level at a time.
+func (f *File) nodes(fn func(off uint64, p filePage) error) error {
+	level := []uint64{f.root}
+	for len(level) > 0 {
+		var next []uint64
+		for _, off := range level {
+			p, err := f.page(off)
+			if err != nil {
+				return err
+			}
+			if err := fn(off, p); err != nil {
+				return err
+			}
+			if p.kind() == internalPage {
+				for i := 0; i <= p.count(); i++ {
+					next = append(next, p.child(i))
+				}
+			}
+		}
+		level = next
+	}
+	return nil
+}
+
+// freeRuns returns the runs on the free list of the file.
+func (f *File) freeRuns() ([]pageRun, error) {
+	if f.free == 0 {
+		return nil, nil
+	}
+	p, err := f.page(f.free)
+	if err != nil {
+		return nil, err
+	}
+	if p.kind() != freePage {
+		return nil, fmt.Errorf("%w: free list %d is not a free list", ErrCorrupt, f.free)
+	}
+	runs := make([]pageRun, p.count())
+	for i := range runs {
+		b := p.item(i)
+		if len(b) != 16 {
+			return nil, fmt.Errorf("%w: free list %d has a bad run", ErrCorrupt, f.free)
+		}
+		runs[i] = pageRun{off: binary.LittleEndian.Uint64(b), n: binary.LittleEndian.Uint64(b[8:])}
+	}
+	return runs, nil
+}
+
+// Verify reads every node of the file and checks their checksums, that the
+// keys of every node are in order and within the bounds set by their parents,
+// that the leaves are linked in order and that the file holds as many
+// entries as its footer says. For the files of a Store, whose leaves are not
+// linked, it checks instead that no page on the free list holds a node.
+func (f *File) Verify() error {
+	var prev filePage
+	var prevOff, count uint64
+	seen := map[uint64]bool{}
+
+	var walk func(off uint64, lo, hi []byte) error
+	walk = func(off uint64, lo, hi []byte) error {
+		if seen[off] {
+			return fmt.Errorf("%w: node %d reached twice", ErrCorrupt, off)
+		}
+		seen[off] = true
+		p, err := f.page(off)
+		if err != nil {
+			return err
+		}
+		for o := off + pageSize; o < off+pages(len(p))*pageSize; o += pageSize {
+			seen[o] = true
+		}
+		// Children hold keys from their left separator up to their right
+		// one, which splits of equal keys may leave in both children.
+		inBounds := func(k []byte) bool {
+			return (lo == nil || bytes.Compare(lo, k) <= 0) && (hi == nil || bytes.Compare(k, hi) <= 0)
+		}
+		step := 1
+		if p.kind() == leafPage {
+			step = 2
+		}
+		for i := 0; i < p.items(); i += step {
+			k := p.item(i)
+			if !inBounds(k) || i > 0 && bytes.Compare(p.item(i-step), k) > 0 {
+				return fmt.Errorf("%w: keys of node %d out of order", ErrCorrupt, off)
+			}
+		}
+
+		if p.kind() != leafPage && p.kind() != internalPage {
+			return fmt.Errorf("%w: node %d is not a node", ErrCorrupt, off)
+		}
+		if p.kind() == leafPage {
+			if f.linked && (p.previous() != prevOff || prev != nil && prev.next() != off) {
+				return fmt.Errorf("%w: leaf %d not linked to its neighbours", ErrCorrupt, off)
+			}
+			prev, prevOff = p, off
+			count += uint64(p.count())
+			return nil
+		}
+		for i := 0; i <= p.count(); i++ {
+			clo, chi := lo, hi
+			if i > 0 {
+				clo = p.item(i - 1)
+			}
+			if i < p.count() {
+				chi = p.item(i)
+			}
+			if err := walk(p.child(i), clo, chi); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := walk(f.root, nil, nil); err != nil {
+		return err
+	}
+	if f.linked && prev.next() != 0 {
+		return fmt.Errorf("%w: last leaf %d linked to %d", ErrCorrupt, prevOff, prev.next())
+	}
+	if count != f.count {
+		return fmt.Errorf("%w: %d entries, footer says %d", ErrCorrupt, count, f.count)
+	}
+
+	runs, err := f.freeRuns()
+	if err != nil {
+		return err
+	}
+	seen[f.free] = true
+	for _, r := range runs {
+		if r.off%pageSize != 0 || r.off+r.n*pageSize > uint64(len(f.data)) {
+			return fmt.Errorf("%w: free run %d outside the file", ErrCorrupt, r.off)
+		}
+		for off := r.off; off < r.off+r.n*pageSize; off += pageSize {
+			if seen[off] {
+				return fmt.Errorf("%w: page %d is in use and free", ErrCorrupt, off)
+			}
+		}
+	}
+	return nil
+}
+
+// CompactFile rewrites the named tree file with its entries packed densely
+// into sequential leaves, then replaces the file with the smaller copy. Files
+// already open keep reading the old contents until they are closed.
+func CompactFile(name string) error {
+	f, err := Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".compact")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := f.WriteCompact(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// WriteCompact writes the entries of the file to w as a new tree file whose
+// nodes are filled as far as a page allows.
+func (f *File) WriteCompact(w io.Writer) error {
+	recs, firsts, err := f.denseLeaves()
+	if err != nil {
+		return err
+	}
+
+	fw := &fileWriter{w: bufio.NewWriter(w)}
+	fw.header()
+	offsets := fw.leaves(recs)
+	root, _ := denseLevels(offsets, firsts, func(rec *pageRecord) (uint64, error) {
+		return fw.record(rec), nil
+	})
+
+	fw.footer(root, f.count)
+	if fw.err != nil {
+		return fw.err
+	}
+	return fw.w.Flush()
+}
+
+// denseLeaves packs the entries of the file into leaves filled as far as a
+// page allows, and returns them with the first key of each.
+func (f *File) denseLeaves() (recs []*pageRecord, firsts [][]byte, err error) {
+	rec := &pageRecord{kind: leafPage}
+	err = f.Range(nil, nil, func(k, v []byte) bool {
+		if rec.count() > 0 && (rec.count() == 0xffff || rec.size()+8+len(k)+len(v) > pageSize) {
+			recs = append(recs, rec)
+			rec = &pageRecord{kind: leafPage}
+		}
+		if rec.count() == 0 {
+			firsts = append(firsts, k)
+		}
+		rec.items = append(rec.items, k, v)
+		return true
+	})
+	return append(recs, rec), firsts, err
+}
+
+// denseLevels builds the internal levels over the leaves at offsets, whose
+// first keys are firsts, filling each node as far as a page allows. It writes
+// nodes with write and returns the offset of the root.
+func denseLevels(offsets []uint64, firsts [][]byte, write func(*pageRecord) (uint64, error)) (uint64, error) {
+	for len(offsets) > 1 {
+		var recs []*pageRecord
+		var nextFirsts [][]byte
+		for i := 0; i < len(offsets); {
+			start := i
+			rec := &pageRecord{kind: internalPage, children: offsets[i : i+1]}
+			nextFirsts = append(nextFirsts, firsts[i])
+			// Separators are the first key of every child but the first. Each
+			// node takes at least two children so that the levels shrink.
+			for i++; i < len(offsets) && rec.count() < 0xffff; i++ {
+				if len(rec.children) > 1 && rec.size()+12+len(firsts[i]) > pageSize {
+					break
+				}
+				rec.items = append(rec.items, firsts[i])
+				rec.children = offsets[start : i+1]
+			}
+			recs = append(recs, rec)
+		}
+		// A child left over at the end joins the node before it, even past a
+		// page, unless that node is out of separators, in which case it
+		// hands over its own last child.
+		if n := len(recs); n > 1 && len(recs[n-1].children) == 1 {
+			prev, last := recs[n-2], recs[n-1]
+			if prev.count() < 0xffff {
+				prev.items = append(prev.items, nextFirsts[n-1])
+				prev.children = offsets[len(offsets)-len(prev.children)-1:]
+				recs, nextFirsts = recs[:n-1], nextFirsts[:n-1]
+			} else {
+				k := len(prev.children) - 1
+				nextFirsts[n-1] = prev.items[k-1]
+				last.items = [][]byte{firsts[len(offsets)-1]}
+				last.children = offsets[len(offsets)-2:]
+				prev.items, prev.children = prev.items[:k-1], prev.children[:k]
+			}
+		}
+
+		next := make([]uint64, len(recs))
+		for i, rec := range recs {
+			off, err := write(rec)
+			if err != nil {
+				return 0, err
+			}
+			next[i] = off
+		}
+		offsets, firsts = next, nextFirsts
+	}
+	return offsets[0], nil
+}
@@ -0,0 +1,197 @@
+package btree
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"math/rand"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestFileStats(t *testing.T) {
+	tree := NewBTree(4)
+	for i := 0; i < 100; i++ {
+		tree.Insert(&testKey{value: i})
+	}
+	f, err := Open(writeTestFile(t, tree))
+	if err != nil {
+		t.Fatalf("Failed to open tree file: %v", err)
+	}
+	defer f.Close()
+
+	st, err := f.Stats()
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	if st.Entries != 100 || st.Pages != st.LeafNodes+st.InternalNodes {
+		t.Fatalf("Got unexpected stats %+v", st)
+	}
+	if st.Fragmentation < 0.9 {
+		t.Fatalf("Got fragmentation %v for nodes of a few keys each", st.Fragmentation)
+	}
+}
+
+func TestCompactFile(t *testing.T) {
+	tree := NewBTree(8)
+	for i := 0; i < 20000; i++ {
+		tree.Insert(&testKey{value: i})
+	}
+	for i := 0; i < 20000; i++ {
+		if i%5 != 0 {
+			tree.Remove(&testKey{value: i})
+		}
+	}
+	name := writeTestFile(t, tree)
+	before, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old, err := Open(name)
+	if err != nil {
+		t.Fatalf("Failed to open tree file: %v", err)
+	}
+	defer old.Close()
+
+	if err := CompactFile(name); err != nil {
+		t.Fatalf("Failed to compact tree file: %v", err)
+	}
+	after, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Size() >= before.Size()/10 {
+		t.Fatalf("Compacted file is %d bytes, down from %d", after.Size(), before.Size())
+	}
+
+	f, err := Open(name)
+	if err != nil {
+		t.Fatalf("Failed to open compacted file: %v", err)
+	}
+	defer f.Close()
+
+	st, err := f.Stats()
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	if st.Entries != 4000 || f.Len() != 4000 || st.Fragmentation > 0.1 {
+		t.Fatalf("Got unexpected stats %+v for the compacted file", st)
+	}
+
+	for _, file := range []*File{f, old} {
+		for i := 0; i < 20000; i += 7 {
+			v, ok, err := file.Get(testFileKey(i))
+			if err != nil || ok != (i%5 == 0) || ok && string(v) != strconv.Itoa(i) {
+				t.Fatalf("Got value %q, found %v, error %v for key %d", v, ok, err, i)
+			}
+		}
+	}
+	n := 0
+	f.Range(nil, nil, func(k, v []byte) bool {
+		if string(v) != strconv.Itoa(n*5) {
+			t.Fatalf("Got value %q instead of expected value %d", v, n*5)
+		}
+		n++
+		return true
+	})
+	if n != 4000 {
+		t.Fatalf("Got %d entries from the compacted file instead of expected %d", n, 4000)
+	}
+}
+
+func TestFileVerify(t *testing.T) {
+	tree := NewBTree(4)
+	for _, v := range rand.New(rand.NewSource(1)).Perm(2000) {
+		tree.Insert(&testKey{value: v})
+	}
+	for i := 0; i < 2000; i += 3 {
+		tree.Remove(&testKey{value: i})
+	}
+	name := writeTestFile(t, tree)
+	f, err := Open(name)
+	if err != nil {
+		t.Fatalf("Failed to open tree file: %v", err)
+	}
+	if err := f.Verify(); err != nil {
+		t.Fatalf("Verify of a written file failed: %v", err)
+	}
+	f.Close()
+
+	if err := CompactFile(name); err != nil {
+		t.Fatalf("Failed to compact tree file: %v", err)
+	}
+	if f, err = Open(name); err != nil {
+		t.Fatalf("Failed to open tree file: %v", err)
+	}
+	if err := f.Verify(); err != nil {
+		t.Fatalf("Verify of a compacted file failed: %v", err)
+	}
+	f.Close()
+
+	// A footer with the wrong entry count, but a valid checksum, passes Open.
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ft := data[len(data)-footerSize:]
+	binary.LittleEndian.PutUint64(ft[8:], binary.LittleEndian.Uint64(ft[8:])+1)
+	binary.LittleEndian.PutUint32(ft[24:], crc32.Checksum(ft[:24], castagnoli))
+	if f, err = newFile(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Verify(); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("Verify of a file with a wrong count returned %v", err)
+	}
+
+	// As does a flipped byte, until its node is read.
+	data[pageSize+pageHeaderSize+20] ^= 0xff
+	if f, err = newFile(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Verify(); err != ErrCorrupt {
+		t.Fatalf("Verify of a file with a damaged node returned %v", err)
+	}
+}
+
+func TestDenseLevels(t *testing.T) {
+	// Keys of this size fit five children to a node, so eleven leaves leave
+	// one over at the end of the first level.
+	var offsets []uint64
+	var firsts [][]byte
+	for i := 0; i < 11; i++ {
+		offsets = append(offsets, uint64(i))
+		firsts = append(firsts, make([]byte, 1000))
+	}
+	var recs []*pageRecord
+	root, err := denseLevels(offsets, firsts, func(rec *pageRecord) (uint64, error) {
+		recs = append(recs, rec)
+		return uint64(100 + len(recs) - 1), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root != uint64(100+len(recs)-1) {
+		t.Fatalf("denseLevels returned root %d, want the last node written", root)
+	}
+	var leaves []uint64
+	for _, rec := range recs {
+		if len(rec.children) < 2 || len(rec.items) != len(rec.children)-1 {
+			t.Fatalf("Internal node has %d children and %d separators", len(rec.children), len(rec.items))
+		}
+		for _, c := range rec.children {
+			if c < 100 {
+				leaves = append(leaves, c)
+			}
+		}
+	}
+	for i, c := range leaves {
+		if c != uint64(i) {
+			t.Fatalf("Leaves are reached as %v", leaves)
+		}
+	}
+	if len(leaves) != len(offsets) {
+		t.Fatalf("Reached %d of %d leaves", len(leaves), len(offsets))
+	}
+}
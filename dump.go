@@ -0,0 +1,243 @@
+package btree
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// A DumpFormat is a way of drawing the structure of a tree.
+type DumpFormat int
+
+const (
+	// DumpText writes one line per node, indented by depth.
+	DumpText DumpFormat = iota
+	// DumpDOT writes a Graphviz graph, with internal nodes showing their
+	// separators, leaves their keys and dashed edges for the links between
+	// leaves.
+	DumpDOT
+	// DumpJSON writes the nodes as nested JSON objects.
+	DumpJSON
+)
+
+// WithKeyFormatter sets how Dump writes keys. By default Bytes and String
+// keys are quoted and other keys are formatted with fmt.Sprint.
+func WithKeyFormatter(f func(Key) string) Option {
+	return func(c *Config) { c.KeyFormatter = f }
+}
+
+func (c *Config) formatKey(k key) string {
+	if c.KeyFormatter != nil {
+		return c.KeyFormatter(k)
+	}
+	switch k := k.(type) {
+	case Bytes:
+		return strconv.Quote(string(k))
+	case String:
+		return strconv.Quote(string(k))
+	}
+	return fmt.Sprint(k)
+}
+
+// Dump writes the structure of the tree to w in format, to help see what
+// a tree looks like. Nodes are numbered in depth-first order.
+func (t *BTree) Dump(w io.Writer, format DumpFormat) error {
+	if err := t.tryDrain(); err != nil {
+		return err
+	}
+	ids := map[node]int{}
+	var leaves []node
+	var build func(n node) *dumpNode
+	build = func(n node) *dumpNode {
+		dn := &dumpNode{ID: len(ids), Kind: "leaf", Keys: []string{}}
+		ids[n] = dn.ID
+		in, ok := n.(*internalNode)
+		if !ok {
+			for i := 0; i < leafLen(n); i++ {
+				dn.Keys = append(dn.Keys, t.cfg.formatKey(leafAt(n, i)))
+			}
+			leaves = append(leaves, n)
+			return dn
+		}
+		dn.Kind = "internal"
+		for _, k := range in.keys {
+			dn.Keys = append(dn.Keys, t.cfg.formatKey(k))
+		}
+		for _, c := range in.nodes {
+			dn.Children = append(dn.Children, build(c))
+		}
+		return dn
+	}
+	root := build(t.root)
+
+	link := func(n node) *int {
+		if id, ok := ids[n]; ok {
+			return &id
+		}
+		return nil
+	}
+	for i, l := range root.leaves() {
+		l.Next, l.Previous = link(nextLeaf(leaves[i])), link(previousLeaf(leaves[i]))
+	}
+	return root.render(w, format)
+}
+
+// dumpNode is a node of a tree or tree file as Dump draws it. Next and
+// Previous are the IDs of the leaves linked to a leaf.
+type dumpNode struct {
+	ID       int         `json:"id"`
+	Kind     string      `json:"kind"`
+	Keys     []string    `json:"keys"`
+	Children []*dumpNode `json:"children,omitempty"`
+	Next     *int        `json:"next,omitempty"`
+	Previous *int        `json:"previous,omitempty"`
+}
+
+// leaves returns the leaves under dn, in order.
+func (dn *dumpNode) leaves() []*dumpNode {
+	if dn.Kind == "leaf" {
+		return []*dumpNode{dn}
+	}
+	var ls []*dumpNode
+	for _, c := range dn.Children {
+		ls = append(ls, c.leaves()...)
+	}
+	return ls
+}
+
+func (dn *dumpNode) render(w io.Writer, format DumpFormat) error {
+	bw := bufio.NewWriter(w)
+	switch format {
+	case DumpText:
+		dn.text(bw, 0)
+	case DumpDOT:
+		dn.dot(bw)
+	case DumpJSON:
+		enc := json.NewEncoder(bw)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(dn); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("btree: unknown dump format %d", format)
+	}
+	return bw.Flush()
+}
+
+func (dn *dumpNode) text(w io.Writer, depth int) {
+	fmt.Fprintf(w, "%s%s %d [%s]\n", strings.Repeat("  ", depth), dn.Kind, dn.ID, strings.Join(dn.Keys, " "))
+	for _, c := range dn.Children {
+		c.text(w, depth+1)
+	}
+}
+
+// dotEscape escapes the characters with a meaning in DOT record labels.
+var dotEscape = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `|`, `\|`, `{`, `\{`, `}`, `\}`, `<`, `\<`, `>`, `\>`)
+
+func (dn *dumpNode) dot(w io.Writer) {
+	fmt.Fprintln(w, "digraph btree {")
+	fmt.Fprintln(w, "\tnode [shape=record];")
+
+	var walk func(dn *dumpNode)
+	walk = func(dn *dumpNode) {
+		ks := make([]string, len(dn.Keys))
+		for i, k := range dn.Keys {
+			ks[i] = dotEscape.Replace(k)
+		}
+		if dn.Kind == "leaf" {
+			fmt.Fprintf(w, "\tn%d [label=\"%s\"];\n", dn.ID, strings.Join(ks, "|"))
+			return
+		}
+
+		// Each child hangs from the port between the separators around it.
+		var label strings.Builder
+		for i := range dn.Children {
+			if i > 0 {
+				fmt.Fprintf(&label, "|%s|", ks[i-1])
+			}
+			fmt.Fprintf(&label, "<c%d>", i)
+		}
+		fmt.Fprintf(w, "\tn%d [label=\"%s\"];\n", dn.ID, label.String())
+		for i, c := range dn.Children {
+			walk(c)
+			fmt.Fprintf(w, "\tn%d:c%d -> n%d;\n", dn.ID, i, c.ID)
+		}
+	}
+	walk(dn)
+
+	leaves := dn.leaves()
+	for _, l := range leaves {
+		if l.Next != nil {
+			fmt.Fprintf(w, "\tn%d -> n%d [style=dashed, constraint=false];\n", l.ID, *l.Next)
+		}
+		if l.Previous != nil {
+			fmt.Fprintf(w, "\tn%d -> n%d [style=dashed, color=gray, constraint=false];\n", l.ID, *l.Previous)
+		}
+	}
+	fmt.Fprint(w, "\t{rank=same;")
+	for _, l := range leaves {
+		fmt.Fprintf(w, " n%d;", l.ID)
+	}
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, "}")
+}
+
+// Dump writes the structure of the file to w in format, like BTree.Dump.
+// Keys are quoted and nodes are numbered in depth-first order.
+func (f *File) Dump(w io.Writer, format DumpFormat) error {
+	ids := map[uint64]int{}
+	var leaves []filePage
+	var build func(off uint64) (*dumpNode, error)
+	build = func(off uint64) (*dumpNode, error) {
+		if _, ok := ids[off]; ok {
+			return nil, fmt.Errorf("%w: node %d reached twice", ErrCorrupt, off)
+		}
+		p, err := f.page(off)
+		if err != nil {
+			return nil, err
+		}
+		dn := &dumpNode{ID: len(ids), Kind: "leaf", Keys: []string{}}
+		ids[off] = dn.ID
+		if p.kind() == leafPage {
+			for i := 0; i < p.count(); i++ {
+				dn.Keys = append(dn.Keys, strconv.Quote(string(p.item(2*i))))
+			}
+			leaves = append(leaves, p)
+			return dn, nil
+		}
+		dn.Kind = "internal"
+		for i := 0; i < p.count(); i++ {
+			dn.Keys = append(dn.Keys, strconv.Quote(string(p.item(i))))
+		}
+		for i := 0; i <= p.count(); i++ {
+			c, err := build(p.child(i))
+			if err != nil {
+				return nil, err
+			}
+			dn.Children = append(dn.Children, c)
+		}
+		return dn, nil
+	}
+	root, err := build(f.root)
+	if err != nil {
+		return err
+	}
+
+	// Stores leave the links of the leaves they have not rewritten pointing
+	// at pages that may since have been freed, so only linked files have any.
+	link := func(off uint64) *int {
+		if id, ok := ids[off]; ok && off != 0 {
+			return &id
+		}
+		return nil
+	}
+	if f.linked {
+		for i, l := range root.leaves() {
+			l.Next, l.Previous = link(leaves[i].next()), link(leaves[i].previous())
+		}
+	}
+	return root.render(w, format)
+}
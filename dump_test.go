@@ -0,0 +1,138 @@
+package btree
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDump(t *testing.T) {
+	tree, err := New(WithOrder(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for v := 1; v <= 6; v++ {
+		tree.Insert(Int64(v))
+	}
+
+	var buf bytes.Buffer
+	if err := tree.Dump(&buf, DumpText); err != nil {
+		t.Fatal(err)
+	}
+	want := "internal 0 [3 5]\n  leaf 1 [1 2]\n  leaf 2 [3 4]\n  leaf 3 [5 6]\n"
+	if buf.String() != want {
+		t.Fatalf("Text dump:\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	buf.Reset()
+	if err := tree.Dump(&buf, DumpDOT); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`n0 [label="<c0>|3|<c1>|5|<c2>"];`,
+		`n3 [label="5|6"];`,
+		`n0:c1 -> n2;`,
+		`n1 -> n2 [style=dashed, constraint=false];`,
+		`n3 -> n2 [style=dashed, color=gray, constraint=false];`,
+	} {
+		if !strings.Contains(buf.String(), "\t"+line+"\n") {
+			t.Fatalf("DOT dump lacks %q:\n%s", line, buf.String())
+		}
+	}
+
+	buf.Reset()
+	if err := tree.Dump(&buf, DumpJSON); err != nil {
+		t.Fatal(err)
+	}
+	var root dumpNode
+	if err := json.Unmarshal(buf.Bytes(), &root); err != nil {
+		t.Fatal(err)
+	}
+	if root.Kind != "internal" || len(root.Children) != 3 || strings.Join(root.Children[2].Keys, ",") != "5,6" {
+		t.Fatalf("JSON dump: %s", buf.String())
+	}
+}
+
+func TestDumpKeyFormatter(t *testing.T) {
+	tree, err := New(WithKeyFormatter(func(k Key) string { return "<" + string(k.(String)) + ">" }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree.Insert(String("a|b"))
+
+	var buf bytes.Buffer
+	tree.Dump(&buf, DumpDOT)
+	if !strings.Contains(buf.String(), `n0 [label="\<a\|b\>"];`) {
+		t.Fatalf("DOT labels not escaped:\n%s", buf.String())
+	}
+	buf.Reset()
+	tree.Dump(&buf, DumpText)
+	if buf.String() != "leaf 0 [<a|b>]\n" {
+		t.Fatalf("Text dump: %q", buf.String())
+	}
+
+	if err := tree.Dump(&buf, DumpFormat(-1)); err == nil {
+		t.Fatalf("Dump accepted an unknown format")
+	}
+}
+
+func TestFileDump(t *testing.T) {
+	tree := NewBTree(3)
+	for v := 1; v <= 6; v++ {
+		tree.Insert(&testKey{value: v})
+	}
+	f, err := Open(writeTestFile(t, tree))
+	if err != nil {
+		t.Fatalf("Failed to open tree file: %v", err)
+	}
+	defer f.Close()
+
+	// The file has the shape of the tree, with its keys encoded.
+	var want, got bytes.Buffer
+	tree, _ = New(WithOrder(3), WithKeyFormatter(func(k Key) string {
+		kb, _ := encodeTestKey(k)
+		return strconv.Quote(string(kb))
+	}))
+	for v := 1; v <= 6; v++ {
+		tree.Insert(&testKey{value: v})
+	}
+	tree.Dump(&want, DumpDOT)
+	if err := f.Dump(&got, DumpDOT); err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != want.String() {
+		t.Fatalf("File dump:\n%s\nwant:\n%s", got.String(), want.String())
+	}
+}
+
+func TestStoreDump(t *testing.T) {
+	tree := NewBTree(3)
+	for v := 1; v <= 20; v++ {
+		tree.Insert(&testKey{value: v})
+	}
+	s, err := OpenStore(writeTestFile(t, tree))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.Put(testFileKey(1000), []byte("1000")); err != nil {
+		t.Fatal(err)
+	}
+	v, err := s.View()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+
+	// The leaves left from the written file keep their old links, which the
+	// dump does not draw.
+	var buf bytes.Buffer
+	if err := v.Dump(&buf, DumpJSON); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), `"next"`) || strings.Contains(buf.String(), `"previous"`) {
+		t.Fatalf("Store dump links its leaves:\n%s", buf.String())
+	}
+}
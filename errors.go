@@ -0,0 +1,89 @@
+package btree
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+var (
+	// ErrInvalidOrder is returned by New for orders too small to split nodes.
+	ErrInvalidOrder = errors.New("btree: order must be at least 3")
+
+	// ErrInvalidFill is returned by New for a minimum fill outside [0, 0.5].
+	ErrInvalidFill = errors.New("btree: minimum fill must be between 0 and 0.5")
+
+	// ErrKeyTypeMismatch is returned when a key is not of the same type as
+	// the keys already in the tree.
+	ErrKeyTypeMismatch = errors.New("btree: key type does not match the tree")
+
+	// ErrUnsorted is returned when a batch of keys is not in ascending order.
+	ErrUnsorted = errors.New("btree: batch keys are not sorted")
+
+	// ErrInvalidLimit is returned by New for negative size or buffer limits,
+	// or a memory budget without a way to size keys, and by NewQueue for any
+	// size limit.
+	ErrInvalidLimit = errors.New("btree: invalid size limit")
+
+	// ErrNotHashed is returned by RootHash, RangeHash and Diff for trees not
+	// built WithMerkle.
+	ErrNotHashed = errors.New("btree: tree does not hash its keys")
+
+	// ErrClosed is returned by the methods of a closed Store.
+	ErrClosed = errors.New("btree: store is closed")
+
+	// ErrCorrupt is returned when a tree or tree file fails validation.
+	ErrCorrupt = errors.New("btree: corrupt tree")
+)
+
+// corrupt panics with an error wrapping ErrCorrupt. Nodes report broken
+// invariants this way; the tree's public methods recover the panic with catch
+// and return the error.
+func corrupt(msg string) {
+	panic(fmt.Errorf("%w: %s", ErrCorrupt, msg))
+}
+
+// catch recovers a panic raised by corrupt into *err. Any other panic is
+// passed on.
+func catch(err *error) {
+	if r := recover(); r != nil {
+		if e, ok := r.(error); ok && errors.Is(e, ErrCorrupt) {
+			*err = e
+			return
+		}
+		panic(r)
+	}
+}
+
+// checkKey makes sure k is of the same type as the keys in the tree, so that
+// comparing it with them cannot fail.
+func (t *BTree) checkKey(k key) error {
+	if k == nil || t.keyType != nil && reflect.TypeOf(k) != t.keyType {
+		return ErrKeyTypeMismatch
+	}
+	return nil
+}
+
+func asInternalNode(n node) *internalNode {
+	in, ok := n.(*internalNode)
+	if !ok {
+		corrupt("expected an internal node")
+	}
+	return in
+}
+
+func asLeafNode(n node) *leafNode {
+	ln, ok := n.(*leafNode)
+	if !ok {
+		corrupt("expected a leaf node")
+	}
+	return ln
+}
+
+func asBytesLeafNode(n node) *bytesLeafNode {
+	ln, ok := n.(*bytesLeafNode)
+	if !ok {
+		corrupt("expected a Bytes leaf node")
+	}
+	return ln
+}
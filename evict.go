@@ -0,0 +1,169 @@
+package btree
+
+// accesses tracks the uses of the keys of a tree with a size limit. Like the
+// deadlines of expiring keys, they are indexed twice: by key, and by how cold
+// each key is, so that the key to evict is always the first of byRank.
+type accesses struct {
+	policy EvictionPolicy
+	tick   uint64 // counts uses, to order them in time
+	n      int
+	bytes  int
+	byKey  *BTree // of accessKeys
+	byRank *BTree // of rankKeys
+}
+
+// access records the uses of a key.
+type access struct {
+	uses, tick uint64
+	size       int
+}
+
+// accessKey orders the accesses of keys by key. Its access is shared with
+// the tree, so that uses update it in place.
+type accessKey struct {
+	k key
+	a *access
+}
+
+func (a accessKey) Less(other key) bool {
+	return a.k.Less(other.(accessKey).k)
+}
+
+func (a accessKey) Compare(other key) int {
+	return a.k.Compare(other.(accessKey).k)
+}
+
+// rankKey orders keys from the coldest: by uses under EvictLFU, then by the
+// time of their last use. Ticks are unique, so they break every tie.
+type rankKey struct {
+	uses, tick uint64
+	k          key
+}
+
+func (r rankKey) Less(other key) bool {
+	return r.Compare(other) < 0
+}
+
+func (r rankKey) Compare(other key) int {
+	o := other.(rankKey)
+	if r.uses != o.uses {
+		return compareOrdered(Uint64(r.uses), Uint64(o.uses))
+	}
+	return compareOrdered(Uint64(r.tick), Uint64(o.tick))
+}
+
+func newAccesses(policy EvictionPolicy) *accesses {
+	byKey, _ := New()
+	byRank, _ := New()
+	return &accesses{policy: policy, byKey: byKey, byRank: byRank}
+}
+
+func (x *accesses) get(k key) *access {
+	if a, ok := x.byKey.Get(accessKey{k: k}).(accessKey); ok {
+		return a.a
+	}
+	return nil
+}
+
+func (x *accesses) rank(k key, a *access) rankKey {
+	r := rankKey{tick: a.tick, k: k}
+	if x.policy == EvictLFU {
+		r.uses = a.uses
+	}
+	return r
+}
+
+// track starts tracking k, a key of the given size just added to the tree.
+func (x *accesses) track(k key, size int) {
+	x.tick++
+	a := &access{uses: 1, tick: x.tick, size: size}
+	x.byKey.Insert(accessKey{k: k, a: a})
+	x.byRank.Insert(x.rank(k, a))
+	x.n++
+	x.bytes += size
+}
+
+// touch records a use of k.
+func (x *accesses) touch(k key) {
+	if x == nil {
+		return
+	}
+	a := x.get(k)
+	if a == nil {
+		return
+	}
+	x.byRank.Remove(x.rank(k, a))
+	x.tick++
+	a.uses++
+	a.tick = x.tick
+	x.byRank.Insert(x.rank(k, a))
+}
+
+// forget stops tracking k, if it is tracked.
+func (x *accesses) forget(k key) {
+	if x == nil {
+		return
+	}
+	a := x.get(k)
+	if a == nil {
+		return
+	}
+	x.byKey.Remove(accessKey{k: k})
+	x.byRank.Remove(x.rank(k, a))
+	x.n--
+	x.bytes -= a.size
+}
+
+// coldest returns the key to evict first, or nil if no key is tracked.
+func (x *accesses) coldest() key {
+	leaf := x.byRank.endLeaf(false)
+	if leafLen(leaf) == 0 {
+		return nil
+	}
+	return leafAt(leaf, 0).(rankKey).k
+}
+
+// forget drops what the tree knows of k besides k itself, once k is removed.
+func (t *BTree) forget(k key) {
+	t.expiries.forget(k)
+	t.accesses.forget(k)
+}
+
+// insertBounded adds k to a tree with a size limit, evicting the coldest
+// keys first to make room for it. A key larger than the whole memory budget
+// is evicted in turn, after all the others.
+func (t *BTree) insertBounded(k key) error {
+	x := t.accesses
+	if t.lookup(k) != nil {
+		x.touch(k)
+		return nil
+	}
+	if err := t.insert(k); err != nil {
+		return err
+	}
+	size := 0
+	if t.cfg.KeySize != nil {
+		size = t.cfg.KeySize(k)
+	}
+	for x.n > 0 && t.cfg.overLimit(x.n+1, x.bytes+size) {
+		if err := t.evict(x.coldest()); err != nil {
+			return err
+		}
+	}
+	x.track(k, size)
+	t.notify(EventInsert, nil, k)
+	if t.cfg.overLimit(x.n, x.bytes) {
+		return t.evict(k)
+	}
+	return nil
+}
+
+func (t *BTree) evict(k key) error {
+	if err := t.Remove(k); err != nil {
+		return err
+	}
+	if t.cfg.OnEvict != nil {
+		t.cfg.OnEvict(k)
+	}
+	return nil
+}
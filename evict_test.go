@@ -0,0 +1,114 @@
+package btree
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// treeKeys returns the keys of an Int64 tree in order.
+func treeKeys(tree *BTree) []int {
+	var vs []int
+	for it := tree.Scan(nil, nil); it.Next(); {
+		vs = append(vs, int(it.Key().(Int64)))
+	}
+	return vs
+}
+
+func TestEvictLRU(t *testing.T) {
+	var evicted []int
+	tree, err := New(WithOrder(4), WithMaxKeys(5), WithOnEvict(func(k Key) {
+		evicted = append(evicted, int(k.(Int64)))
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for v := 0; v < 5; v++ {
+		tree.Insert(Int64(v))
+	}
+	// Using 0 and 1 leaves 2 and 3 the coldest; reinserting 2 uses it too.
+	tree.Get(Int64(0))
+	tree.Get(Int64(1))
+	tree.Insert(Int64(2))
+	tree.Insert(Int64(10))
+	tree.Insert(Int64(11))
+
+	if want := []int{3, 4}; !reflect.DeepEqual(evicted, want) {
+		t.Fatalf("Evicted %v, want %v", evicted, want)
+	}
+	if got, want := treeKeys(tree), []int{0, 1, 2, 10, 11}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Tree holds %v, want %v", got, want)
+	}
+
+	// Removed keys no longer count against the limit.
+	tree.Remove(Int64(0))
+	tree.Insert(Int64(12))
+	if len(evicted) != 2 || tree.accesses.n != 5 {
+		t.Fatalf("Evicted %v with %d keys tracked", evicted, tree.accesses.n)
+	}
+
+	// Many more inserts keep the tree at its limit.
+	for v := 100; v < 1000; v++ {
+		tree.Insert(Int64(v))
+	}
+	if got, want := treeKeys(tree), []int{995, 996, 997, 998, 999}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Tree holds %v, want %v", got, want)
+	}
+}
+
+func TestEvictLFU(t *testing.T) {
+	tree, err := New(WithMaxKeys(3), WithEviction(EvictLFU))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for v := 0; v < 3; v++ {
+		tree.Insert(Int64(v))
+	}
+	for i := 0; i < 3; i++ {
+		tree.Get(Int64(0))
+	}
+	tree.Get(Int64(1))
+	tree.Get(Int64(2))
+	tree.Get(Int64(1))
+
+	// 2 has fewer uses than 1 and 0, although it was used more recently.
+	tree.Insert(Int64(3))
+	if got, want := treeKeys(tree), []int{0, 1, 3}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Tree holds %v, want %v", got, want)
+	}
+	// 3 is now the least used.
+	tree.Insert(Int64(4))
+	if got, want := treeKeys(tree), []int{0, 1, 4}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Tree holds %v, want %v", got, want)
+	}
+}
+
+func TestEvictMaxBytes(t *testing.T) {
+	var evicted []Key
+	tree, err := New(
+		WithMaxBytes(10, func(k Key) int { return len(k.(String)) }),
+		WithOnEvict(func(k Key) { evicted = append(evicted, k) }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []String{"aaaa", "bbb", "cc"} {
+		tree.Insert(s)
+	}
+	tree.Insert(String("dddd"))
+	if want := []Key{String("aaaa")}; !reflect.DeepEqual(evicted, want) {
+		t.Fatalf("Evicted %v, want %v", evicted, want)
+	}
+	// A key over the whole budget evicts everything, then itself.
+	tree.Insert(String("eeeeeeeeeeee"))
+	if len(evicted) != 5 || tree.Get(String("cc")) != nil || tree.accesses.bytes != 0 {
+		t.Fatalf("Evicted %v, leaving %d bytes", evicted, tree.accesses.bytes)
+	}
+
+	if _, err := New(WithMaxBytes(10, nil)); !errors.Is(err, ErrInvalidLimit) {
+		t.Fatalf("New without a key size returned %v", err)
+	}
+	if _, err := New(WithMaxKeys(-1)); !errors.Is(err, ErrInvalidLimit) {
+		t.Fatalf("New with a negative limit returned %v", err)
+	}
+}
@@ -0,0 +1,613 @@
+package btree
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"sync/atomic"
+)
+
+// Tree files hold an immutable copy of a tree. Every node is stored as a
+// record starting on a page boundary:
+//
+//	0  crc32c of bytes [4:size)
+//	4  size of the record in bytes
+//	8  kind (leafPage or internalPage), 1 byte of padding, key count
+//	12 reserved
+//	16 leaf:     previous and next leaf offsets, then 2*count end offsets
+//	             of the interleaved keys and values in the data area
+//	   internal: count+1 child offsets, then count end offsets of the
+//	             separator keys in the data area
+//
+// The first page holds the file header and a footer holding the root offset
+// follows the last node, so a file can be written in a single pass. Offset 0
+// is never a node and marks a missing sibling.
+//
+// Files changed by a Store also hold two meta records in the first page,
+// written in turn by its commits, and the newer valid one takes the place of
+// the footer. Their free list is a record of kind freePage whose items are
+// runs of free pages, each an offset and a page count. Their leaves are not
+// linked, and scans step from one leaf to the next through the separators
+// above them.
+const (
+	pageSize    = 4096
+	fileMagic   = 0x46525442 // "BTRF"
+	fileVersion = 1
+
+	leafPage     = 1
+	internalPage = 2
+	freePage     = 3
+
+	pageHeaderSize = 16
+	fileHeaderSize = 8
+	footerSize     = 32
+	metaOffset     = 64
+	metaSize       = 48
+)
+
+var (
+	errUnordered = errors.New("btree: encoded keys are not in ascending order")
+	errTooLarge  = errors.New("btree: node too large for tree file")
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// An Encoder returns the key and value bytes stored in a tree file for k.
+// Encoded keys must sort with bytes.Compare in the same order as the keys
+// they were encoded from.
+type Encoder func(k key) (kb, vb []byte)
+
+// WriteFile writes the tree to the named file, replacing it if it exists.
+func (t *BTree) WriteFile(name string, enc Encoder) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := t.Write(f, enc); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Write writes the tree to w in the tree file format.
+func (t *BTree) Write(w io.Writer, enc Encoder) error {
+	if err := t.tryDrain(); err != nil {
+		return err
+	}
+	levels := []nodes{{t.root}}
+	for {
+		if _, ok := levels[len(levels)-1][0].(*internalNode); !ok {
+			break
+		}
+		var next nodes
+		for _, p := range levels[len(levels)-1] {
+			next = append(next, p.(*internalNode).nodes...)
+		}
+		levels = append(levels, next)
+	}
+
+	fw := &fileWriter{w: bufio.NewWriter(w)}
+	fw.header()
+
+	// Leaves are written first so that each level knows the offsets of the
+	// level below it; the root ends up last.
+	leaves := levels[len(levels)-1]
+	recs := make([]*pageRecord, len(leaves))
+	var last []byte
+	var count uint64
+	for i, n := range leaves {
+		ks := n.Keys()
+		rec := &pageRecord{kind: leafPage}
+		for _, k := range ks {
+			kb, vb := enc(k)
+			if last != nil && bytes.Compare(last, kb) >= 0 {
+				return errUnordered
+			}
+			last = kb
+			rec.items = append(rec.items, kb, vb)
+		}
+		count += uint64(len(ks))
+		recs[i] = rec
+	}
+	offsets := fw.leaves(recs)
+
+	for l := len(levels) - 2; l >= 0; l-- {
+		child := 0
+		recs = recs[:0]
+		for _, p := range levels[l] {
+			n := p.(*internalNode)
+			rec := &pageRecord{kind: internalPage}
+			for _, k := range n.keys {
+				kb, _ := enc(k)
+				rec.items = append(rec.items, kb)
+			}
+			rec.children = offsets[child : child+len(n.nodes)]
+			child += len(n.nodes)
+			recs = append(recs, rec)
+		}
+		next := make([]uint64, len(recs))
+		for i, rec := range recs {
+			next[i] = fw.record(rec)
+		}
+		offsets = next
+	}
+
+	fw.footer(offsets[0], count)
+	if fw.err != nil {
+		return fw.err
+	}
+	return fw.w.Flush()
+}
+
+type pageRecord struct {
+	kind     uint8
+	items    [][]byte
+	children []uint64
+	prev     uint64
+	next     uint64
+}
+
+func (r *pageRecord) size() int {
+	size := pageHeaderSize + 4*len(r.items)
+	switch r.kind {
+	case leafPage:
+		size += 16
+	case internalPage:
+		size += 8 * len(r.children)
+	}
+	for _, it := range r.items {
+		size += len(it)
+	}
+	return size
+}
+
+func (r *pageRecord) count() int {
+	if r.kind == leafPage {
+		return len(r.items) / 2
+	}
+	return len(r.items)
+}
+
+func pages(size int) uint64 {
+	return uint64((size + pageSize - 1) / pageSize)
+}
+
+type fileWriter struct {
+	w   *bufio.Writer
+	off uint64
+	err error
+}
+
+func (fw *fileWriter) write(b []byte) {
+	if fw.err != nil {
+		return
+	}
+	_, fw.err = fw.w.Write(b)
+	fw.off += uint64(len(b))
+}
+
+func (fw *fileWriter) pad() {
+	if rem := fw.off % pageSize; rem != 0 {
+		fw.write(make([]byte, pageSize-rem))
+	}
+}
+
+func (fw *fileWriter) header() {
+	var hdr [fileHeaderSize]byte
+	binary.LittleEndian.PutUint32(hdr[0:], fileMagic)
+	binary.LittleEndian.PutUint32(hdr[4:], fileVersion)
+	fw.write(hdr[:])
+	fw.pad()
+}
+
+// leaves writes a run of sibling leaves, linking each to its neighbours.
+func (fw *fileWriter) leaves(recs []*pageRecord) []uint64 {
+	offsets := make([]uint64, len(recs))
+	off := fw.off
+	for i, rec := range recs {
+		offsets[i] = off
+		off += pages(rec.size()) * pageSize
+	}
+	for i, rec := range recs {
+		if i > 0 {
+			rec.prev = offsets[i-1]
+		}
+		if i < len(recs)-1 {
+			rec.next = offsets[i+1]
+		}
+		fw.record(rec)
+	}
+	return offsets
+}
+
+func (fw *fileWriter) record(rec *pageRecord) uint64 {
+	off := fw.off
+	b, err := rec.encode()
+	if err != nil {
+		if fw.err == nil {
+			fw.err = err
+		}
+		return off
+	}
+	fw.write(b)
+	fw.pad()
+	return off
+}
+
+// encode returns the bytes of the record, checksum included.
+func (rec *pageRecord) encode() ([]byte, error) {
+	size := rec.size()
+	if rec.count() > 0xffff || uint64(size) > math.MaxUint32 {
+		return nil, errTooLarge
+	}
+
+	b := make([]byte, size)
+	b[8] = rec.kind
+	binary.LittleEndian.PutUint16(b[10:], uint16(rec.count()))
+	p := pageHeaderSize
+	if rec.kind == leafPage {
+		binary.LittleEndian.PutUint64(b[p:], rec.prev)
+		binary.LittleEndian.PutUint64(b[p+8:], rec.next)
+		p += 16
+	} else {
+		for _, c := range rec.children {
+			binary.LittleEndian.PutUint64(b[p:], c)
+			p += 8
+		}
+	}
+	data := p + 4*len(rec.items)
+	end := 0
+	for _, it := range rec.items {
+		copy(b[data+end:], it)
+		end += len(it)
+		binary.LittleEndian.PutUint32(b[p:], uint32(end))
+		p += 4
+	}
+	binary.LittleEndian.PutUint32(b[4:], uint32(size))
+	binary.LittleEndian.PutUint32(b[0:], crc32.Checksum(b[4:], castagnoli))
+	return b, nil
+}
+
+func (fw *fileWriter) footer(root, count uint64) {
+	var ft [footerSize]byte
+	binary.LittleEndian.PutUint64(ft[0:], root)
+	binary.LittleEndian.PutUint64(ft[8:], count)
+	binary.LittleEndian.PutUint32(ft[20:], fileVersion)
+	binary.LittleEndian.PutUint32(ft[24:], crc32.Checksum(ft[:24], castagnoli))
+	binary.LittleEndian.PutUint32(ft[28:], fileMagic)
+	fw.write(ft[:])
+}
+
+// meta is a commit of a Store, which it writes to one of two slots in the
+// first page of its file:
+//
+//	0  transaction number, counting from 1
+//	8  root offset
+//	16 entry count
+//	24 free list offset, or 0 if no page is free
+//	32 end of the pages in use
+//	40 crc32c of bytes [0:40)
+//	44 fileMagic
+type meta struct {
+	txn, root, count, free, end uint64
+}
+
+func (m meta) encode() []byte {
+	b := make([]byte, metaSize)
+	for i, v := range []uint64{m.txn, m.root, m.count, m.free, m.end} {
+		binary.LittleEndian.PutUint64(b[8*i:], v)
+	}
+	binary.LittleEndian.PutUint32(b[40:], crc32.Checksum(b[:40], castagnoli))
+	binary.LittleEndian.PutUint32(b[44:], fileMagic)
+	return b
+}
+
+// latestMeta returns the newer valid meta record of a file, if it has one.
+func latestMeta(data []byte) (meta, bool) {
+	var m meta
+	for slot := 0; slot < 2; slot++ {
+		b := data[metaOffset+slot*metaSize:][:metaSize]
+		if binary.LittleEndian.Uint32(b[44:]) != fileMagic ||
+			binary.LittleEndian.Uint32(b[40:]) != crc32.Checksum(b[:40], castagnoli) {
+			continue
+		}
+		var s meta
+		for i, v := range []*uint64{&s.txn, &s.root, &s.count, &s.free, &s.end} {
+			*v = binary.LittleEndian.Uint64(b[8*i:])
+		}
+		if s.txn > m.txn {
+			m = s
+		}
+	}
+	return m, m.txn > 0
+}
+
+// File is a read-only tree file mapped into memory. Lookups and scans work
+// directly on the mapped pages and do not allocate; the byte slices they
+// return alias the mapping and are only valid until Close.
+//
+// Page checksums are verified the first time a node is visited. A File is
+// safe for concurrent use.
+type File struct {
+	data     []byte
+	root     uint64
+	count    uint64
+	free     uint64 // offset of the free list of a Store's file, or 0
+	linked   bool   // whether leaves link to their siblings
+	verified []uint32
+	unmap    func() error
+}
+
+// Open maps the named tree file into memory.
+func Open(name string) (*File, error) {
+	data, unmap, err := mmapFile(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := newFile(data)
+	if err != nil {
+		unmap()
+		return nil, err
+	}
+	f.unmap = unmap
+	return f, nil
+}
+
+func newFile(data []byte) (*File, error) {
+	if len(data) < pageSize+footerSize ||
+		binary.LittleEndian.Uint32(data[0:]) != fileMagic ||
+		binary.LittleEndian.Uint32(data[4:]) != fileVersion {
+		return nil, ErrCorrupt
+	}
+	if m, ok := latestMeta(data); ok {
+		if m.end < 2*pageSize || m.end%pageSize != 0 || m.end > uint64(len(data)) {
+			return nil, ErrCorrupt
+		}
+		return metaFile(data, m), nil
+	}
+	ft := data[len(data)-footerSize:]
+	if binary.LittleEndian.Uint32(ft[28:]) != fileMagic ||
+		binary.LittleEndian.Uint32(ft[24:]) != crc32.Checksum(ft[:24], castagnoli) {
+		return nil, ErrCorrupt
+	}
+	npages := (len(data) - footerSize + pageSize - 1) / pageSize
+	return &File{
+		data:     data[:len(data)-footerSize],
+		root:     binary.LittleEndian.Uint64(ft[0:]),
+		count:    binary.LittleEndian.Uint64(ft[8:]),
+		linked:   true,
+		verified: make([]uint32, (npages+31)/32),
+	}, nil
+}
+
+// metaFile returns the tree of data committed by m.
+func metaFile(data []byte, m meta) *File {
+	return &File{
+		data:     data[:m.end],
+		root:     m.root,
+		count:    m.count,
+		free:     m.free,
+		verified: make([]uint32, (m.end/pageSize+31)/32),
+	}
+}
+
+// Close unmaps the file. Slices previously returned by the File must not be
+// used after Close.
+func (f *File) Close() error {
+	if f.unmap == nil {
+		return nil
+	}
+	err := f.unmap()
+	f.unmap = nil
+	f.data = nil
+	return err
+}
+
+// Len returns the number of entries in the file.
+func (f *File) Len() int {
+	return int(f.count)
+}
+
+// Get returns the value stored for k.
+func (f *File) Get(k []byte) (v []byte, ok bool, err error) {
+	p, _, err := f.findLeaf(k)
+	if err != nil {
+		return nil, false, err
+	}
+	i := p.search(k)
+	if i == p.count() || !bytes.Equal(p.item(2*i), k) {
+		return nil, false, nil
+	}
+	return p.item(2*i + 1), true, nil
+}
+
+// Range calls fn for each entry with lo <= key < hi, in ascending order,
+// until fn returns false. A nil lo or hi leaves that end of the range open.
+func (f *File) Range(lo, hi []byte, fn func(k, v []byte) bool) error {
+	p, bound, err := f.findLeaf(lo)
+	if err != nil {
+		return err
+	}
+	i := 0
+	if lo != nil {
+		i = p.search(lo)
+	}
+	for {
+		for ; i < p.count(); i++ {
+			k := p.item(2 * i)
+			if hi != nil && bytes.Compare(k, hi) >= 0 {
+				return nil
+			}
+			if !fn(k, p.item(2*i+1)) {
+				return nil
+			}
+		}
+		switch {
+		case f.linked && p.next() != 0:
+			p, err = f.page(p.next())
+		case !f.linked && bound != nil:
+			p, bound, err = f.findLeaf(bound)
+		default:
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		i = 0
+	}
+}
+
+// findLeaf returns the leaf where k belongs, and the separator bounding its
+// keys from above, or nil if it is the last leaf.
+func (f *File) findLeaf(k []byte) (p filePage, bound []byte, err error) {
+	p, err = f.page(f.root)
+	for err == nil && p.kind() == internalPage {
+		i := p.route(k)
+		if i < p.count() {
+			bound = p.item(i)
+		}
+		p, err = f.page(p.child(i))
+	}
+	if err == nil && p.kind() != leafPage {
+		err = ErrCorrupt
+	}
+	return p, bound, err
+}
+
+// page returns the node record at off, verifying its checksum on first use.
+func (f *File) page(off uint64) (filePage, error) {
+	if off%pageSize != 0 || off == 0 || off+pageHeaderSize > uint64(len(f.data)) {
+		return nil, ErrCorrupt
+	}
+	size := uint64(binary.LittleEndian.Uint32(f.data[off+4:]))
+	if size < pageHeaderSize || off+size > uint64(len(f.data)) {
+		return nil, ErrCorrupt
+	}
+	p := filePage(f.data[off : off+size])
+
+	idx := off / pageSize
+	word, bit := &f.verified[idx/32], uint32(1)<<(idx%32)
+	if atomic.LoadUint32(word)&bit == 0 {
+		if crc32.Checksum(p[4:], castagnoli) != binary.LittleEndian.Uint32(p) || !p.valid() {
+			return nil, ErrCorrupt
+		}
+		for {
+			old := atomic.LoadUint32(word)
+			if atomic.CompareAndSwapUint32(word, old, old|bit) {
+				break
+			}
+		}
+	}
+	return p, nil
+}
+
+// filePage is a node record within a mapped tree file.
+type filePage []byte
+
+func (p filePage) kind() uint8 { return p[8] }
+func (p filePage) count() int  { return int(binary.LittleEndian.Uint16(p[10:])) }
+
+func (p filePage) items() int {
+	if p.kind() == leafPage {
+		return 2 * p.count()
+	}
+	return p.count()
+}
+
+func (p filePage) ends() int {
+	switch p.kind() {
+	case leafPage:
+		return pageHeaderSize + 16
+	case internalPage:
+		return pageHeaderSize + 8*(p.count()+1)
+	}
+	return pageHeaderSize
+}
+
+// valid checks that the record's layout fits within its size. It is run once
+// alongside the checksum so that later accesses need no bounds checks of
+// their own.
+func (p filePage) valid() bool {
+	if k := p.kind(); k != leafPage && k != internalPage && k != freePage {
+		return false
+	}
+	data := p.ends() + 4*p.items()
+	if data > len(p) {
+		return false
+	}
+	prev := 0
+	for i := 0; i < p.items(); i++ {
+		end := int(binary.LittleEndian.Uint32(p[p.ends()+4*i:]))
+		if end < prev || data+end > len(p) {
+			return false
+		}
+		prev = end
+	}
+	return true
+}
+
+func (p filePage) item(i int) []byte {
+	ends := p.ends()
+	data := ends + 4*p.items()
+	start := 0
+	if i > 0 {
+		start = int(binary.LittleEndian.Uint32(p[ends+4*(i-1):]))
+	}
+	end := int(binary.LittleEndian.Uint32(p[ends+4*i:]))
+	return p[data+start : data+end]
+}
+
+func (p filePage) child(i int) uint64 {
+	return binary.LittleEndian.Uint64(p[pageHeaderSize+8*i:])
+}
+
+func (p filePage) previous() uint64 {
+	return binary.LittleEndian.Uint64(p[pageHeaderSize:])
+}
+
+func (p filePage) next() uint64 {
+	return binary.LittleEndian.Uint64(p[pageHeaderSize+8:])
+}
+
+// route returns the index of the child of an internal node where k belongs:
+// the first whose separator is greater than k, mirroring
+// internalNode.searchKNIndex. A nil k is the lowest key.
+func (p filePage) route(k []byte) int {
+	lo, hi := 0, p.count()
+	if k == nil {
+		return 0
+	}
+	for lo < hi {
+		mid := int(uint(lo+hi) >> 1)
+		if bytes.Compare(p.item(mid), k) <= 0 {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo
+}
+
+// search returns the index of the first key in a leaf that is not less than k.
+func (p filePage) search(k []byte) int {
+	lo, hi := 0, p.count()
+	for lo < hi {
+		mid := int(uint(lo+hi) >> 1)
+		if bytes.Compare(p.item(2*mid), k) < 0 {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo
+}
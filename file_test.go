@@ -0,0 +1,181 @@
+package btree
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func encodeTestKey(k key) ([]byte, []byte) {
+	v := k.(*testKey).value
+	kb := make([]byte, 8)
+	binary.BigEndian.PutUint64(kb, uint64(v)^1<<63)
+	return kb, []byte(strconv.Itoa(v))
+}
+
+func testFileKey(v int) []byte {
+	kb, _ := encodeTestKey(&testKey{value: v})
+	return kb
+}
+
+func writeTestFile(t *testing.T, tree *BTree) string {
+	name := filepath.Join(t.TempDir(), "tree.btf")
+	if err := tree.WriteFile(name, encodeTestKey); err != nil {
+		t.Fatalf("Failed to write tree file: %v", err)
+	}
+	return name
+}
+
+func TestFileGet(t *testing.T) {
+	tree := NewBTree(8)
+	for i := 0; i < 1000; i += 2 {
+		tree.Insert(&testKey{value: i})
+	}
+	f, err := Open(writeTestFile(t, tree))
+	if err != nil {
+		t.Fatalf("Failed to open tree file: %v", err)
+	}
+	defer f.Close()
+
+	if f.Len() != 500 {
+		t.Fatalf("Got length %d instead of expected length %d", f.Len(), 500)
+	}
+	for i := -1; i < 1001; i++ {
+		v, ok, err := f.Get(testFileKey(i))
+		if err != nil {
+			t.Fatalf("Get of %d failed: %v", i, err)
+		}
+		if ok != (i >= 0 && i < 1000 && i%2 == 0) {
+			t.Fatalf("Got found %v for key %d", ok, i)
+		}
+		if ok && string(v) != strconv.Itoa(i) {
+			t.Fatalf("Got value %q instead of expected value %q", v, strconv.Itoa(i))
+		}
+	}
+}
+
+func TestFileRange(t *testing.T) {
+	tree := NewBTree(4)
+	for i := 0; i < 200; i++ {
+		tree.Insert(&testKey{value: i})
+	}
+	f, err := Open(writeTestFile(t, tree))
+	if err != nil {
+		t.Fatalf("Failed to open tree file: %v", err)
+	}
+	defer f.Close()
+
+	want := 50
+	err = f.Range(testFileKey(50), testFileKey(150), func(k, v []byte) bool {
+		if string(v) != strconv.Itoa(want) {
+			t.Fatalf("Got value %q instead of expected value %d", v, want)
+		}
+		want++
+		return true
+	})
+	if err != nil {
+		t.Fatalf("Range failed: %v", err)
+	}
+	if want != 150 {
+		t.Fatalf("Range stopped at %d instead of expected %d", want, 150)
+	}
+
+	n := 0
+	f.Range(nil, nil, func(k, v []byte) bool {
+		n++
+		return true
+	})
+	if n != 200 {
+		t.Fatalf("Got %d entries from an open range, expected %d", n, 200)
+	}
+}
+
+func TestFileEmpty(t *testing.T) {
+	f, err := Open(writeTestFile(t, NewBTree(4)))
+	if err != nil {
+		t.Fatalf("Failed to open tree file: %v", err)
+	}
+	defer f.Close()
+
+	if _, ok, err := f.Get(testFileKey(1)); ok || err != nil {
+		t.Fatalf("Got found %v, error %v from an empty file", ok, err)
+	}
+}
+
+func TestFileCorrupt(t *testing.T) {
+	tree := NewBTree(4)
+	for i := 0; i < 100; i++ {
+		tree.Insert(&testKey{value: i})
+	}
+	name := writeTestFile(t, tree)
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Flip a byte inside the first leaf, which follows the header page.
+	data[pageSize+pageHeaderSize+20] ^= 0xff
+	if err := os.WriteFile(name, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := Open(name)
+	if err != nil {
+		t.Fatalf("Failed to open tree file: %v", err)
+	}
+	defer f.Close()
+
+	if _, _, err := f.Get(testFileKey(99)); err != nil {
+		t.Fatalf("Get from an intact leaf failed: %v", err)
+	}
+	if _, _, err := f.Get(testFileKey(0)); err != ErrCorrupt {
+		t.Fatalf("Got error %v instead of expected error %v", err, ErrCorrupt)
+	}
+}
+
+func TestFileGetAllocs(t *testing.T) {
+	tree := NewBTree(16)
+	for i := 0; i < 1000; i++ {
+		tree.Insert(&testKey{value: i})
+	}
+	f, err := Open(writeTestFile(t, tree))
+	if err != nil {
+		t.Fatalf("Failed to open tree file: %v", err)
+	}
+	defer f.Close()
+
+	k := testFileKey(500)
+	allocs := testing.AllocsPerRun(100, func() {
+		f.Get(k)
+		f.Range(k, nil, func(k, v []byte) bool { return false })
+	})
+	if allocs != 0 {
+		t.Fatalf("Got %v allocations per lookup, expected none", allocs)
+	}
+}
+
+func BenchmarkFileGet(b *testing.B) {
+	tree := NewBTree(64)
+	for i := 0; i < 100000; i++ {
+		tree.Insert(&testKey{value: i})
+	}
+	name := filepath.Join(b.TempDir(), "tree.btf")
+	if err := tree.WriteFile(name, encodeTestKey); err != nil {
+		b.Fatal(err)
+	}
+	f, err := Open(name)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer f.Close()
+
+	keys := make([][]byte, 100000)
+	for i := range keys {
+		keys[i] = testFileKey(i)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f.Get(keys[i%len(keys)])
+	}
+}
@@ -0,0 +1,3 @@
+module github.com/highlyunavailable/btree
+
+go 1.22
@@ -0,0 +1,137 @@
+package btree
+
+// A Hint remembers the path from the root to the leaf of the last operation
+// that used it, so that the next operation near the same keys can start from
+// that leaf, or from the closest ancestor whose range holds its key, instead
+// of from the root. Any operation without the hint that might reshape the
+// tree makes the hint start over from the root. The zero value is ready to
+// use, and a Hint may move between trees.
+type Hint struct {
+	tree    *BTree
+	version uint64
+	path    []hintLevel
+}
+
+// hintLevel is a node of a Hint's path with the bounds lo <= k < hi of the
+// keys below it. A nil bound leaves that end open.
+type hintLevel struct {
+	n      node
+	lo, hi key
+}
+
+func (l *hintLevel) contains(k key) bool {
+	return (l.lo == nil || !k.Less(l.lo)) && (l.hi == nil || k.Less(l.hi))
+}
+
+// locate returns the leaf that holds k, starting from the deepest node of the
+// hint's path whose range holds k.
+func (h *Hint) locate(t *BTree, k key) node {
+	if h.tree != t || h.version != t.version || len(h.path) == 0 {
+		h.tree, h.version = t, t.version
+		h.path = append(h.path[:0], hintLevel{n: t.root})
+	}
+	i := len(h.path) - 1
+	for i > 0 && !h.path[i].contains(k) {
+		i--
+	}
+	h.path = h.path[:i+1]
+
+	for {
+		l := h.path[len(h.path)-1]
+		n, ok := l.n.(*internalNode)
+		if !ok {
+			return l.n
+		}
+		_, nIdx := n.searchKNIndex(k)
+		child := hintLevel{n: n.nodes[nIdx], lo: l.lo, hi: l.hi}
+		if nIdx > 0 {
+			child.lo = n.keys[nIdx-1]
+		}
+		if nIdx < len(n.keys) {
+			child.hi = n.keys[nIdx]
+		}
+		h.path = append(h.path, child)
+	}
+}
+
+// grew adds what the leaf of the hint has gained since its sum was before
+// to the sums of the internal nodes above it.
+func (h *Hint) grew(before nodeSum) {
+	leaf := h.path[len(h.path)-1].n
+	d := leaf.sums().since(before)
+	for _, l := range h.path[:len(h.path)-1] {
+		l.n.sums().add(d)
+	}
+}
+
+// InsertHint adds k to the tree like Insert, starting from the leaf
+// remembered by h. Inserts that fit in that leaf without splitting it leave
+// the hint valid.
+func (t *BTree) InsertHint(k key, h *Hint) (err error) {
+	if err := t.checkKey(k); err != nil {
+		return err
+	}
+	if t.keyType == nil || t.accesses != nil || t.cfg.buffering() {
+		return t.Insert(k)
+	}
+	defer catch(&err)
+
+	leaf := h.locate(t, k)
+	if leafLen(leaf)+1 >= leafCap(leaf) {
+		return t.Insert(k)
+	}
+	before := *leaf.sums()
+	leaf.Insert(k)
+	h.grew(before)
+	t.notify(EventInsert, nil, k)
+	return nil
+}
+
+// RemoveHint removes k from the tree like Remove, starting from the leaf
+// remembered by h. Removals that leave that leaf full enough to need no
+// merging leave the hint valid.
+func (t *BTree) RemoveHint(k key, h *Hint) (err error) {
+	if err := t.checkKey(k); err != nil {
+		return err
+	}
+	if t.cfg.buffering() {
+		return t.Remove(k)
+	}
+	defer catch(&err)
+
+	leaf := h.locate(t, k)
+	if n := leafLen(leaf); len(h.path) > 1 {
+		short := n <= 1
+		if t.cfg.mergePolicy() == MergeEager {
+			short = short || t.cfg.underfull(n-1, leafCap(leaf))
+		}
+		if short {
+			return t.Remove(k)
+		}
+	}
+	old := t.watched(k)
+	before := *leaf.sums()
+	leaf.Remove(k)
+	h.grew(before)
+	t.forget(k)
+	if old != nil {
+		t.notify(EventDelete, old, nil)
+	}
+	return nil
+}
+
+// GetHint returns the key in the tree equal to k like Get, starting from the
+// leaf remembered by h.
+func (t *BTree) GetHint(k key, h *Hint) key {
+	if t.checkKey(k) != nil {
+		return nil
+	}
+	if t.cfg.buffering() {
+		return t.Get(k)
+	}
+	if k = h.locate(t, k).Get(k); k != nil && !t.live(k) {
+		return nil
+	}
+	t.accesses.touch(k)
+	return k
+}
@@ -0,0 +1,111 @@
+package btree
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestHintRandomInsertRemove(t *testing.T) {
+	for _, policy := range []MergePolicy{MergeEager, MergeLazy} {
+		for _, d := range []uint{3, 4, 8, 16} {
+			r := rand.New(rand.NewSource(int64(d)))
+			tree, err := New(WithOrder(d), WithMergePolicy(policy))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var h Hint
+			want := make(map[int]bool)
+			v := 0
+			for i := 0; i < 5000; i++ {
+				// Walk around the keys so that most operations are near the
+				// previous one, with an occasional jump and unhinted insert.
+				v = (v + r.Intn(7) - 3 + 500) % 500
+				if r.Intn(50) == 0 {
+					v = r.Intn(500)
+				}
+				switch {
+				case r.Intn(100) == 0:
+					if !want[v] {
+						tree.Insert(&testKey{value: v})
+						want[v] = true
+					}
+				case r.Intn(2) == 0:
+					if !want[v] {
+						tree.InsertHint(&testKey{value: v}, &h)
+						want[v] = true
+					}
+				default:
+					tree.RemoveHint(&testKey{value: v}, &h)
+					delete(want, v)
+				}
+				if k := tree.GetHint(&testKey{value: v}, &h); (k != nil) != want[v] {
+					t.Fatalf("Got key %v for key %d, expected it to be present: %v", k, v, want[v])
+				}
+				if i%50 == 0 {
+					checkTree(t, tree, want)
+				}
+			}
+			checkTree(t, tree, want)
+		}
+	}
+}
+
+func TestHintReusesPath(t *testing.T) {
+	tree := NewBTree(4)
+	for i := 0; i < 1000; i++ {
+		tree.Insert(&testKey{value: i * 10})
+	}
+	var h Hint
+	tree.GetHint(&testKey{value: 5000}, &h)
+	leaf := h.path[len(h.path)-1].n
+	depth := len(h.path)
+
+	// A key next to the last one is found without leaving the leaf.
+	tree.InsertHint(&testKey{value: 5001}, &h)
+	if h.path[len(h.path)-1].n != leaf || len(h.path) != depth {
+		t.Fatalf("Hinted insert next to the last key moved to another leaf")
+	}
+	if tree.GetHint(&testKey{value: 5001}, &h) == nil {
+		t.Fatalf("Failed to get the key inserted with a hint")
+	}
+
+	// A key far away restarts from a higher node and lands in another leaf.
+	if k := tree.GetHint(&testKey{value: 10}, &h); k == nil || k.(*testKey).value != 10 {
+		t.Fatalf("Got key %v instead of expected key %d", k, 10)
+	}
+	if h.path[len(h.path)-1].n == leaf {
+		t.Fatalf("Hinted get of a distant key stayed in the same leaf")
+	}
+
+	if tree.GetHint(String("a"), &h) != nil || tree.InsertHint(String("a"), &h) != ErrKeyTypeMismatch {
+		t.Fatalf("Hinted operations accepted a key of the wrong type")
+	}
+}
+
+func BenchmarkTreeHint(b *testing.B) {
+	for _, hinted := range []bool{false, true} {
+		name := "Root"
+		if hinted {
+			name = "Hint"
+		}
+		b.Run(name, func(b *testing.B) {
+			tree := NewBTree(32)
+			for i := 0; i < 1000000; i++ {
+				tree.Insert(&testKey{value: i * 2})
+			}
+			keys := make([]*testKey, b.N)
+			for i := range keys {
+				keys[i] = &testKey{value: 1000000 + i%1000*2}
+			}
+			var h Hint
+			b.ResetTimer()
+			for _, k := range keys {
+				if hinted {
+					tree.GetHint(k, &h)
+				} else {
+					tree.Get(k)
+				}
+			}
+		})
+	}
+}
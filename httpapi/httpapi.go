@@ -0,0 +1,311 @@
+// Package httpapi serves a tree over HTTP with JSON responses:
+//
+//	GET    /keys/{key}                               the key and its value
+//	PUT    /keys/{key}                               stores the key, with the body as its value
+//	DELETE /keys/{key}                               removes the key
+//	GET    /range?from=&to=&limit=&cursor=           the keys from up to to
+//
+// Range responses hold at most limit items and, when there are more, a next
+// cursor to pass back with the same to and limit for the following page.
+// Cursors name the last key of their page, so paging never repeats or skips
+// keys, even while the tree changes between pages.
+package httpapi
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/highlyunavailable/btree"
+)
+
+// A Codec converts between the keys of a tree and their forms in requests
+// and responses.
+type Codec struct {
+	// ParseKey returns the key to look up for the text of a key in a path
+	// or query.
+	ParseKey func(k string) (btree.Key, error)
+	// FormatKey returns the text of a key, the inverse of ParseKey.
+	FormatKey func(k btree.Key) string
+	// Store returns the key to store for the text of a key and the body of
+	// a PUT.
+	Store func(k string, body []byte) (btree.Key, error)
+	// Value returns the value of a key for responses, which must marshal to
+	// JSON. A nil value is left out.
+	Value func(k btree.Key) any
+}
+
+// EntryCodec stores btree.Entries, keyed by the bytes of the key text and
+// holding the bodies of PUTs as their values. Values that are valid JSON are
+// returned as is, and others as strings.
+var EntryCodec = Codec{
+	ParseKey: func(k string) (btree.Key, error) {
+		return &btree.Entry{Key: []byte(k)}, nil
+	},
+	FormatKey: func(k btree.Key) string {
+		return string(k.(*btree.Entry).Key)
+	},
+	Store: func(k string, body []byte) (btree.Key, error) {
+		return &btree.Entry{Key: []byte(k), Value: body}, nil
+	},
+	Value: func(k btree.Key) any {
+		v := k.(*btree.Entry).Value
+		if json.Valid(v) {
+			return json.RawMessage(v)
+		}
+		return string(v)
+	},
+}
+
+// StringCodec stores btree.String keys without values. The bodies of PUTs
+// are ignored.
+var StringCodec = Codec{
+	ParseKey:  func(k string) (btree.Key, error) { return btree.String(k), nil },
+	FormatKey: func(k btree.Key) string { return string(k.(btree.String)) },
+	Store:     func(k string, body []byte) (btree.Key, error) { return btree.String(k), nil },
+	Value:     func(k btree.Key) any { return nil },
+}
+
+const (
+	// DefaultLimit is the number of items in a range page when the request
+	// sets no limit.
+	DefaultLimit = 100
+	// MaxLimit is the most items a range page holds.
+	MaxLimit = 1000
+	// MaxBodySize is the largest body a PUT may have.
+	MaxBodySize = 1 << 20
+)
+
+// A Handler serves a tree over HTTP. The tree must not be used by anything
+// else while it is served.
+type Handler struct {
+	mu    sync.Mutex // guards tree
+	tree  *btree.BTree
+	codec Codec
+}
+
+// NewHandler returns a handler serving tree, whose keys codec converts.
+func NewHandler(tree *btree.BTree, codec Codec) *Handler {
+	return &Handler{tree: tree, codec: codec}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if k, ok := strings.CutPrefix(r.URL.Path, "/keys/"); ok {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			h.get(w, k)
+		case http.MethodPut:
+			h.put(w, r, k)
+		case http.MethodDelete:
+			h.delete(w, k)
+		default:
+			w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+			fail(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+		return
+	}
+	if r.URL.Path != "/range" {
+		fail(w, http.StatusNotFound, "not found")
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		fail(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	h.scan(w, r)
+}
+
+// item is a key and its value in a response.
+type item struct {
+	Key   string `json:"key"`
+	Value any    `json:"value,omitempty"`
+}
+
+func (h *Handler) item(k btree.Key) item {
+	return item{Key: h.codec.FormatKey(k), Value: h.codec.Value(k)}
+}
+
+func reply(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func fail(w http.ResponseWriter, status int, msg string) {
+	reply(w, status, struct {
+		Error string `json:"error"`
+	}{msg})
+}
+
+// key parses the text of a key.
+func (h *Handler) key(w http.ResponseWriter, s string) (btree.Key, bool) {
+	k, err := h.codec.ParseKey(s)
+	if err != nil {
+		fail(w, http.StatusBadRequest, "invalid key: "+err.Error())
+		return nil, false
+	}
+	return k, true
+}
+
+func (h *Handler) get(w http.ResponseWriter, s string) {
+	k, ok := h.key(w, s)
+	if !ok {
+		return
+	}
+	h.mu.Lock()
+	found := h.tree.Get(k)
+	h.mu.Unlock()
+	if found == nil {
+		fail(w, http.StatusNotFound, "key not found")
+		return
+	}
+	reply(w, http.StatusOK, h.item(found))
+}
+
+func (h *Handler) put(w http.ResponseWriter, r *http.Request, s string) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxBodySize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			fail(w, http.StatusRequestEntityTooLarge, "body too large")
+		} else {
+			fail(w, http.StatusBadRequest, err.Error())
+		}
+		return
+	}
+	k, err := h.codec.Store(s, body)
+	if err != nil {
+		fail(w, http.StatusBadRequest, "invalid key or value: "+err.Error())
+		return
+	}
+
+	h.mu.Lock()
+	old, err := h.tree.ReplaceOrInsert(k)
+	h.mu.Unlock()
+	switch {
+	case errors.Is(err, btree.ErrKeyTypeMismatch):
+		fail(w, http.StatusBadRequest, err.Error())
+	case err != nil:
+		fail(w, http.StatusInternalServerError, err.Error())
+	case old == nil:
+		reply(w, http.StatusCreated, h.item(k))
+	default:
+		reply(w, http.StatusOK, h.item(k))
+	}
+}
+
+func (h *Handler) delete(w http.ResponseWriter, s string) {
+	k, ok := h.key(w, s)
+	if !ok {
+		return
+	}
+	h.mu.Lock()
+	found := h.tree.Get(k) != nil
+	var err error
+	if found {
+		err = h.tree.Remove(k)
+	}
+	h.mu.Unlock()
+	switch {
+	case err != nil:
+		fail(w, http.StatusInternalServerError, err.Error())
+	case !found:
+		fail(w, http.StatusNotFound, "key not found")
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// page is a response to a range request.
+type page struct {
+	Items []item `json:"items"`
+	Next  string `json:"next,omitempty"`
+}
+
+// cursor is the content of the opaque cursors of range pages.
+type cursor struct {
+	After string `json:"after"`
+}
+
+func encodeCursor(after string) string {
+	b, _ := json.Marshal(cursor{After: after})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return "", err
+	}
+	var c cursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return "", err
+	}
+	return c.After, nil
+}
+
+func (h *Handler) scan(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit := DefaultLimit
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > MaxLimit {
+			fail(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(MaxLimit))
+			return
+		}
+		limit = n
+	}
+
+	// A cursor resumes after the last key of the previous page, in place of
+	// from.
+	var lo, hi, after btree.Key
+	var ok bool
+	if c := q.Get("cursor"); c != "" {
+		s, err := decodeCursor(c)
+		if err != nil {
+			fail(w, http.StatusBadRequest, "invalid cursor")
+			return
+		}
+		if after, ok = h.key(w, s); !ok {
+			return
+		}
+		lo = after
+	} else if q.Has("from") {
+		if lo, ok = h.key(w, q.Get("from")); !ok {
+			return
+		}
+	}
+	if q.Has("to") {
+		if hi, ok = h.key(w, q.Get("to")); !ok {
+			return
+		}
+	}
+
+	p := page{Items: []item{}}
+	h.mu.Lock()
+	it := h.tree.Scan(lo, hi)
+	for it.Next() {
+		k := it.Key()
+		if after != nil && k.Compare(after) == 0 {
+			continue
+		}
+		if len(p.Items) == limit {
+			p.Next = encodeCursor(p.Items[limit-1].Key)
+			break
+		}
+		p.Items = append(p.Items, h.item(k))
+	}
+	err := it.Err()
+	h.mu.Unlock()
+	if err != nil {
+		fail(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	reply(w, http.StatusOK, p)
+}
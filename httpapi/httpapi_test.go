@@ -0,0 +1,131 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/highlyunavailable/btree"
+)
+
+func newServer(t *testing.T, codec Codec) *httptest.Server {
+	tree, err := btree.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := httptest.NewServer(NewHandler(tree, codec))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// do sends a request and decodes its JSON reply into v, unless v is nil,
+// returning the status.
+func do(t *testing.T, s *httptest.Server, method, path, body string, v any) int {
+	t.Helper()
+	req, err := http.NewRequest(method, s.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := s.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestKeys(t *testing.T) {
+	s := newServer(t, EntryCodec)
+	var got map[string]any
+	if code := do(t, s, "GET", "/keys/a", "", &got); code != http.StatusNotFound || got["error"] == nil {
+		t.Fatalf("GET of a missing key replied %d %v", code, got)
+	}
+	if code := do(t, s, "PUT", "/keys/a", `{"n": 1}`, nil); code != http.StatusCreated {
+		t.Fatalf("PUT of a new key replied %d", code)
+	}
+	if code := do(t, s, "PUT", "/keys/a/b", "text", nil); code != http.StatusCreated {
+		t.Fatalf("PUT of a key with a slash replied %d", code)
+	}
+	if code := do(t, s, "PUT", "/keys/a", `[1, 2]`, nil); code != http.StatusOK {
+		t.Fatalf("PUT of an existing key replied %d", code)
+	}
+
+	for path, want := range map[string]map[string]any{
+		"/keys/a":   {"key": "a", "value": []any{1.0, 2.0}},
+		"/keys/a/b": {"key": "a/b", "value": "text"},
+	} {
+		got = nil
+		if code := do(t, s, "GET", path, "", &got); code != http.StatusOK || !reflect.DeepEqual(got, want) {
+			t.Fatalf("GET %s replied %d %v, want %v", path, code, got, want)
+		}
+	}
+
+	if code := do(t, s, "DELETE", "/keys/a", "", nil); code != http.StatusNoContent {
+		t.Fatalf("DELETE replied %d", code)
+	}
+	if code := do(t, s, "DELETE", "/keys/a", "", nil); code != http.StatusNotFound {
+		t.Fatalf("DELETE of a missing key replied %d", code)
+	}
+	if code := do(t, s, "POST", "/keys/a", "", nil); code != http.StatusMethodNotAllowed {
+		t.Fatalf("POST replied %d", code)
+	}
+}
+
+func TestRangePages(t *testing.T) {
+	s := newServer(t, StringCodec)
+	for i := 0; i < 25; i++ {
+		do(t, s, "PUT", "/keys/k"+strconv.Itoa(100+i), "", nil)
+	}
+
+	// Page through [k105, k120) three keys at a time, removing and adding
+	// keys between pages.
+	var keys []string
+	q := url.Values{"from": {"k105"}, "to": {"k120"}, "limit": {"3"}}
+	for pages := 0; ; pages++ {
+		var p struct {
+			Items []struct{ Key string }
+			Next  string
+		}
+		if code := do(t, s, "GET", "/range?"+q.Encode(), "", &p); code != http.StatusOK {
+			t.Fatalf("GET /range?%s replied %d", q.Encode(), code)
+		}
+		for _, it := range p.Items {
+			keys = append(keys, it.Key)
+		}
+		if p.Next == "" {
+			break
+		}
+		if pages == 0 {
+			do(t, s, "DELETE", "/keys/"+keys[len(keys)-1], "", nil)
+			do(t, s, "PUT", "/keys/k107a", "", nil)
+			do(t, s, "PUT", "/keys/k104a", "", nil)
+		}
+		q.Del("from")
+		q.Set("cursor", p.Next)
+	}
+	want := []string{"k105", "k106", "k107", "k107a", "k108", "k109", "k110",
+		"k111", "k112", "k113", "k114", "k115", "k116", "k117", "k118", "k119"}
+	if !reflect.DeepEqual(keys, want) {
+		t.Fatalf("Paged through %v, want %v", keys, want)
+	}
+
+	var p struct{ Items []any }
+	if code := do(t, s, "GET", "/range?from=z", "", &p); code != http.StatusOK || p.Items == nil || len(p.Items) != 0 {
+		t.Fatalf("GET of an empty range replied %d %v", code, p.Items)
+	}
+	for _, query := range []string{"limit=0", "limit=x", "limit=100000", "cursor=%21"} {
+		if code := do(t, s, "GET", "/range?"+query, "", nil); code != http.StatusBadRequest {
+			t.Fatalf("GET /range?%s replied %d", query, code)
+		}
+	}
+}
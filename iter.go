@@ -0,0 +1,301 @@
+package btree
+
+// Iterator walks the keys of a tree in ascending order by following the links
+// between leaves. The tree must not be modified while an Iterator is in use.
+//
+//	for it := t.Scan(lo, hi); it.Next(); {
+//		k := it.Key()
+//	}
+type Iterator struct {
+	leaf node
+	keys keys // of leaf, if it holds them boxed
+	n    int  // the number of keys in leaf
+	i    int
+	hi   key
+	cur  key
+	err  error
+
+	// expired reports keys to skip because they have expired.
+	expired func(key) bool
+}
+
+// Scan returns an iterator over the keys k with lo <= k < hi. A nil lo or hi
+// leaves that end of the range open. Keys that have expired by the time of the call
+// are skipped.
+func (t *BTree) Scan(lo, hi key) *Iterator {
+	for _, k := range []key{lo, hi} {
+		if k != nil && t.checkKey(k) != nil {
+			return &Iterator{err: ErrKeyTypeMismatch}
+		}
+	}
+	if err := t.tryDrain(); err != nil {
+		return &Iterator{err: err}
+	}
+
+	leaf := t.root
+	for {
+		n, ok := leaf.(*internalNode)
+		if !ok {
+			break
+		}
+		if lo == nil {
+			leaf = n.nodes[0]
+		} else {
+			_, nIdx := n.searchKNIndex(lo)
+			leaf = n.nodes[nIdx]
+		}
+	}
+
+	it := &Iterator{hi: hi}
+	it.setLeaf(leaf)
+	if x := t.expiries; x != nil {
+		now := t.cfg.clock().Now()
+		it.expired = func(k key) bool { return x.expired(k, now) }
+	}
+	if lo != nil {
+		it.i = leaf.Search(lo)
+	}
+	return it
+}
+
+// ScanPrefix returns an iterator over the keys starting with prefix, which
+// must be a Bytes or String key. The scan seeks to prefix and stops at the
+// first key past every key with that prefix, without testing each key.
+func (t *BTree) ScanPrefix(prefix key) *Iterator {
+	end, ok := prefixEnd(prefix)
+	if !ok {
+		return &Iterator{err: ErrKeyTypeMismatch}
+	}
+	return t.Scan(prefix, end)
+}
+
+// CountPrefix returns the number of keys starting with prefix, or 0 if prefix
+// is not a key of the tree's type.
+func (t *BTree) CountPrefix(prefix key) int {
+	n := 0
+	for it := t.ScanPrefix(prefix); it.Next(); {
+		n++
+	}
+	return n
+}
+
+// DeletePrefix removes every key starting with prefix and returns the number
+// of keys removed.
+func (t *BTree) DeletePrefix(prefix key) (int, error) {
+	var ks keys
+	it := t.ScanPrefix(prefix)
+	for it.Next() {
+		ks = append(ks, it.Key())
+	}
+	if it.Err() != nil {
+		return 0, it.Err()
+	}
+	for i, k := range ks {
+		if err := t.Remove(k); err != nil {
+			return i, err
+		}
+	}
+	return len(ks), nil
+}
+
+// Next advances the iterator and reports whether there is a key to read.
+func (it *Iterator) Next() bool {
+	for {
+		for it.leaf != nil && it.i == it.n {
+			it.setLeaf(nextLeaf(it.leaf))
+		}
+		if it.leaf == nil {
+			return false
+		}
+		var k key
+		if it.keys != nil {
+			k = it.keys[it.i]
+		} else {
+			k = leafAt(it.leaf, it.i)
+		}
+		if it.hi != nil && !k.Less(it.hi) {
+			it.leaf = nil
+			return false
+		}
+		it.i++
+		if it.expired == nil || !it.expired(k) {
+			it.cur = k
+			return true
+		}
+	}
+}
+
+// setLeaf moves the iterator to the start of leaf. Keys of typed and
+// prefix-compressed leaves are read one at a time with leafAt, since their
+// Keys would box every key of the leaf into a new slice.
+func (it *Iterator) setLeaf(leaf node) {
+	it.leaf, it.keys, it.n, it.i = leaf, nil, 0, 0
+	if l, ok := leaf.(*leafNode); ok {
+		it.keys = l.keys
+	}
+	if leaf != nil {
+		it.n = leafLen(leaf)
+	}
+}
+
+// Key returns the key at the current position of the iterator.
+func (it *Iterator) Key() key {
+	return it.cur
+}
+
+// Err returns the error that stopped the iterator, if any. Scans over keys
+// of the wrong type end with ErrKeyTypeMismatch.
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+func nextLeaf(n node) node {
+	switch n := n.(type) {
+	case *leafNode:
+		if n.next != nil {
+			return n.next
+		}
+	case *bytesLeafNode:
+		if n.next != nil {
+			return n.next
+		}
+	case typedLeaf:
+		return n.nextLeaf()
+	}
+	return nil
+}
+
+func previousLeaf(n node) node {
+	switch n := n.(type) {
+	case *leafNode:
+		if n.previous != nil {
+			return n.previous
+		}
+	case *bytesLeafNode:
+		if n.previous != nil {
+			return n.previous
+		}
+	case typedLeaf:
+		return n.previousLeaf()
+	}
+	return nil
+}
+
+// rightmostLeaf returns the last leaf under n.
+func rightmostLeaf(n node) node {
+	for {
+		in, ok := n.(*internalNode)
+		if !ok {
+			return n
+		}
+		n = in.nodes[len(in.nodes)-1]
+	}
+}
+
+// atRightEdge reports whether n is the rightmost node of its level.
+func atRightEdge(n node) bool {
+	return nextLeaf(rightmostLeaf(n)) == nil
+}
+
+func leafLen(n node) int {
+	switch n := n.(type) {
+	case *leafNode:
+		return len(n.keys)
+	case *bytesLeafNode:
+		return len(n.suffixes)
+	case typedLeaf:
+		return n.count()
+	}
+	return 0
+}
+
+// leafAt returns the key at position i of the leaf n.
+func leafAt(n node, i int) key {
+	switch n := n.(type) {
+	case *leafNode:
+		return n.keys[i]
+	case *bytesLeafNode:
+		return n.key(i)
+	case typedLeaf:
+		return n.at(i)
+	}
+	corrupt("expected a leaf node")
+	return nil
+}
+
+func leafCap(n node) int {
+	switch n := n.(type) {
+	case *leafNode:
+		return cap(n.keys)
+	case *bytesLeafNode:
+		return cap(n.suffixes)
+	case typedLeaf:
+		return n.capacity()
+	}
+	return 0
+}
+
+// unlinkLeaf removes a leaf from the chain of leaves.
+func unlinkLeaf(n node) {
+	switch n := n.(type) {
+	case *leafNode:
+		if n.previous != nil {
+			n.previous.next = n.next
+		}
+		if n.next != nil {
+			n.next.previous = n.previous
+		}
+	case *bytesLeafNode:
+		if n.previous != nil {
+			n.previous.next = n.next
+		}
+		if n.next != nil {
+			n.next.previous = n.previous
+		}
+	case typedLeaf:
+		n.unlink()
+	}
+}
+
+// isDrained reports whether a node holds no entries at all.
+func isDrained(n node) bool {
+	switch n := n.(type) {
+	case *leafNode:
+		return len(n.keys) == 0
+	case *bytesLeafNode:
+		return len(n.suffixes) == 0
+	case typedLeaf:
+		return n.count() == 0
+	case *internalNode:
+		return len(n.nodes) == 0
+	}
+	return false
+}
+
+// prefixEnd returns the smallest key greater than every key starting with
+// prefix, or nil if there is none. It reports false if prefix is not a Bytes
+// or String key.
+func prefixEnd(prefix key) (key, bool) {
+	var p []byte
+	switch k := prefix.(type) {
+	case Bytes:
+		p = k
+	case String:
+		p = []byte(k)
+	default:
+		return nil, false
+	}
+
+	end := append([]byte(nil), p...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			end = end[:i+1]
+			if _, ok := prefix.(String); ok {
+				return String(end), true
+			}
+			return Bytes(end), true
+		}
+	}
+	return nil, true
+}
@@ -0,0 +1,101 @@
+package btree
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func newPrefixTestTree(t *BTree, mk func(string) key) *BTree {
+	for _, i := range rand.New(rand.NewSource(1)).Perm(1000) {
+		t.Insert(mk(fmt.Sprintf("user:%d:%03d", i%10, i)))
+	}
+	return t
+}
+
+func TestScan(t *testing.T) {
+	tree := NewBTree(4)
+	for i := 0; i < 100; i++ {
+		tree.Insert(&testKey{value: i * 2})
+	}
+
+	want := 11
+	for it := tree.Scan(&testKey{value: 11}, &testKey{value: 40}); it.Next(); {
+		want++
+		if it.Key().(*testKey).value != want {
+			t.Fatalf("Got value %d instead of expected value %d", it.Key().(*testKey).value, want)
+		}
+		want++
+	}
+	if want != 39 {
+		t.Fatalf("Scan ended after %d instead of expected %d", want-1, 38)
+	}
+
+	if tree.Scan(&testKey{value: 500}, nil).Next() {
+		t.Fatalf("Got a key past the end of the tree")
+	}
+	if NewBTree(4).Scan(nil, nil).Next() {
+		t.Fatalf("Got a key from an empty tree")
+	}
+}
+
+func TestScanPrefix(t *testing.T) {
+	for name, tree := range map[string]*BTree{
+		"bytes":  newPrefixTestTree(NewBTree(8), func(s string) key { return Bytes(s) }),
+		"prefix": newPrefixTestTree(NewBytesBTree(8), func(s string) key { return Bytes(s) }),
+		"string": newPrefixTestTree(NewBTree(8), func(s string) key { return String(s) }),
+	} {
+		prefix := func(s string) key {
+			if name == "string" {
+				return String(s)
+			}
+			return Bytes(s)
+		}
+
+		n := 0
+		for it := tree.ScanPrefix(prefix("user:4:")); it.Next(); {
+			want := fmt.Sprintf("user:4:%03d", n*10+4)
+			if got := fmt.Sprintf("%s", it.Key()); got != want {
+				t.Fatalf("%s: Got key %q instead of expected key %q", name, got, want)
+			}
+			n++
+		}
+		if n != 100 {
+			t.Fatalf("%s: Got %d keys instead of expected %d", name, n, 100)
+		}
+
+		if c := tree.CountPrefix(prefix("user:7:1")); c != 10 {
+			t.Fatalf("%s: Got count %d instead of expected count %d", name, c, 10)
+		}
+		if c := tree.CountPrefix(prefix("")); c != 1000 {
+			t.Fatalf("%s: Got count %d instead of expected count %d", name, c, 1000)
+		}
+
+		if d, err := tree.DeletePrefix(prefix("user:3:")); d != 100 || err != nil {
+			t.Fatalf("%s: Deleted %d keys with error %v instead of expected %d", name, d, err, 100)
+		}
+		if c := tree.CountPrefix(prefix("user:3:")); c != 0 {
+			t.Fatalf("%s: Got count %d after deleting the prefix", name, c)
+		}
+		if c := tree.CountPrefix(prefix("user:")); c != 900 {
+			t.Fatalf("%s: Got count %d instead of expected count %d", name, c, 900)
+		}
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	for _, tc := range []struct {
+		prefix, end key
+	}{
+		{Bytes("abc"), Bytes("abd")},
+		{Bytes("a\xff\xff"), Bytes("b")},
+		{String("user:"), String("user;")},
+		{Bytes("\xff"), nil},
+		{Bytes(""), nil},
+	} {
+		end, _ := prefixEnd(tc.prefix)
+		if fmt.Sprintf("%#v", end) != fmt.Sprintf("%#v", tc.end) {
+			t.Fatalf("Got prefix end %#v instead of expected %#v for %q", end, tc.end, tc.prefix)
+		}
+	}
+}
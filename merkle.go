@@ -0,0 +1,190 @@
+package btree
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// A Hash is a digest of a set of keys and their values. It is the sum of the
+// SHA-256 hashes of the keys, taken as four 64-bit lanes, so it depends only
+// on which keys the set holds and not on the shape of the tree holding them.
+// The empty set hashes to the zero Hash.
+type Hash [sha256.Size]byte
+
+// add adds the lanes of h to those of x.
+func (x *Hash) add(h Hash) {
+	for i := 0; i < len(x); i += 8 {
+		binary.LittleEndian.PutUint64(x[i:], binary.LittleEndian.Uint64(x[i:])+binary.LittleEndian.Uint64(h[i:]))
+	}
+}
+
+// sub takes the lanes of h from those of x.
+func (x *Hash) sub(h Hash) {
+	for i := 0; i < len(x); i += 8 {
+		binary.LittleEndian.PutUint64(x[i:], binary.LittleEndian.Uint64(x[i:])-binary.LittleEndian.Uint64(h[i:]))
+	}
+}
+
+// nodeSum is the hash and number of the keys below a node.
+type nodeSum struct {
+	h Hash
+	n int
+}
+
+func (s *nodeSum) add(o nodeSum) {
+	s.h.add(o.h)
+	s.n += o.n
+}
+
+func (s *nodeSum) sub(o nodeSum) {
+	s.h.sub(o.h)
+	s.n -= o.n
+}
+
+// since returns what s has gained over before, an earlier copy of it.
+func (s nodeSum) since(before nodeSum) nodeSum {
+	s.sub(before)
+	return s
+}
+
+// summed holds the sum of the keys below a node of a tree built WithMerkle.
+// Every kind of node embeds it, and keeps it up to date as its keys or
+// children change: leaves as keys come and go, internal nodes as their
+// children change below them, and both in Split, Merge and Rebalance* as
+// entries move between siblings. In other trees it stays zero.
+type summed struct {
+	sum nodeSum
+}
+
+func (s *summed) sums() *nodeSum {
+	return &s.sum
+}
+
+// merkle hashes the keys of a tree built WithMerkle.
+type merkle struct {
+	enc Encoder
+}
+
+func newMerkle(enc Encoder) *merkle {
+	return &merkle{enc: enc}
+}
+
+// hash returns the hash of the key k.
+func (m *merkle) hash(k key) Hash {
+	kb, vb := m.enc(k)
+	b := binary.AppendUvarint(make([]byte, 0, binary.MaxVarintLen64+len(kb)+len(vb)), uint64(len(kb)))
+	b = append(append(b, kb...), vb...)
+	return sha256.Sum256(b)
+}
+
+// hashKey returns the sum of the key k alone, which is zero in trees not
+// built WithMerkle.
+func (c *Config) hashKey(k key) nodeSum {
+	if c == nil || c.merkle == nil {
+		return nodeSum{}
+	}
+	return nodeSum{c.merkle.hash(k), 1}
+}
+
+// shift moves the sum of the entries i to j of to, just moved there from
+// its sibling from, out of the sum of from and into that of to.
+func (c *Config) shift(to, from node, i, j int) {
+	if c == nil || c.merkle == nil {
+		return
+	}
+	var s nodeSum
+	if in, ok := to.(*internalNode); ok {
+		for _, child := range in.nodes[i:j] {
+			s.add(*child.sums())
+		}
+	} else {
+		for ; i < j; i++ {
+			s.add(c.hashKey(leafAt(to, i)))
+		}
+	}
+	to.sums().add(s)
+	from.sums().sub(s)
+}
+
+// resum sets the sum of n to that of its children, after they have been
+// rearranged wholesale.
+func (c *Config) resum(n *internalNode) {
+	if c == nil || c.merkle == nil {
+		return
+	}
+	n.sum = nodeSum{}
+	for _, child := range n.nodes {
+		n.sum.add(*child.sums())
+	}
+}
+
+// rangeSum returns the sum of the keys k below n with lo <= k < hi, where
+// the keys of n are known to lie in [nlo, nhi]. The upper bound is inclusive
+// because splits between equal keys leave one equal to the separator on the
+// left. Nil bounds are open.
+func (m *merkle) rangeSum(n node, lo, hi, nlo, nhi key) nodeSum {
+	if (lo == nil || nlo != nil && !nlo.Less(lo)) && (hi == nil || nhi != nil && nhi.Less(hi)) {
+		return *n.sums()
+	}
+	var s nodeSum
+	in, ok := n.(*internalNode)
+	if !ok {
+		for i := 0; i < leafLen(n); i++ {
+			if k := leafAt(n, i); (lo == nil || !k.Less(lo)) && (hi == nil || k.Less(hi)) {
+				s.add(nodeSum{m.hash(k), 1})
+			}
+		}
+		return s
+	}
+	for i, child := range in.nodes {
+		clo, chi := nlo, nhi
+		if i > 0 {
+			clo = in.keys[i-1]
+		}
+		if i < len(in.keys) {
+			chi = in.keys[i]
+		}
+		if hi != nil && clo != nil && !clo.Less(hi) {
+			break
+		}
+		if lo != nil && chi != nil && chi.Less(lo) {
+			continue
+		}
+		s.add(m.rangeSum(child, lo, hi, clo, chi))
+	}
+	return s
+}
+
+// RootHash returns the hash of the keys in the tree, which equals that of
+// any other tree holding the same keys, with the same values, whatever their
+// shapes. Keys that have expired but not yet been removed count. It returns
+// ErrNotHashed for trees not built WithMerkle, and ErrCorrupt if applying
+// the buffered changes finds the tree corrupt.
+func (t *BTree) RootHash() (Hash, error) {
+	if t.cfg.merkle == nil {
+		return Hash{}, ErrNotHashed
+	}
+	if err := t.tryDrain(); err != nil {
+		return Hash{}, err
+	}
+	return t.root.sums().h, nil
+}
+
+// RangeHash returns the hash and number of the keys k with lo <= k < hi. A
+// nil lo or hi leaves that end of the range open. Only the nodes on the paths
+// to lo and hi are visited.
+func (t *BTree) RangeHash(lo, hi Key) (Hash, int, error) {
+	if t.cfg.merkle == nil {
+		return Hash{}, 0, ErrNotHashed
+	}
+	for _, k := range []key{lo, hi} {
+		if k != nil && t.checkKey(k) != nil {
+			return Hash{}, 0, ErrKeyTypeMismatch
+		}
+	}
+	if err := t.tryDrain(); err != nil {
+		return Hash{}, 0, err
+	}
+	s := t.cfg.merkle.rangeSum(t.root, lo, hi, nil, nil)
+	return s.h, s.n, nil
+}
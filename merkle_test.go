@@ -0,0 +1,188 @@
+package btree
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+func encodeInt64(k key) ([]byte, []byte) {
+	return binary.BigEndian.AppendUint64(nil, uint64(k.(Int64))^1<<63), nil
+}
+
+func encodeBytes(k key) ([]byte, []byte) {
+	return k.(Bytes), nil
+}
+
+// rootHash returns the root hash of tree, failing t on an error.
+func rootHash(t *testing.T, tree *BTree) Hash {
+	t.Helper()
+	h, err := tree.RootHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestRootHashShapes(t *testing.T) {
+	var hashes []Hash
+	for _, opts := range [][]Option{
+		{WithMerkle(encodeTestKey)},
+		{WithMerkle(encodeTestKey), WithOrder(3)},
+		{WithMerkle(encodeTestKey), WithOrder(4), WithSplitPolicy(SplitLeft), WithNodePool()},
+	} {
+		tree, err := New(opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rootHash(t, tree) != (Hash{}) {
+			t.Fatal("Empty tree has a nonzero hash")
+		}
+		for _, v := range rand.Perm(200) {
+			tree.Insert(&testKey{v})
+		}
+		for v := 0; v < 200; v += 3 {
+			tree.Remove(&testKey{v})
+		}
+		hashes = append(hashes, rootHash(t, tree))
+	}
+	for i := 1; i < len(hashes); i++ {
+		if hashes[i] != hashes[0] {
+			t.Fatalf("Trees of different shapes hash to %x and %x", hashes[0], hashes[i])
+		}
+	}
+
+	// Changing a value changes the hash.
+	encodeUser := func(k key) ([]byte, []byte) {
+		return binary.BigEndian.AppendUint64(nil, uint64(k.(*user).id)), []byte(k.(*user).email)
+	}
+	tree, _ := New(WithMerkle(encodeUser))
+	tree.Insert(&user{id: 1, email: "a"})
+	before := rootHash(t, tree)
+	tree.ReplaceOrInsert(&user{id: 1, email: "b"})
+	if rootHash(t, tree) == before {
+		t.Fatal("Replacing a value left the hash unchanged")
+	}
+	tree.ReplaceOrInsert(&user{id: 1, email: "a"})
+	if rootHash(t, tree) != before {
+		t.Fatal("Restoring a value did not restore the hash")
+	}
+
+	if _, err := NewBTree(3).RootHash(); err != ErrNotHashed {
+		t.Fatalf("RootHash without hashing returned %v", err)
+	}
+	if _, _, err := NewBTree(3).RangeHash(nil, nil); err != ErrNotHashed {
+		t.Fatalf("RangeHash without hashing returned %v", err)
+	}
+}
+
+// checkSums compares the sum kept by n and by each node below it with one
+// computed afresh from their keys, and returns that of n.
+func checkSums(t *testing.T, m *merkle, n node) nodeSum {
+	t.Helper()
+	var want nodeSum
+	if in, ok := n.(*internalNode); ok {
+		for _, child := range in.nodes {
+			want.add(checkSums(t, m, child))
+		}
+	} else {
+		for i := 0; i < leafLen(n); i++ {
+			want.add(nodeSum{m.hash(leafAt(n, i)), 1})
+		}
+	}
+	if got := *n.sums(); got != want {
+		t.Fatalf("Node of %d keys keeps the sum of %d keys, hash %x, want %x", want.n, got.n, got.h, want.h)
+	}
+	return want
+}
+
+// checkHashes compares the sums kept by the nodes of tree with ones computed
+// afresh, and range hashes with sums over the keys in the range. Scans start
+// from the first key, since duplicate keys may sit left of a separator equal
+// to them, where a scan from that key would not look.
+func checkHashes(t *testing.T, tree *BTree, rng *rand.Rand) {
+	t.Helper()
+	m := tree.cfg.merkle
+	if got, want := rootHash(t, tree), checkSums(t, m, tree.root).h; got != want {
+		t.Fatalf("Root hash %x, want %x", got, want)
+	}
+	for i := 0; i < 5; i++ {
+		lo, hi := Int64(rng.Intn(1000)), Int64(rng.Intn(1000))
+		var want nodeSum
+		for it := tree.Scan(nil, hi); it.Next(); {
+			if !it.Key().Less(lo) {
+				want.add(nodeSum{m.hash(it.Key()), 1})
+			}
+		}
+		if h, n, err := tree.RangeHash(lo, hi); err != nil || h != want.h || n != want.n {
+			t.Fatalf("RangeHash(%d, %d) returned %d keys, %v, want %d", lo, hi, n, err, want.n)
+		}
+	}
+}
+
+func TestMerkleChanges(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, opts := range [][]Option{
+		{WithOrder(3)},
+		{WithOrder(4), WithNodePool(), WithMergePolicy(MergeLazy)},
+		{WithLeafOrder(5), WithInternalOrder(3), WithSplitPolicy(SplitAdaptive)},
+	} {
+		tree, err := New(append(opts, WithMerkle(encodeInt64))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var h Hint
+		for i := 0; i < 600; i++ {
+			k := Int64(rng.Intn(1000))
+			switch op := rng.Intn(9); {
+			case op < 3:
+				tree.Insert(k)
+			case op == 7:
+				tree.ReplaceOrInsert(k)
+			case op == 3:
+				tree.InsertHint(k, &h)
+			case op == 4:
+				tree.RemoveHint(k, &h)
+			case op == 5:
+				ks := []Key{k, k + 1, k + 7}
+				if rng.Intn(2) == 0 {
+					tree.InsertBatch(ks)
+				} else {
+					tree.RemoveBatch(ks)
+				}
+			case op == 6:
+				tree.popEnd(rng.Intn(2) == 0)
+			default:
+				tree.Remove(k)
+			}
+			if i%10 == 0 {
+				checkHashes(t, tree, rng)
+			}
+		}
+		checkHashes(t, tree, rng)
+		tree.Clear()
+		if rootHash(t, tree) != (Hash{}) {
+			t.Fatal("Cleared tree has a nonzero hash")
+		}
+	}
+
+	// Prefix-compressed leaves hash the keys they hold like any other.
+	plain, _ := New(WithMerkle(encodeBytes), WithOrder(3))
+	compressed, _ := New(WithMerkle(encodeBytes), WithOrder(3), WithPrefixCompression())
+	for i := 0; i < 300; i++ {
+		k := Bytes("key-" + strconv.Itoa(rng.Intn(50)))
+		if rng.Intn(3) == 0 {
+			plain.Remove(k)
+			compressed.Remove(k)
+		} else {
+			plain.Insert(k)
+			compressed.Insert(k)
+		}
+	}
+	if rootHash(t, plain) != rootHash(t, compressed) {
+		t.Fatal("Prefix compression changed the hash")
+	}
+	checkSums(t, plain.cfg.merkle, plain.root)
+	checkSums(t, compressed.cfg.merkle, compressed.root)
+}
@@ -0,0 +1,17 @@
+//go:build !unix
+
+package btree
+
+import "os"
+
+// mmapShared is whether writes to a file show through its mappings.
+const mmapShared = false
+
+// mmapFile falls back to reading the whole file on platforms without mmap.
+func mmapFile(name string) ([]byte, func() error, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return nil }, nil
+}
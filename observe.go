@@ -0,0 +1,169 @@
+package btree
+
+import "sync/atomic"
+
+// A NodeOp is a change to the shape of the tree.
+type NodeOp int
+
+const (
+	// OpSplit splits a full node in two.
+	OpSplit NodeOp = iota
+	// OpMerge merges two siblings into the left one.
+	OpMerge
+	// OpRebalanceToHead moves keys from the tail of a left sibling to the
+	// head of its right one.
+	OpRebalanceToHead
+	// OpRebalanceToTail moves keys from the head of a right sibling to the
+	// tail of its left one.
+	OpRebalanceToTail
+)
+
+// A NodeKind tells leaves from internal nodes.
+type NodeKind int
+
+const (
+	// LeafKind is a leaf, at level 0.
+	LeafKind NodeKind = iota
+	// InternalKind is an internal node, above the leaves.
+	InternalKind
+)
+
+// A NodeEvent describes a split, merge or rebalance. Level is the height of
+// the nodes above the leaves, which are at level 0. Before and After hold
+// the number of keys in the left and right node; a split starts from a
+// single node, and a merge ends with one, both counted as the left one.
+type NodeEvent struct {
+	Op            NodeOp
+	Kind          NodeKind
+	Level         int
+	Before, After [2]int
+}
+
+// An Observer receives the structural changes of a tree configured with
+// WithObserver, as they happen. Trees without an observer do none of the
+// work of describing them. A node that InsertBatch spreads over several is
+// reported as split one node at a time, from the left, as if it had first
+// taken all of its keys.
+type Observer interface {
+	Observe(NodeEvent)
+}
+
+// WithObserver makes the tree report its splits, merges and rebalances to o.
+func WithObserver(o Observer) Option {
+	return func(c *Config) { c.Observer = o }
+}
+
+func (c *Config) observer() Observer {
+	if c == nil {
+		return nil
+	}
+	return c.Observer
+}
+
+// sizes returns the number of keys in left and right, which may be nil, for
+// the Before of an event. Without an observer it does not count them.
+func (c *Config) sizes(left, right node) [2]int {
+	if c.observer() == nil {
+		return [2]int{}
+	}
+	return [2]int{nodeLen(left), nodeLen(right)}
+}
+
+// observe reports op on left and right, whose sizes were before, to the
+// observer if there is one.
+func (c *Config) observe(op NodeOp, before [2]int, left, right node) {
+	if obs := c.observer(); obs != nil {
+		obs.Observe(nodeEvent(op, before, left, right))
+	}
+}
+
+// observeSplits reports the nodes out, which a batch filled in place of the
+// last of them, as splits of that node, each taking the leftmost of the
+// nodes still joined.
+func (c *Config) observeSplits(out nodes) {
+	obs := c.observer()
+	if obs == nil || len(out) < 2 {
+		return
+	}
+	// Internal splits move a key up to the parent, so the joined node held
+	// the separators between out as well.
+	_, moved := out[0].(*internalNode)
+	rest := 0
+	for _, n := range out {
+		rest += nodeLen(n)
+	}
+	if moved {
+		rest += len(out) - 1
+	}
+	for _, n := range out[:len(out)-1] {
+		e := nodeEvent(OpSplit, [2]int{rest, 0}, n, nil)
+		rest -= e.After[0]
+		if moved {
+			rest--
+		}
+		e.After[1] = rest
+		obs.Observe(e)
+	}
+}
+
+// nodeEvent describes op on left and right, which is nil after a merge.
+func nodeEvent(op NodeOp, before [2]int, left, right node) NodeEvent {
+	e := NodeEvent{Op: op, Before: before, After: [2]int{nodeLen(left), nodeLen(right)}}
+	for n := left; ; e.Level++ {
+		in, ok := n.(*internalNode)
+		if !ok {
+			break
+		}
+		e.Kind = InternalKind
+		n = in.nodes[0]
+	}
+	return e
+}
+
+// nodeLen returns the number of keys in n.
+func nodeLen(n node) int {
+	if in, ok := n.(*internalNode); ok {
+		return len(in.keys)
+	}
+	return leafLen(n)
+}
+
+// Counters is an Observer counting the events of each kind, for metrics.
+// Its counts can be read while the tree changes.
+type Counters struct {
+	splits, merges, toHead, toTail [2]atomic.Uint64
+}
+
+// Metrics is a snapshot of Counters, indexed by NodeKind.
+type Metrics struct {
+	Splits           [2]uint64
+	Merges           [2]uint64
+	RebalancesToHead [2]uint64
+	RebalancesToTail [2]uint64
+}
+
+// Observe counts e.
+func (c *Counters) Observe(e NodeEvent) {
+	switch e.Op {
+	case OpSplit:
+		c.splits[e.Kind].Add(1)
+	case OpMerge:
+		c.merges[e.Kind].Add(1)
+	case OpRebalanceToHead:
+		c.toHead[e.Kind].Add(1)
+	case OpRebalanceToTail:
+		c.toTail[e.Kind].Add(1)
+	}
+}
+
+// Snapshot returns the counts so far.
+func (c *Counters) Snapshot() Metrics {
+	var m Metrics
+	for k := range m.Splits {
+		m.Splits[k] = c.splits[k].Load()
+		m.Merges[k] = c.merges[k].Load()
+		m.RebalancesToHead[k] = c.toHead[k].Load()
+		m.RebalancesToTail[k] = c.toTail[k].Load()
+	}
+	return m
+}
@@ -0,0 +1,128 @@
+package btree
+
+import (
+	"math/rand"
+	"testing"
+)
+
+type recorder struct {
+	events []NodeEvent
+}
+
+func (r *recorder) Observe(e NodeEvent) {
+	r.events = append(r.events, e)
+}
+
+type observers []Observer
+
+func (os observers) Observe(e NodeEvent) {
+	for _, o := range os {
+		o.Observe(e)
+	}
+}
+
+func TestObserver(t *testing.T) {
+	rec, counters := &recorder{}, &Counters{}
+	tree, err := New(WithOrder(4), WithObserver(observers{rec, counters}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := rand.New(rand.NewSource(1))
+	perm := r.Perm(2000)
+	for _, v := range perm {
+		tree.Insert(&testKey{v})
+	}
+	leaves := 1
+	for _, e := range rec.events {
+		if e.Op != OpSplit {
+			t.Fatalf("Insert caused %+v", e)
+		}
+		if e.Kind == LeafKind {
+			leaves++
+		}
+	}
+	if n, _ := leafFill(tree); n != leaves {
+		t.Fatalf("Tree has %d leaves after %d leaf splits", n, leaves-1)
+	}
+
+	for _, v := range r.Perm(2000) {
+		tree.Remove(&testKey{v})
+	}
+
+	var want Metrics
+	for _, e := range rec.events {
+		sum := func(c [2]int) int { return c[0] + c[1] }
+		if (e.Kind == LeafKind) != (e.Level == 0) {
+			t.Fatalf("%+v has the wrong level", e)
+		}
+		switch e.Op {
+		case OpSplit:
+			want.Splits[e.Kind]++
+			// Internal splits move their middle key up to the parent.
+			if moved := int(e.Kind); e.Before[1] != 0 || e.Before[0] != sum(e.After)+moved {
+				t.Fatalf("%+v changed the number of keys", e)
+			}
+		case OpMerge:
+			want.Merges[e.Kind]++
+			if e.After[1] != 0 || e.Kind == LeafKind && e.After[0] != sum(e.Before) {
+				t.Fatalf("%+v changed the number of keys", e)
+			}
+		case OpRebalanceToHead, OpRebalanceToTail:
+			if e.Op == OpRebalanceToHead {
+				want.RebalancesToHead[e.Kind]++
+			} else {
+				want.RebalancesToTail[e.Kind]++
+			}
+			if e.Kind == LeafKind && sum(e.Before) != sum(e.After) {
+				t.Fatalf("%+v changed the number of keys", e)
+			}
+		}
+	}
+	if got := counters.Snapshot(); got != want {
+		t.Fatalf("Counters %+v, want %+v", got, want)
+	}
+	if want.Merges[LeafKind] == 0 || want.Splits[InternalKind] == 0 ||
+		want.RebalancesToHead[LeafKind]+want.RebalancesToTail[LeafKind] == 0 {
+		t.Fatalf("Missing events: %+v", want)
+	}
+}
+
+func TestObserverInsertBatch(t *testing.T) {
+	rec := &recorder{}
+	tree, err := New(WithOrder(4), WithObserver(rec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ks []Key
+	for v := 0; v < 500; v += 2 {
+		ks = append(ks, &testKey{v})
+	}
+	for i := 0; i < 2; i++ {
+		if err := tree.InsertBatch(ks); err != nil {
+			t.Fatal(err)
+		}
+		// The second batch falls between the keys of the first.
+		for j, k := range ks {
+			ks[j] = &testKey{k.(*testKey).value + 1}
+		}
+	}
+
+	leaves, internal := 1, 0
+	for _, e := range rec.events {
+		if e.Op != OpSplit || (e.Kind == LeafKind) != (e.Level == 0) {
+			t.Fatalf("InsertBatch caused %+v", e)
+		}
+		if moved := int(e.Kind); e.Before[1] != 0 || e.Before[0] != e.After[0]+e.After[1]+moved {
+			t.Fatalf("%+v changed the number of keys", e)
+		}
+		if e.Kind == LeafKind {
+			leaves++
+		} else {
+			internal++
+		}
+	}
+	if n, _ := leafFill(tree); n != leaves || internal == 0 {
+		t.Fatalf("Tree has %d leaves after %d leaf and %d internal splits", n, leaves-1, internal)
+	}
+}
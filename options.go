@@ -0,0 +1,299 @@
+package btree
+
+// DefaultOrder is the order of trees created by New without WithOrder.
+const DefaultOrder = 32
+
+// A SplitPolicy decides where a full node is split.
+type SplitPolicy int
+
+const (
+	// SplitMidpoint splits nodes into two halves.
+	SplitMidpoint SplitPolicy = iota
+	// SplitRight leaves the left node nearly full, which suits ascending
+	// inserts that only ever add to the right node.
+	SplitRight
+	// SplitLeft leaves the right node nearly full, which suits descending
+	// inserts.
+	SplitLeft
+	// SplitAdaptive splits each node to the right or left when its last
+	// insert went to its end or start, and at the midpoint otherwise.
+	SplitAdaptive
+)
+
+// A MergePolicy decides what happens to nodes left underfull by removals.
+type MergePolicy int
+
+const (
+	// MergeEager refills underfull nodes from a sibling, or merges the two
+	// when they fit in a single node.
+	MergeEager MergePolicy = iota
+	// MergeLazy leaves underfull nodes alone and only drops nodes that
+	// removals have emptied, trading space for cheaper removals.
+	MergeLazy
+)
+
+// An EvictionPolicy decides which keys a tree with a size limit evicts.
+type EvictionPolicy int
+
+const (
+	// EvictLRU evicts the least recently used keys.
+	EvictLRU EvictionPolicy = iota
+	// EvictLFU evicts the least frequently used keys, and the least recently
+	// used of those used equally often.
+	EvictLFU
+)
+
+// Config holds the settings of a tree, built up by the Options passed to New.
+type Config struct {
+	// Order is the maximum number of keys in a node. It must be at least 3.
+	Order uint
+
+	// LeafOrder and InternalOrder override Order for leaves and internal
+	// nodes when they are not zero.
+	LeafOrder     uint
+	InternalOrder uint
+
+	// MinFill is the fraction of a node's capacity below which MergeEager
+	// refills it. It must be between 0 and 0.5.
+	MinFill float64
+
+	SplitPolicy SplitPolicy
+	MergePolicy MergePolicy
+
+	// DetectAppends splits the rightmost node of each level so that it stays
+	// nearly full when its last insert went to its end, whatever the split
+	// policy, and lets inserts past the largest key skip the descent from
+	// the root. It is on by default.
+	DetectAppends bool
+
+	// PrefixCompression stores Bytes keys in leaves that hold the prefix
+	// shared by their keys only once.
+	PrefixCompression bool
+
+	// NodePool keeps the nodes dropped by merges, removals and Clear on a
+	// free list, with their backing arrays, and builds new nodes from it.
+	NodePool bool
+
+	// Clock tells the time to the expiry of keys inserted with
+	// InsertExpiring, and paces the janitor. It defaults to the system clock.
+	Clock Clock
+
+	// MaxKeys and MaxBytes limit the number of keys in the tree and the sum
+	// of their KeySize, when they are not zero. Inserts past either limit
+	// evict keys by the Eviction policy, passing each to OnEvict if it is
+	// set. Gets and inserts count as uses of a key; scans do not.
+	MaxKeys  int
+	MaxBytes int
+	KeySize  func(Key) int
+	Eviction EvictionPolicy
+	OnEvict  func(Key)
+
+	// Observer receives the splits, merges and rebalances of nodes.
+	Observer Observer
+
+	// KeyFormatter formats the keys written by Dump.
+	KeyFormatter func(Key) string
+
+	// BufferSize, when it is not zero, makes internal nodes queue up to
+	// BufferSize inserts and removals before passing them down to their
+	// children all at once, which saves random inserts most of their node
+	// visits at some cost to reads. The operations that walk the leaves
+	// first apply every queued change, so they write to the tree even when
+	// they only read keys, and need the same exclusive access as Insert:
+	// Scan, ScanPrefix, CountPrefix, Dump, Write, WriteFile, RootHash,
+	// RangeHash, Diff, ServeDiff, ParallelForEach, ParallelReduce,
+	// InsertBatch, RemoveBatch, ReplaceOrInsert and the Queue methods.
+	BufferSize int
+
+	// Merkle makes the tree hash its keys and their values, as encoded by
+	// Merkle, for RootHash, RangeHash and Diff. Each node keeps the sum of
+	// the hashes below it, updated as keys come, go and move between nodes.
+	Merkle Encoder
+
+	pool   *nodePool
+	merkle *merkle
+}
+
+// defaultConfig holds the settings New starts from. Nodes created outside of
+// a tree use it too, except that they always split at the midpoint.
+var defaultConfig = Config{Order: DefaultOrder, MinFill: 0.5, DetectAppends: true}
+
+// An Option configures a tree created by New.
+type Option func(*Config)
+
+// WithOrder sets the maximum number of keys in a node.
+func WithOrder(d uint) Option {
+	return func(c *Config) { c.Order = d }
+}
+
+// WithLeafOrder sets the maximum number of keys in a leaf.
+func WithLeafOrder(d uint) Option {
+	return func(c *Config) { c.LeafOrder = d }
+}
+
+// WithInternalOrder sets the maximum number of keys in an internal node.
+func WithInternalOrder(d uint) Option {
+	return func(c *Config) { c.InternalOrder = d }
+}
+
+// WithMinFill sets the fraction of a node's capacity below which it is
+// refilled from its siblings.
+func WithMinFill(f float64) Option {
+	return func(c *Config) { c.MinFill = f }
+}
+
+// WithSplitPolicy sets where full nodes are split.
+func WithSplitPolicy(p SplitPolicy) Option {
+	return func(c *Config) { c.SplitPolicy = p }
+}
+
+// WithMergePolicy sets how underfull nodes are handled.
+func WithMergePolicy(p MergePolicy) Option {
+	return func(c *Config) { c.MergePolicy = p }
+}
+
+// WithAppendDetection turns the detection of appends on or off.
+func WithAppendDetection(on bool) Option {
+	return func(c *Config) { c.DetectAppends = on }
+}
+
+// WithPrefixCompression makes the tree hold Bytes keys in prefix-compressed
+// leaves.
+func WithPrefixCompression() Option {
+	return func(c *Config) { c.PrefixCompression = true }
+}
+
+// WithNodePool makes the tree recycle the nodes it drops instead of leaving
+// them to the garbage collector.
+func WithNodePool() Option {
+	return func(c *Config) { c.NodePool = true }
+}
+
+// WithClock makes the tree tell the time of expiring keys by c.
+func WithClock(c Clock) Option {
+	return func(cfg *Config) { cfg.Clock = c }
+}
+
+// WithMaxKeys limits the tree to n keys.
+func WithMaxKeys(n int) Option {
+	return func(c *Config) { c.MaxKeys = n }
+}
+
+// WithMaxBytes limits the sum of the sizes of the keys in the tree to n, as
+// told by size.
+func WithMaxBytes(n int, size func(Key) int) Option {
+	return func(c *Config) { c.MaxBytes, c.KeySize = n, size }
+}
+
+// WithEviction sets which keys a tree with a size limit evicts.
+func WithEviction(p EvictionPolicy) Option {
+	return func(c *Config) { c.Eviction = p }
+}
+
+// WithOnEvict makes the tree call f with each key it evicts.
+func WithOnEvict(f func(Key)) Option {
+	return func(c *Config) { c.OnEvict = f }
+}
+
+// WithBuffering makes internal nodes queue up to n changes for their
+// children.
+func WithBuffering(n int) Option {
+	return func(c *Config) { c.BufferSize = n }
+}
+
+// WithMerkle makes the tree keep hashes of its keys, as encoded by enc.
+func WithMerkle(enc Encoder) Option {
+	return func(c *Config) { c.Merkle = enc }
+}
+
+// resolve fills in the node orders and validates the configuration.
+func (c *Config) resolve() error {
+	if c.LeafOrder == 0 {
+		c.LeafOrder = c.Order
+	}
+	if c.InternalOrder == 0 {
+		c.InternalOrder = c.Order
+	}
+	if c.LeafOrder < 3 || c.InternalOrder < 3 {
+		return ErrInvalidOrder
+	}
+	if c.MinFill < 0 || c.MinFill > 0.5 {
+		return ErrInvalidFill
+	}
+	if c.MaxKeys < 0 || c.MaxBytes < 0 || c.MaxBytes > 0 && c.KeySize == nil || c.BufferSize < 0 {
+		return ErrInvalidLimit
+	}
+	if c.NodePool {
+		c.pool = &nodePool{}
+	}
+	if c.Merkle != nil {
+		c.merkle = newMerkle(c.Merkle)
+	}
+	return nil
+}
+
+// underfull reports whether a node holding n of capacity entries needs to be
+// refilled.
+func (c *Config) underfull(n, capacity int) bool {
+	if c == nil {
+		c = &defaultConfig
+	}
+	return n <= int(float64(capacity)*c.MinFill)
+}
+
+// splitIndex returns the number of entries a full node holding n entries
+// keeps in its left half, given the position of its last insert. edge
+// reports whether the node is the rightmost one of its level.
+func (c *Config) splitIndex(n, last int, edge func() bool) int {
+	if c == nil {
+		return n / 2
+	}
+	policy := c.SplitPolicy
+	if c.DetectAppends && last >= n-1 && edge() {
+		policy = SplitRight
+	}
+	if policy == SplitAdaptive {
+		switch {
+		case last >= n-1:
+			policy = SplitRight
+		case last == 0:
+			policy = SplitLeft
+		default:
+			policy = SplitMidpoint
+		}
+	}
+	switch policy {
+	case SplitRight:
+		return n - 1
+	case SplitLeft:
+		return 1
+	default:
+		return n / 2
+	}
+}
+
+func (c *Config) mergePolicy() MergePolicy {
+	if c == nil {
+		return MergeEager
+	}
+	return c.MergePolicy
+}
+
+// bounded reports whether the tree has a size limit.
+func (c *Config) bounded() bool {
+	return c.MaxKeys > 0 || c.MaxBytes > 0
+}
+
+// overLimit reports whether n keys of the given total size exceed the size
+// limits.
+func (c *Config) overLimit(n, bytes int) bool {
+	return c.MaxKeys > 0 && n > c.MaxKeys || c.MaxBytes > 0 && bytes > c.MaxBytes
+}
+
+// clock returns the configured clock, or the system clock.
+func (c *Config) clock() Clock {
+	if c == nil || c.Clock == nil {
+		return systemClock{}
+	}
+	return c.Clock
+}
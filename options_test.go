@@ -0,0 +1,244 @@
+package btree
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+func TestNewInvalidOrder(t *testing.T) {
+	for _, d := range []uint{0, 1, 2} {
+		if tree, err := New(WithOrder(d)); tree != nil || err != ErrInvalidOrder {
+			t.Fatalf("Got tree %v, error %v for order %d", tree, err, d)
+		}
+	}
+
+	tree, err := New()
+	if err != nil {
+		t.Fatalf("Failed to create a tree with default options: %v", err)
+	}
+	for i := 0; i < 1000; i++ {
+		if err := tree.Insert(&testKey{value: i}); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+	if tree.cfg.LeafOrder != DefaultOrder || tree.cfg.InternalOrder != DefaultOrder {
+		t.Fatalf("Got orders %d/%d instead of expected order %d", tree.cfg.LeafOrder, tree.cfg.InternalOrder, DefaultOrder)
+	}
+}
+
+func TestKeyTypeMismatch(t *testing.T) {
+	tree := NewBTree(4)
+	for i := 0; i < 100; i++ {
+		tree.Insert(&testKey{value: i})
+	}
+
+	if err := tree.Insert(String("a")); err != ErrKeyTypeMismatch {
+		t.Fatalf("Got error %v instead of expected error %v", err, ErrKeyTypeMismatch)
+	}
+	if err := tree.Remove(Bytes("a")); err != ErrKeyTypeMismatch {
+		t.Fatalf("Got error %v instead of expected error %v", err, ErrKeyTypeMismatch)
+	}
+	if err := tree.Insert(nil); err != ErrKeyTypeMismatch {
+		t.Fatalf("Got error %v instead of expected error %v", err, ErrKeyTypeMismatch)
+	}
+	if k := tree.Get(String("a")); k != nil {
+		t.Fatalf("Got key %v for a key of the wrong type", k)
+	}
+	it := tree.ScanPrefix(&testKey{value: 1})
+	if it.Next() || it.Err() != ErrKeyTypeMismatch {
+		t.Fatalf("Got error %v instead of expected error %v", it.Err(), ErrKeyTypeMismatch)
+	}
+	if _, err := NewBytesBTree(4).DeletePrefix(String("a")); err != ErrKeyTypeMismatch {
+		t.Fatalf("Got error %v instead of expected error %v", err, ErrKeyTypeMismatch)
+	}
+	checkTree(t, tree, func() map[int]bool {
+		want := make(map[int]bool)
+		for i := 0; i < 100; i++ {
+			want[i] = true
+		}
+		return want
+	}())
+}
+
+func TestCorruptNodeError(t *testing.T) {
+	tree := NewBTree(4)
+	for i := 0; i < 20; i++ {
+		tree.Insert(&testKey{value: i})
+	}
+	// Move a leaf down a level, so that its siblings are of different kinds.
+	root := tree.root.(*internalNode)
+	in := newInternalNode(4)
+	in.nodes = append(in.nodes, root.nodes[1])
+	root.nodes[1] = in
+
+	var err error
+	for i := 0; i < 20 && err == nil; i++ {
+		err = tree.Remove(&testKey{value: i})
+	}
+	if !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("Got error %v instead of expected error %v", err, ErrCorrupt)
+	}
+}
+
+// leafFill returns the number of leaves in the tree and their average fill.
+func leafFill(tree *BTree) (int, float64) {
+	leaves, used, total := 0, 0, 0
+	var walk func(n node)
+	walk = func(n node) {
+		switch n := n.(type) {
+		case *internalNode:
+			for _, c := range n.nodes {
+				walk(c)
+			}
+		case *leafNode:
+			leaves++
+			used += len(n.keys)
+			total += cap(n.keys)
+		}
+	}
+	walk(tree.root)
+	return leaves, float64(used) / float64(total)
+}
+
+func TestSplitPolicies(t *testing.T) {
+	for _, tc := range []struct {
+		policy     SplitPolicy
+		descending bool
+		minFill    float64
+	}{
+		{SplitMidpoint, false, 0.45},
+		{SplitRight, false, 0.9},
+		{SplitLeft, true, 0.9},
+		{SplitAdaptive, false, 0.9},
+		{SplitAdaptive, true, 0.9},
+	} {
+		tree, err := New(WithOrder(16), WithSplitPolicy(tc.policy))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := make(map[int]bool)
+		for i := 0; i < 5000; i++ {
+			v := i
+			if tc.descending {
+				v = -i
+			}
+			tree.Insert(&testKey{value: v})
+			want[v] = true
+		}
+		checkTree(t, tree, want)
+		if _, fill := leafFill(tree); fill < tc.minFill {
+			t.Fatalf("Got leaf fill %.2f for policy %d, expected at least %.2f", fill, tc.policy, tc.minFill)
+		}
+	}
+}
+
+func TestAppendDetection(t *testing.T) {
+	for _, detect := range []bool{true, false} {
+		tree, err := New(WithOrder(16), WithAppendDetection(detect))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := make(map[int]bool)
+		for i := 0; i < 5000; i++ {
+			tree.Insert(&testKey{value: i})
+			want[i] = true
+		}
+		checkTree(t, tree, want)
+		_, fill := leafFill(tree)
+		if detect && fill < 0.9 || !detect && fill > 0.6 {
+			t.Fatalf("Got leaf fill %.2f with append detection %v", fill, detect)
+		}
+
+		// Appends mixed with removals and inserts elsewhere must not go to a
+		// stale rightmost leaf.
+		r := rand.New(rand.NewSource(1))
+		for i := 5000; i < 10000; i++ {
+			tree.Insert(&testKey{value: i})
+			want[i] = true
+			v := r.Intn(i)
+			if r.Intn(2) == 0 {
+				tree.Remove(&testKey{value: v})
+				delete(want, v)
+			} else if !want[v] {
+				tree.Insert(&testKey{value: v})
+				want[v] = true
+			}
+		}
+		checkTree(t, tree, want)
+	}
+}
+
+func TestSeparateOrders(t *testing.T) {
+	tree, err := New(WithLeafOrder(64), WithInternalOrder(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10000; i++ {
+		tree.Insert(&testKey{value: i})
+	}
+	root := tree.root.(*internalNode)
+	if cap(root.keys) != 4 {
+		t.Fatalf("Got internal capacity %d instead of expected %d", cap(root.keys), 4)
+	}
+	for n := node(root); ; {
+		in, ok := n.(*internalNode)
+		if !ok {
+			if cap(n.(*leafNode).keys) != 64 {
+				t.Fatalf("Got leaf capacity %d instead of expected %d", cap(n.(*leafNode).keys), 64)
+			}
+			break
+		}
+		n = in.nodes[0]
+	}
+
+	if _, err := New(WithLeafOrder(2)); err != ErrInvalidOrder {
+		t.Fatalf("Got error %v instead of expected error %v", err, ErrInvalidOrder)
+	}
+	if _, err := New(WithMinFill(0.75)); err != ErrInvalidFill {
+		t.Fatalf("Got error %v instead of expected error %v", err, ErrInvalidFill)
+	}
+}
+
+func TestMergePolicies(t *testing.T) {
+	for _, policy := range []MergePolicy{MergeEager, MergeLazy} {
+		tree, err := New(WithOrder(8), WithMergePolicy(policy))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := make(map[int]bool)
+		for i := 0; i < 2000; i++ {
+			tree.Insert(&testKey{value: i})
+			want[i] = true
+		}
+		before, _ := leafFill(tree)
+		for i := 0; i < 2000; i++ {
+			if i%4 != 0 || i >= 1000 && i < 1500 {
+				tree.Remove(&testKey{value: i})
+				delete(want, i)
+			}
+		}
+		checkTree(t, tree, want)
+
+		after, fill := leafFill(tree)
+		switch policy {
+		case MergeEager:
+			if fill < 0.5 {
+				t.Fatalf("Got leaf fill %.2f after eager merges", fill)
+			}
+		case MergeLazy:
+			// Only the leaves emptied by removing 1000-1499 are dropped.
+			if after >= before || fill > 0.5 {
+				t.Fatalf("Got %d leaves with fill %.2f after lazy removals, from %d", after, fill, before)
+			}
+		}
+
+		for i := 0; i < 2000; i++ {
+			tree.Remove(&testKey{value: i})
+		}
+		checkTree(t, tree, nil)
+		if _, ok := tree.root.(*leafNode); !ok {
+			t.Fatalf("Got root %T after removing every key", tree.root)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package btree
+
+import (
+	"context"
+	"runtime"
+	"sync"
+)
+
+// chunksPerWorker is how many subranges ParallelForEach and ParallelReduce
+// cut their range into for each worker, so that workers done early can take
+// on what others have left.
+const chunksPerWorker = 4
+
+// Partition splits the keys k with lo <= k < hi into at most n ranges that
+// cover it in ascending order. The ranges are cut at the separators of the
+// highest level of the tree with at least n nodes in the range, so they hold
+// similar numbers of keys; there are fewer than n when the range spans fewer
+// leaves. A nil lo or hi leaves that end of the range open. Partition only
+// reads the internal nodes, whose separators also route the changes still
+// buffered in them, so it may run alongside other readers of the tree.
+func (t *BTree) Partition(lo, hi Key, n int) (rs []Range, err error) {
+	for _, k := range []key{lo, hi} {
+		if k != nil && t.checkKey(k) != nil {
+			return nil, ErrKeyTypeMismatch
+		}
+	}
+	defer catch(&err)
+
+	// seps[i] separates level[i] from level[i+1].
+	level, seps := []node{t.root}, keys(nil)
+	for len(seps) < n-1 {
+		if _, ok := level[0].(*internalNode); !ok {
+			break
+		}
+		var next []node
+		var nextSeps keys
+		for i, nd := range level {
+			in := asInternalNode(nd)
+			a, b := in.span(lo, hi)
+			if i > 0 {
+				nextSeps = append(nextSeps, seps[i-1])
+			}
+			nextSeps = append(nextSeps, in.keys[a:b]...)
+			next = append(next, in.nodes[a:b+1]...)
+		}
+		level, seps = next, nextSeps
+	}
+
+	rs = make([]Range, 0, min(max(n, 1), len(level)))
+	from := lo
+	for i := 1; i < cap(rs); i++ {
+		s := seps[i*len(level)/cap(rs)-1]
+		rs = append(rs, Range{from, s})
+		from = s
+	}
+	return append(rs, Range{from, hi}), nil
+}
+
+// ParallelForEach calls fn with each key k with lo <= k < hi, from up to
+// workers goroutines at once, or GOMAXPROCS if workers is not positive. The
+// range is cut by Partition, and the keys of each part are passed in order,
+// but the parts run in no particular order. It stops at the first error from
+// fn, or when ctx is done, and returns that error. The tree must not be
+// modified until ParallelForEach returns. In trees built WithBuffering, it
+// first applies the buffered changes, which writes to the tree, so the caller
+// must hold it as it would for Insert; other trees are only read.
+func (t *BTree) ParallelForEach(ctx context.Context, lo, hi Key, workers int, fn func(Key) error) error {
+	workers = parallelism(workers)
+	rs, err := t.partitionDrained(lo, hi, workers*chunksPerWorker)
+	if err != nil {
+		return err
+	}
+	return t.parallel(ctx, rs, workers, func(ctx context.Context, _ int, it *Iterator) error {
+		for it.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := fn(it.Key()); err != nil {
+				return err
+			}
+		}
+		return it.Err()
+	})
+}
+
+// ParallelReduce folds the keys k with lo <= k < hi of t into a T, from up to
+// workers goroutines at once, or GOMAXPROCS if workers is not positive. Each
+// part of the range cut by Partition is folded in order from the zero T, and
+// the results are then joined in key order by combine, also from the zero T,
+// so combine need not be commutative. It returns the error of ctx if ctx is
+// done first. The tree must not be modified until ParallelReduce returns. Like
+// ParallelForEach, it writes to trees built WithBuffering.
+func ParallelReduce[T any](ctx context.Context, t *BTree, lo, hi Key, workers int, fold func(T, Key) T, combine func(T, T) T) (T, error) {
+	var acc T
+	workers = parallelism(workers)
+	rs, err := t.partitionDrained(lo, hi, workers*chunksPerWorker)
+	if err != nil {
+		return acc, err
+	}
+	parts := make([]T, len(rs))
+	err = t.parallel(ctx, rs, workers, func(ctx context.Context, i int, it *Iterator) error {
+		for it.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			parts[i] = fold(parts[i], it.Key())
+		}
+		return it.Err()
+	})
+	if err != nil {
+		return acc, err
+	}
+	for _, p := range parts {
+		acc = combine(acc, p)
+	}
+	return acc, nil
+}
+
+// partitionDrained applies the buffered changes of the tree, so that the
+// scans of the workers find them in the leaves and only read the tree, then
+// cuts the range like Partition.
+func (t *BTree) partitionDrained(lo, hi Key, n int) ([]Range, error) {
+	if err := t.tryDrain(); err != nil {
+		return nil, err
+	}
+	return t.Partition(lo, hi, n)
+}
+
+func parallelism(workers int) int {
+	if workers > 0 {
+		return workers
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// parallel calls do with a scan of each of rs from up to workers goroutines,
+// and returns the first error from do, or that of ctx. The tree must have been
+// drained, so that the scans only read it.
+func (t *BTree) parallel(ctx context.Context, rs []Range, workers int, do func(ctx context.Context, i int, it *Iterator) error) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range min(workers, len(rs)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				if err := do(ctx, i, t.Scan(rs[i].Lo, rs[i].Hi)); err != nil {
+					cancel(err)
+				}
+			}
+		}()
+	}
+feed:
+	for i := range rs {
+		select {
+		case next <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(next)
+	wg.Wait()
+	return context.Cause(ctx)
+}
@@ -0,0 +1,166 @@
+package btree
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestPartition(t *testing.T) {
+	tree, _ := New(WithOrder(8), WithBuffering(8))
+	for _, v := range rand.Perm(20000) {
+		tree.Insert(Int64(v))
+	}
+	// Partition reads the tree without applying its buffered changes.
+	if _, err := tree.Partition(nil, nil, 4); err != nil || !tree.pending {
+		t.Fatalf("Partition returned %v, with changes pending %v", err, tree.pending)
+	}
+	for _, c := range []struct {
+		lo, hi Key
+		n      int
+	}{
+		{nil, nil, 1},
+		{nil, nil, 7},
+		{nil, nil, 64},
+		{Int64(1000), Int64(3000), 5},
+		{Int64(19990), nil, 8},
+		{Int64(50), Int64(40), 3},
+	} {
+		rs, err := tree.Partition(c.lo, c.hi, c.n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rs) == 0 || len(rs) > c.n || rs[0].Lo != c.lo || rs[len(rs)-1].Hi != c.hi {
+			t.Fatalf("Partition(%v, %v, %d) = %v", c.lo, c.hi, c.n, rs)
+		}
+		total, most := 0, 0
+		for i, r := range rs {
+			if i > 0 && r.Lo != rs[i-1].Hi {
+				t.Fatalf("Partition(%v, %v, %d) = %v has a gap", c.lo, c.hi, c.n, rs)
+			}
+			n := 0
+			for it := tree.Scan(r.Lo, r.Hi); it.Next(); {
+				n++
+			}
+			total += n
+			most = max(most, n)
+		}
+		want := 0
+		for it := tree.Scan(c.lo, c.hi); it.Next(); {
+			want++
+		}
+		if total != want {
+			t.Fatalf("Partition(%v, %v, %d) covers %d keys, want %d", c.lo, c.hi, c.n, total, want)
+		}
+		// The largest part is within a few nodes' fill of an even share.
+		if len(rs) == c.n && most > 3*want/c.n+8 {
+			t.Fatalf("Partition(%v, %v, %d) has a part of %d keys out of %d", c.lo, c.hi, c.n, most, want)
+		}
+	}
+
+	if rs, _ := NewBTree(4).Partition(nil, nil, 4); len(rs) != 1 || rs[0] != (Range{}) {
+		t.Fatalf("Partition of an empty tree = %v", rs)
+	}
+	if _, err := tree.Partition(String("a"), nil, 2); err != ErrKeyTypeMismatch {
+		t.Fatalf("Partition with a mismatched key returned %v", err)
+	}
+
+	// A leaf in place of an internal node is reported, not panicked on.
+	root := tree.root.(*internalNode)
+	root.nodes[len(root.nodes)-1] = NewBTree(4).root
+	if _, err := tree.Partition(nil, nil, 1000); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("Partition of a corrupt tree returned %v", err)
+	}
+}
+
+func TestParallelForEach(t *testing.T) {
+	tree, _ := New(WithOrder(16))
+	for v := 0; v < 10000; v++ {
+		tree.Insert(Int64(v))
+	}
+	var mu sync.Mutex
+	seen := make(map[Int64]int)
+	err := tree.ParallelForEach(context.Background(), Int64(100), Int64(9000), 4, func(k Key) error {
+		mu.Lock()
+		seen[k.(Int64)]++
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 8900 {
+		t.Fatalf("Visited %d keys, want 8900", len(seen))
+	}
+	for k, n := range seen {
+		if n != 1 || k < 100 || k >= 9000 {
+			t.Fatalf("Visited %d %d times", k, n)
+		}
+	}
+
+	// The first error stops the other workers.
+	stop := errors.New("stop")
+	var calls atomic.Int64
+	err = tree.ParallelForEach(context.Background(), nil, nil, 4, func(k Key) error {
+		calls.Add(1)
+		if k.(Int64) == 5000 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Fatalf("ParallelForEach returned %v, want %v", err, stop)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls.Store(0)
+	err = tree.ParallelForEach(ctx, nil, nil, 0, func(Key) error {
+		calls.Add(1)
+		return nil
+	})
+	if err != context.Canceled || calls.Load() != 0 {
+		t.Fatalf("Canceled ParallelForEach returned %v after %d calls", err, calls.Load())
+	}
+}
+
+func TestParallelReduce(t *testing.T) {
+	tree, _ := New(WithOrder(4))
+	for _, v := range rand.Perm(5000) {
+		tree.Insert(Int64(v))
+	}
+	sum, err := ParallelReduce(context.Background(), tree, nil, nil, 3,
+		func(acc int64, k Key) int64 { return acc + int64(k.(Int64)) },
+		func(a, b int64) int64 { return a + b })
+	if err != nil || sum != 4999*5000/2 {
+		t.Fatalf("ParallelReduce summed to %d, %v", sum, err)
+	}
+
+	// Parts are combined in key order.
+	ks, err := ParallelReduce(context.Background(), tree, Int64(10), Int64(4000), 8,
+		func(acc []Int64, k Key) []Int64 { return append(acc, k.(Int64)) },
+		func(a, b []Int64) []Int64 { return append(a, b...) })
+	if err != nil || len(ks) != 3990 {
+		t.Fatalf("ParallelReduce collected %d keys, %v", len(ks), err)
+	}
+	for i, k := range ks {
+		if k != Int64(10+i) {
+			t.Fatalf("ParallelReduce collected %d at %d", k, i)
+		}
+	}
+
+	// Buffered changes are applied before the workers start to scan.
+	buffered, _ := New(WithOrder(4), WithBuffering(8))
+	for _, v := range rand.Perm(5000) {
+		buffered.Insert(Int64(v))
+	}
+	sum, err = ParallelReduce(context.Background(), buffered, nil, nil, 3,
+		func(acc int64, k Key) int64 { return acc + int64(k.(Int64)) },
+		func(a, b int64) int64 { return a + b })
+	if err != nil || sum != 4999*5000/2 {
+		t.Fatalf("ParallelReduce of a buffered tree summed to %d, %v", sum, err)
+	}
+}
@@ -1,42 +1,241 @@
 package btree
 
-import "sort"
+import (
+	"reflect"
+	"sort"
+)
 
 type BTree struct {
-	root  node
-	order uint
+	root    node
+	cfg     Config
+	keyType reflect.Type
+
+	// rightmost caches the last leaf for appends. Splits keep the right half
+	// in the original node, so only removals need to reset it.
+	rightmost node
+
+	// version changes whenever the shape of the tree or its separators might,
+	// which invalidates every Hint.
+	version uint64
+
+	// pending is set while internal nodes may hold buffered messages.
+	pending bool
+
+	// expiries holds the deadlines of the keys inserted by InsertExpiring.
+	expiries *expiries
+
+	// accesses tracks the use of keys in trees with a size limit.
+	accesses *accesses
+
+	watchers []*Watcher
 }
 
+// New returns an empty tree configured by opts.
+func New(opts ...Option) (*BTree, error) {
+	t := &BTree{cfg: defaultConfig}
+	for _, opt := range opts {
+		opt(&t.cfg)
+	}
+	if err := t.cfg.resolve(); err != nil {
+		return nil, err
+	}
+	if t.cfg.PrefixCompression {
+		t.keyType = reflect.TypeOf(Bytes(nil))
+	}
+	t.root = t.newLeaf()
+	if t.cfg.bounded() {
+		t.accesses = newAccesses(t.cfg.Eviction)
+	}
+	return t, nil
+}
+
+func (t *BTree) newLeaf() node {
+	if t.cfg.PrefixCompression {
+		return t.cfg.allocBytesLeaf(t.cfg.LeafOrder)
+	}
+	if primLeafTypes[t.keyType] {
+		return t.cfg.allocPrimLeaf(t.keyType, t.cfg.LeafOrder)
+	}
+	return t.cfg.allocLeaf(t.cfg.LeafOrder)
+}
+
+// setKeyType fixes the key type of the tree to that of its first key. Key
+// types with specialized leaves get an empty root of that kind.
+func (t *BTree) setKeyType(k key) {
+	if t.keyType != nil {
+		return
+	}
+	t.keyType = reflect.TypeOf(k)
+	if primLeafTypes[t.keyType] && isDrained(t.root) {
+		t.cfg.release(t.root)
+		t.root = t.newLeaf()
+		t.rightmost = nil
+		t.version++
+	}
+}
+
+// NewBTree returns an empty tree of order d. It panics if d is less than 3;
+// use New to get an error instead.
 func NewBTree(d uint) *BTree {
-	return &BTree{
-		root:  newLeafNode(d),
-		order: d,
+	t, err := New(WithOrder(d))
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// Insert adds k to the tree. In trees with a size limit, inserting a key
+// already in the tree only counts as an access to it, and inserts that take
+// the tree past its limit evict the coldest keys.
+func (t *BTree) Insert(k key) error {
+	if err := t.checkKey(k); err != nil {
+		return err
+	}
+	t.setKeyType(k)
+	if t.accesses != nil {
+		return t.insertBounded(k)
 	}
+	if err := t.insert(k); err != nil {
+		return err
+	}
+	t.notify(EventInsert, nil, k)
+	return nil
 }
 
-func (t *BTree) Insert(k key) {
+// insert adds k to the tree, whatever its limits.
+func (t *BTree) insert(k key) (err error) {
+	defer catch(&err)
+
+	if r, ok := t.root.(*internalNode); ok && t.cfg.buffering() {
+		t.enqueue(r, message{k: k})
+		return nil
+	}
+
+	// Appends to trees built WithMerkle go down from the root, which adds k
+	// to the sums on the way.
+	if t.cfg.DetectAppends && t.cfg.merkle == nil {
+		if t.rightmost == nil {
+			t.rightmost = rightmostLeaf(t.root)
+		}
+		// Appends that leave the last leaf short of full need no splits, so
+		// they can go straight to it.
+		if n := leafLen(t.rightmost); n > 0 && n+1 < leafCap(t.rightmost) && t.rightmost.Search(k) == n {
+			t.rightmost.Insert(k)
+			return nil
+		}
+	}
+
+	t.version++
 	t.root.Insert(k)
-	if t.root.IsFull() {
-		key, left, right := t.root.Split()
+	t.splitRoot()
+	return nil
+}
 
-		r := newInternalNode(t.order)
-		r.keys = append(r.keys, key)
-		r.nodes = append(r.nodes, left, right)
-		t.root = r
+// splitRoot splits a full root under a new one, growing the tree by a level.
+func (t *BTree) splitRoot() {
+	if !t.root.IsFull() {
+		return
 	}
+	before := t.cfg.sizes(t.root, nil)
+	key, left, right := t.root.Split()
+	t.cfg.observe(OpSplit, before, left, right)
+
+	r := t.cfg.allocInternal(t.cfg.InternalOrder)
+	r.appendKeys(key)
+	r.nodes = append(r.nodes, left, right)
+	t.cfg.resum(r)
+	t.root = r
 }
 
-func (t *BTree) Remove(k key) {
-	t.root.Remove(k)
-	if r, ok := t.root.(*internalNode); ok {
-		if len(r.nodes) < 2 {
+// Remove removes k from the tree, if present.
+func (t *BTree) Remove(k key) (err error) {
+	if err := t.checkKey(k); err != nil {
+		return err
+	}
+	defer catch(&err)
+
+	old := t.watched(k)
+	t.rightmost = nil
+	if r, ok := t.root.(*internalNode); ok && t.cfg.buffering() {
+		t.enqueue(r, message{k: k, remove: true})
+	} else {
+		t.version++
+		t.root.Remove(k)
+		t.shrinkRoot()
+	}
+	t.forget(k)
+	if old != nil {
+		t.notify(EventDelete, old, nil)
+	}
+	return nil
+}
+
+// shrinkRoot drops internal roots left with fewer than two children by
+// removals. The messages still buffered in a dropped root are newer than any
+// below it, so they go to the end of the buffer of the new root, or straight
+// into it when it is a leaf.
+func (t *BTree) shrinkRoot() {
+	for {
+		r, ok := t.root.(*internalNode)
+		if !ok {
+			return
+		}
+		switch len(r.nodes) {
+		case 0:
+			t.root = t.newLeaf()
+		case 1:
 			t.root = r.nodes[0]
+		default:
+			return
+		}
+		buf := r.buf
+		t.cfg.release(r)
+		if in, ok := t.root.(*internalNode); ok {
+			in.buf = append(in.buf, buf...)
+			continue
+		}
+		for _, m := range buf {
+			t.apply(m)
+		}
+	}
+}
+
+// Clear removes every key from the tree. With WithNodePool, its nodes go to
+// the free list for the keys inserted next.
+func (t *BTree) Clear() {
+	var old keys
+	if len(t.watchers) > 0 {
+		for it := t.Scan(nil, nil); it.Next(); {
+			old = append(old, it.Key())
 		}
 	}
+	if t.cfg.pool != nil {
+		releaseAll(&t.cfg, t.root)
+	}
+	t.root = t.newLeaf()
+	t.rightmost = nil
+	t.version++
+	t.pending = false
+	t.expiries = nil
+	if t.accesses != nil {
+		t.accesses = newAccesses(t.cfg.Eviction)
+	}
+	for _, k := range old {
+		t.notify(EventDelete, k, nil)
+	}
 }
 
+// Get returns the key in the tree equal to k, or nil if there is none, it
+// has expired or k is of the wrong type.
 func (t *BTree) Get(k key) key {
-	return t.root.Get(k)
+	if t.checkKey(k) != nil {
+		return nil
+	}
+	if k = t.lookup(k); k != nil && !t.live(k) {
+		return nil
+	}
+	t.accesses.touch(k)
+	return k
 }
 
 type key interface {
@@ -44,12 +243,24 @@ type key interface {
 	Compare(key) int
 }
 
+// Key is the interface implemented by the keys of a tree, for callers that
+// need to name it, such as to build the batches of InsertBatch.
+type Key = key
+
 type keys []key
 
 func (ks keys) Len() int           { return len(ks) }
 func (ks keys) Less(i, j int) bool { return ks[i].Less(ks[j]) }
 func (ks keys) Swap(i, j int)      { ks[i], ks[j] = ks[j], ks[i] }
 func (ks keys) Search(x key) int {
+	switch x := x.(type) {
+	case Int64:
+		return searchKeys(ks, x)
+	case Uint64:
+		return searchKeys(ks, x)
+	case String:
+		return searchKeys(ks, x)
+	}
 	return sort.Search(len(ks), func(i int) bool { return ks[i].Compare(x) >= 0 })
 }
 
@@ -64,7 +275,24 @@ func (ks *keys) InsertAt(i int, k key) {
 }
 
 func (ks *keys) RemoveAt(i int) {
-	*ks = append((*ks)[:i], (*ks)[i+1:]...)
+	copy((*ks)[i:], (*ks)[i+1:])
+	ks.truncate(len(*ks) - 1)
+}
+
+// prepend inserts head before the keys of ks, within their backing array
+// when it has room. head must not share that array.
+func (ks *keys) prepend(head ...key) {
+	l := len(*ks)
+	*ks = append(*ks, head...)
+	copy((*ks)[len(head):], (*ks)[:l])
+	copy(*ks, head)
+}
+
+// truncate shortens ks to n keys, clearing the rest so that the backing array
+// does not keep them alive.
+func (ks *keys) truncate(n int) {
+	clear((*ks)[n:])
+	*ks = (*ks)[:n]
 }
 
 func (ks keys) First() key {
@@ -93,6 +321,8 @@ type node interface {
 	IsFull() bool
 	IsEmpty() bool
 	CanMerge(node) bool
+
+	sums() *nodeSum
 }
 
 type nodes []node
@@ -112,21 +342,33 @@ func (ns *nodes) InsertAt(i int, n node) {
 }
 
 func (ns *nodes) RemoveAt(i int) {
-	*ns = append((*ns)[:i], (*ns)[i+1:]...)
+	copy((*ns)[i:], (*ns)[i+1:])
+	ns.truncate(len(*ns) - 1)
+}
+
+// prepend inserts head before the nodes of ns, within their backing array
+// when it has room. head must not share that array.
+func (ns *nodes) prepend(head ...node) {
+	l := len(*ns)
+	*ns = append(*ns, head...)
+	copy((*ns)[len(head):], (*ns)[:l])
+	copy(*ns, head)
 }
 
-func (ns nodes) SplitAt(i int) (left, right nodes) {
-	lslice, rslice := ns[:i], ns[i:]
-	left = make(nodes, len(lslice), cap(ns))
-	right = make(nodes, len(rslice), cap(ns))
-	copy(left, lslice)
-	copy(right, rslice)
-	return
+// truncate shortens ns to n nodes, clearing the rest.
+func (ns *nodes) truncate(n int) {
+	clear((*ns)[n:])
+	*ns = (*ns)[:n]
 }
 
 type internalNode struct {
 	keys  keys
 	nodes nodes
+	cfg   *Config
+	last  int       // position of the last separator added, for SplitAdaptive
+	seps  primSeps  // keys unboxed, for primitive keys; see resync
+	buf   []message // queued for the children, oldest first, when buffering
+	summed
 }
 
 func newInternalNode(b uint) *internalNode {
@@ -136,7 +378,44 @@ func newInternalNode(b uint) *internalNode {
 	}
 }
 
+// The keys of an internal node change only through the methods below, which
+// all end in resync, so that its unboxed separators always match them.
+
+func (n *internalNode) insertKey(i int, k key) {
+	n.keys.InsertAt(i, k)
+	n.resync()
+}
+
+func (n *internalNode) removeKey(i int) {
+	n.keys.RemoveAt(i)
+	n.resync()
+}
+
+func (n *internalNode) setKey(i int, k key) {
+	n.keys[i] = k
+	n.resync()
+}
+
+func (n *internalNode) appendKeys(ks ...key) {
+	n.keys = append(n.keys, ks...)
+	n.resync()
+}
+
+func (n *internalNode) prependKeys(ks ...key) {
+	n.keys.prepend(ks...)
+	n.resync()
+}
+
+// sliceKeys keeps only the keys from i to j, moved to the start of the array.
+func (n *internalNode) sliceKeys(i, j int) {
+	n.keys.truncate(copy(n.keys, n.keys[i:j]))
+	n.resync()
+}
+
 func (n *internalNode) searchKNIndex(k key) (int, int) {
+	if n.seps != nil {
+		return n.seps.route(k, len(n.nodes))
+	}
 	switch idx := n.Search(k); {
 	case idx == len(n.keys):
 		return idx - 1, len(n.nodes) - 1
@@ -147,95 +426,121 @@ func (n *internalNode) searchKNIndex(k key) (int, int) {
 	}
 }
 
+// span returns the first and last children of n that may hold keys k with
+// lo <= k < hi. Nil bounds are open.
+func (n *internalNode) span(lo, hi key) (int, int) {
+	a, b := 0, len(n.nodes)-1
+	if lo != nil {
+		_, a = n.searchKNIndex(lo)
+	}
+	if hi != nil {
+		_, b = n.searchKNIndex(hi)
+		// Keys equal to hi start child b, which then holds none of the range.
+		if b > a && n.keys[b-1].Compare(hi) == 0 {
+			b--
+		}
+	}
+	return a, max(a, b)
+}
+
 func (n *internalNode) Insert(k key) {
 	kIdx, nIdx := n.searchKNIndex(k)
 
 	child := n.nodes[nIdx]
 
 	if child.IsFull() {
-		key, left, right := child.Split()
-		n.keys.InsertAt(n.Search(key), key)
-		n.nodes[nIdx] = left
-		n.nodes.InsertAt(nIdx+1, right)
+		key := n.splitChild(nIdx)
 		if k.Less(key) {
-			child = left
+			child = n.nodes[nIdx]
 		} else {
-			child = right
+			child = n.nodes[nIdx+1]
 			kIdx++
 		}
 	}
 
+	before := *child.sums()
 	child.Insert(k)
+	n.sum.add(child.sums().since(before))
+}
+
+// splitChild splits the full child at nIdx in two and returns the separator
+// added between them.
+func (n *internalNode) splitChild(nIdx int) key {
+	before := n.cfg.sizes(n.nodes[nIdx], nil)
+	key, left, right := n.nodes[nIdx].Split()
+	n.cfg.observe(OpSplit, before, left, right)
+	n.last = n.Search(key)
+	n.insertKey(n.last, key)
+	n.nodes[nIdx] = left
+	n.nodes.InsertAt(nIdx+1, right)
+	return key
 }
 
 func (n *internalNode) Remove(k key) {
-	curKIdx, curNIdx := n.searchKNIndex(k)
-	child := n.nodes[curNIdx]
+	_, nIdx := n.searchKNIndex(k)
+	child := n.nodes[nIdx]
+	before := *child.sums()
 	child.Remove(k)
-	if k.Compare(n.keys[curKIdx]) == 0 {
-		n.keys[curKIdx] = child.GetLowestLeaf()
+	n.sum.add(child.sums().since(before))
+	n.fix(nIdx)
+}
+
+// fix drops, refills or merges the child at nIdx after removals from it, as
+// the merge policy asks. Children left with no entries at all are dropped
+// under either policy.
+func (n *internalNode) fix(nIdx int) {
+	child := n.nodes[nIdx]
+	if isDrained(child) {
+		if in, ok := child.(*internalNode); ok && len(in.buf) > 0 {
+			// The messages still buffered for the child are older than
+			// those of n, and go to its siblings in their place.
+			n.buf = append(in.buf, n.buf...)
+		}
+		unlinkLeaf(child)
+		n.nodes.RemoveAt(nIdx)
+		if len(n.keys) > 0 {
+			n.removeKey(max(nIdx-1, 0))
+		}
+		n.cfg.release(child)
+		return
 	}
-	if len(n.nodes) == 1 {
+	if n.cfg.mergePolicy() == MergeLazy || !child.IsEmpty() || len(n.nodes) == 1 {
 		return
 	}
-	if child.IsEmpty() {
-		switch {
-		case curNIdx == len(n.nodes)-1: // At the end
-			if child.CanMerge(n.nodes[curNIdx-1]) { // Merge case:
-				n.nodes[curNIdx-1].Merge(n.keys[curKIdx], child)
-				n.nodes.RemoveAt(curNIdx)
-				// Reduce the current node and key index since the trailing items were
-				// just deleted
-				curNIdx--
-				// Don't delete the last key
-				if len(n.keys) > 1 {
-					n.keys.RemoveAt(curKIdx)
-
-					curKIdx--
-				}
-
-				switch n.nodes[curNIdx].(type) {
-				case *leafNode:
-					n.keys[curKIdx] = n.nodes[curNIdx].Keys().First()
-				case *internalNode:
-				}
-			} else {
-				n.keys[curKIdx] = child.RebalanceToHead(n.nodes[curNIdx-1])
-			}
-		case curKIdx == 0 && curNIdx == 0: // At the beginning
-			if child.CanMerge(n.nodes[1]) { // Merge case:
-				n.nodes[1].Merge(n.keys[0], n.nodes[0])
-				n.nodes.RemoveAt(0)
-				if len(n.keys) > 1 {
-					n.keys.RemoveAt(0)
-				}
-			} else {
-				n.keys[curKIdx] = child.RebalanceToTail(n.nodes[curNIdx+1])
-			}
-		default: // In the middle
-			if child.CanMerge(n.nodes[curNIdx+1]) { // Merge case:
-				child.Merge(n.keys[curKIdx], n.nodes[curNIdx+1])
-				n.nodes.RemoveAt(curNIdx + 1)
-				// Don't delete the last key
-				if len(n.keys) > 1 {
-					n.keys.RemoveAt(curKIdx + 1)
-				}
-
-				switch n.nodes[curNIdx].(type) {
-				case *leafNode:
-					n.keys[curKIdx] = n.nodes[curNIdx].Keys().First()
-				case *internalNode:
-					nextLowest := n.nodes[curNIdx].GetLowestLeaf()
-					n.keys[curKIdx] = nextLowest
-				}
-			} else {
-				n.keys[curKIdx] = child.RebalanceToTail(n.nodes[curNIdx+1])
-			}
-		}
+
+	// Refill the child from its right sibling, or from its left one when it
+	// is at the end. Separators may be left stale by removals; they still
+	// bound their children, which is all searchKNIndex relies on.
+	sIdx := nIdx
+	if nIdx == len(n.nodes)-1 {
+		sIdx--
+	}
+	left, right := n.nodes[sIdx], n.nodes[sIdx+1]
+	before := n.cfg.sizes(left, right)
+	op := OpMerge
+	switch {
+	case left.CanMerge(right): // Merge case:
+		left.Merge(n.keys[sIdx], right)
+		n.removeKey(sIdx)
+		n.nodes.RemoveAt(sIdx + 1)
+	case left == child:
+		op = OpRebalanceToTail
+		n.setKey(sIdx, left.RebalanceToTail(right))
+	default:
+		op = OpRebalanceToHead
+		n.setKey(sIdx, right.RebalanceToHead(left))
 	}
+	if op == OpMerge {
+		n.cfg.release(right)
+		right = nil
+	}
+	n.cfg.observe(op, before, left, right)
 }
 
 func (n *internalNode) Search(k key) int {
+	if n.seps != nil {
+		return n.seps.search(k)
+	}
 	return n.keys.Search(k)
 }
 
@@ -245,7 +550,15 @@ func (n *internalNode) Get(k key) key {
 }
 
 func (n *internalNode) GetLowestLeaf() key {
-	return n.nodes[0].GetLowestLeaf()
+	low := n.nodes[0].GetLowestLeaf()
+	// Buffered keys may lie below those in the leaves, and the separators
+	// rebalancing takes from here must still route them.
+	for _, m := range n.buf {
+		if m.k.Less(low) {
+			low = m.k
+		}
+	}
+	return low
 }
 
 func (n *internalNode) Keys() keys {
@@ -258,75 +571,82 @@ func (n *internalNode) Less(o node) bool {
 
 func (n *internalNode) Split() (key, node, node) {
 	if len(n.keys) < 3 {
-		panic("Internal node too small to split")
+		corrupt("internal node too small to split")
 	}
 
-	mid := len(n.keys) / 2
+	// The key at mid moves up, so both halves keep at least one key.
+	edge := func() bool { return atRightEdge(n) }
+	mid := min(max(n.cfg.splitIndex(len(n.keys), n.last, edge), 1), len(n.keys)-2)
 	key := n.keys[mid]
 
-	lslice, rslice := n.keys[:mid], n.keys[mid+1:]
-
-	leftNodes, rightNodes := n.nodes.SplitAt(mid + 1)
-
-	left := &internalNode{
-		keys:  make(keys, len(lslice), cap(n.keys)),
-		nodes: leftNodes,
-	}
-
-	rightSubset := make(keys, len(rslice), cap(n.keys))
+	left := n.cfg.allocInternal(uint(cap(n.keys)))
+	left.appendKeys(n.keys[:mid]...)
+	left.nodes = append(left.nodes, n.nodes[:mid+1]...)
 
+	// The right half stays in n, moved down within its own arrays.
 	right := n
-
-	copy(left.keys, lslice)
-	copy(rightSubset, rslice)
-
-	right.keys = rightSubset
-	right.nodes = rightNodes
+	right.sliceKeys(mid+1, len(n.keys))
+	right.nodes.truncate(copy(n.nodes, n.nodes[mid+1:]))
+	n.cfg.shift(left, right, 0, len(left.nodes))
+	moveMessages(left, right, key, true)
 	return key, left, right
 }
 
 func (n *internalNode) Merge(parent key, toMerge node) key {
-	mn := toMerge.(*internalNode)
-	if n.Less(mn) {
-		if parent.Less(mn.keys[0]) {
-			n.keys.InsertAt(n.keys.Search(parent), parent)
-		}
-		n.keys = append(n.keys, mn.keys...)
+	mn := asInternalNode(toMerge)
+	if n.GetLowestLeaf().Less(mn.GetLowestLeaf()) {
+		n.appendKeys(parent)
+		n.appendKeys(mn.keys...)
 		n.nodes = append(n.nodes, mn.nodes...)
 	} else {
-		if parent.Less(n.keys[0]) {
-			n.keys.InsertAt(n.keys.Search(parent), parent)
-		}
-		n.keys = append(n.keys[:0], append(mn.keys, n.keys...)...)
-		n.nodes = append(n.nodes[:0], append(mn.nodes, n.nodes...)...)
+		n.prependKeys(parent)
+		n.prependKeys(mn.keys...)
+		n.nodes.prepend(mn.nodes...)
 	}
+	n.buf = append(n.buf, mn.buf...)
+	n.sum.add(mn.sum)
 	return n.keys.First()
 }
 
+// rebalanceCount returns how many entries to move from a node holding have
+// entries to a sibling holding short, so that the two end up even.
+func rebalanceCount(have, short int) int {
+	if m := (have - short + 1) / 2; m > 1 {
+		return m
+	}
+	return 1
+}
+
 // Rebalances to the tail of this node, removing items from the head of other.
 func (n *internalNode) RebalanceToTail(other node) key {
-	mn := other.(*internalNode)
-	moveIdx := len(mn.keys) - 1 - (len(mn.keys) - cap(mn.keys)/2)
-	// drop 1 key and return it.
-	keyRight := mn.keys[moveIdx-1]
-	n.keys = append(append(n.keys, mn.GetLowestLeaf()), mn.keys[:moveIdx-1]...)
+	mn := asInternalNode(other)
+	moveIdx := rebalanceCount(len(mn.nodes), len(n.nodes))
+	// The lowest key under other's first child separates it from this node.
+	n.appendKeys(mn.GetLowestLeaf())
+	n.appendKeys(mn.keys[:moveIdx-1]...)
 	n.nodes = append(n.nodes, mn.nodes[:moveIdx]...)
+	n.cfg.shift(n, mn, len(n.nodes)-moveIdx, len(n.nodes))
 
-	mn.keys = append(mn.keys[:0], mn.keys[moveIdx:]...)
-	mn.nodes = append(mn.nodes[:0], mn.nodes[moveIdx:]...)
+	keyRight := mn.keys[moveIdx-1]
+	mn.sliceKeys(moveIdx, len(mn.keys))
+	mn.nodes.truncate(copy(mn.nodes, mn.nodes[moveIdx:]))
+	moveMessages(n, mn, keyRight, true)
 	return keyRight
 }
 
 // Rebalances to the head of this node, removing items from the tail of other.
 func (n *internalNode) RebalanceToHead(other node) key {
-	mn := other.(*internalNode)
-	moveIdx := len(mn.keys) - (len(mn.keys) - cap(mn.keys)/2)
-	keyLeft := mn.keys[moveIdx-1]
-	n.keys = append(n.keys[:0], append(append(mn.keys[moveIdx:], n.GetLowestLeaf()), n.keys...)...)
-	n.nodes = append(n.nodes[:0], append(mn.nodes[moveIdx:], n.nodes...)...)
+	mn := asInternalNode(other)
+	moveIdx := len(mn.nodes) - rebalanceCount(len(mn.nodes), len(n.nodes))
+	n.prependKeys(n.GetLowestLeaf())
+	n.prependKeys(mn.keys[moveIdx:]...)
+	n.nodes.prepend(mn.nodes[moveIdx:]...)
+	n.cfg.shift(n, mn, 0, len(mn.nodes)-moveIdx)
 
-	mn.keys = append(mn.keys[:0], mn.keys[:moveIdx-1]...)
-	mn.nodes = append(mn.nodes[:0], mn.nodes[:moveIdx]...)
+	keyLeft := mn.keys[moveIdx-1]
+	mn.sliceKeys(0, moveIdx-1)
+	mn.nodes.truncate(moveIdx)
+	moveMessages(n, mn, keyLeft, false)
 	return keyLeft
 }
 
@@ -335,21 +655,23 @@ func (n *internalNode) IsFull() bool {
 }
 
 func (n *internalNode) IsEmpty() bool {
-	return len(n.keys) <= cap(n.keys)/2
+	return n.cfg.underfull(len(n.keys), cap(n.keys))
 }
 
 func (n *internalNode) CanMerge(other node) bool {
 	if o, ok := other.(*internalNode); ok {
-		return len(n.keys)+len(o.keys) <= cap(n.keys) && len(n.nodes)+len(o.nodes) <= cap(n.nodes)
-	} else {
-		return false
+		// Merging pulls the separator between the two nodes down as well.
+		return len(n.keys)+len(o.keys) < cap(n.keys)
 	}
-	return len(n.keys) <= cap(n.keys)/2 && len(n.nodes) < cap(n.nodes)/2
+	return false
 }
 
 type leafNode struct {
 	keys           keys
 	next, previous *leafNode
+	cfg            *Config
+	last           int // position of the last insert, for SplitAdaptive
+	summed
 }
 
 func newLeafNode(b uint) *leafNode {
@@ -359,7 +681,9 @@ func newLeafNode(b uint) *leafNode {
 }
 
 func (n *leafNode) Insert(k key) {
-	n.keys.InsertAt(n.keys.Search(k), k)
+	n.last = n.keys.Search(k)
+	n.keys.InsertAt(n.last, k)
+	n.sum.add(n.cfg.hashKey(k))
 }
 
 func (n *leafNode) Remove(k key) {
@@ -367,6 +691,7 @@ func (n *leafNode) Remove(k key) {
 
 	if i < len(n.keys) {
 		if k.Compare(n.keys[i]) == 0 {
+			n.sum.sub(n.cfg.hashKey(n.keys[i]))
 			n.keys.RemoveAt(i)
 		}
 	}
@@ -378,7 +703,7 @@ func (n *leafNode) Search(k key) int {
 
 func (n *leafNode) Get(k key) key {
 	i := n.Search(k)
-	if i == len(n.keys) {
+	if i == len(n.keys) || k.Compare(n.keys[i]) != 0 {
 		return nil
 	}
 	return n.keys[i]
@@ -398,62 +723,72 @@ func (n *leafNode) Less(o node) bool {
 
 func (n *leafNode) Split() (key, node, node) {
 	if len(n.keys) < 2 {
-		panic("Leaf node too small to split")
+		corrupt("leaf node too small to split")
 	}
 
-	mid := len(n.keys) / 2
-	key := n.keys[mid]
-	lslice, rslice := n.keys[:mid], n.keys[mid:]
+	mid := n.cfg.splitIndex(len(n.keys), n.last, func() bool { return n.next == nil })
+	key := splitKey(n.keys[mid-1], n.keys[mid])
 
-	left := &leafNode{
-		keys:     make(keys, len(lslice), cap(n.keys)),
-		previous: n.previous,
-		next:     n,
+	left := n.cfg.allocLeaf(uint(cap(n.keys)))
+	left.keys = append(left.keys, n.keys[:mid]...)
+	left.previous, left.next = n.previous, n
+	if n.previous != nil {
+		n.previous.next = left
 	}
 
-	rightSubset := make(keys, len(rslice), cap(n.keys))
-
+	// The right half stays in n, moved down within its own array.
 	right := n
 	right.previous = left
-
-	copy(left.keys, lslice)
-	copy(rightSubset, rslice)
-
-	right.keys = rightSubset
+	right.keys.truncate(copy(n.keys, n.keys[mid:]))
+	n.cfg.shift(left, right, 0, len(left.keys))
 	return key, left, right
 }
 
 func (n *leafNode) Merge(parent key, toMerge node) key {
-	mn := toMerge.(*leafNode)
-	if n.Less(mn) {
+	mn := asLeafNode(toMerge)
+	// Linked siblings merge in list order; unlinked ones by their keys.
+	after := n.next == mn
+	if !after && n.previous != mn {
+		after = len(n.keys) == 0 || len(mn.keys) > 0 && n.Less(mn)
+	}
+	if after {
 		n.keys = append(n.keys, mn.keys...)
+		n.next = mn.next
+		if mn.next != nil {
+			mn.next.previous = n
+		}
 	} else {
-		n.keys = append(mn.keys, n.keys...)
+		n.keys.prepend(mn.keys...)
+		n.previous = mn.previous
+		if mn.previous != nil {
+			mn.previous.next = n
+		}
 	}
+	n.sum.add(mn.sum)
 	return n.keys.First()
 }
 
 // Rebalances to the tail of this node, removing items from the head of other.
 func (n *leafNode) RebalanceToTail(other node) key {
-	mn := other.(*leafNode)
-	moveIdx := len(mn.keys) - 1 - (len(mn.keys) - cap(mn.keys)/2)
-	keyRight := mn.keys[moveIdx]
+	mn := asLeafNode(other)
+	moveIdx := rebalanceCount(len(mn.keys), len(n.keys))
 	n.keys = append(n.keys, mn.keys[:moveIdx]...)
+	n.cfg.shift(n, mn, len(n.keys)-moveIdx, len(n.keys))
 
-	mn.keys = append(mn.keys[:0], mn.keys[moveIdx:]...)
-	return keyRight
+	mn.keys.truncate(copy(mn.keys, mn.keys[moveIdx:]))
+	return mn.keys.First()
 }
 
 // Rebalances to the head of this node, removing items from the tail of other.
 func (n *leafNode) RebalanceToHead(other node) key {
-	mn := other.(*leafNode)
-	moveIdx := len(mn.keys) - (len(mn.keys) - cap(mn.keys)/2)
-	keyLeft := mn.keys[moveIdx]
+	mn := asLeafNode(other)
+	moveIdx := len(mn.keys) - rebalanceCount(len(mn.keys), len(n.keys))
 
-	n.keys = append(n.keys[:0], append(mn.keys[moveIdx:], n.keys...)...)
+	n.keys.prepend(mn.keys[moveIdx:]...)
+	n.cfg.shift(n, mn, 0, len(mn.keys)-moveIdx)
 
-	mn.keys = append(mn.keys[:0], mn.keys[:moveIdx]...)
-	return keyLeft
+	mn.keys.truncate(moveIdx)
+	return n.keys.First()
 }
 
 func (n *leafNode) IsFull() bool {
@@ -461,7 +796,7 @@ func (n *leafNode) IsFull() bool {
 }
 
 func (n *leafNode) IsEmpty() bool {
-	return len(n.keys) <= cap(n.keys)/2
+	return n.cfg.underfull(len(n.keys), cap(n.keys))
 }
 
 func (n *leafNode) CanMerge(other node) bool {
@@ -3,39 +3,10 @@ package btree
 import (
 	"fmt"
 	"math/rand"
+	"strings"
 	"testing"
 )
 
-func drawChildren(level int, n node) {
-	switch n.(type) {
-	case *internalNode:
-		for i := 0; i < level; i++ {
-			fmt.Print("  - ")
-		}
-		fmt.Printf("Internal node %p: %+v\n", n, n)
-		for _, k := range n.(*internalNode).keys {
-			for i := 0; i < level; i++ {
-				fmt.Print("  --")
-			}
-			fmt.Printf(" %+v\n", k)
-		}
-		for _, cn := range n.(*internalNode).nodes {
-			drawChildren(level+1, cn)
-		}
-	case *leafNode:
-		for i := 0; i < level; i++ {
-			fmt.Print("  + ")
-		}
-		fmt.Printf("Leaf node %p: %+v\n", n, n)
-		for _, k := range n.(*leafNode).keys {
-			for i := 0; i < level; i++ {
-				fmt.Print("  ++")
-			}
-			fmt.Printf(" %+v\n", k)
-		}
-	}
-}
-
 type testKey struct {
 	value int
 }
@@ -337,7 +308,15 @@ func TestTreeIter(t *testing.T) {
 	for i := 0; i < 16; i += 1 {
 		tree.Insert(&testKey{value: i})
 	}
-	drawChildren(0, tree.root)
+	checkDump(t, tree, `internal 0 [&{9}]
+  internal 1 [&{3} &{6}]
+    leaf 2 [&{0} &{1} &{2}]
+    leaf 3 [&{3} &{4} &{5}]
+    leaf 4 [&{6} &{7} &{8}]
+  internal 5 [&{12}]
+    leaf 6 [&{9} &{10} &{11}]
+    leaf 7 [&{12} &{13} &{14} &{15}]
+`)
 	tree.Remove(&testKey{value: 2})
 	tree.Remove(&testKey{value: 1})
 	tree.Remove(&testKey{value: 0})
@@ -350,9 +329,19 @@ func TestTreeIter(t *testing.T) {
 	tree.Remove(&testKey{value: 3})
 	tree.Remove(&testKey{value: 6})
 	tree.Remove(&testKey{value: 12})
-	fmt.Println("--------------------------------------------")
-	drawChildren(0, tree.root)
-	fmt.Println("--------------------------------------------")
+	checkDump(t, tree, "leaf 0 [&{7} &{8} &{9} &{13}]\n")
+}
+
+// checkDump compares the text dump of tree with want.
+func checkDump(t *testing.T, tree *BTree, want string) {
+	t.Helper()
+	var b strings.Builder
+	if err := tree.Dump(&b, DumpText); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != want {
+		t.Fatalf("Text dump:\n%s\nwant:\n%s", b.String(), want)
+	}
 }
 
 func TestTreeBigRemove(t *testing.T) {
@@ -367,9 +356,7 @@ func TestTreeBigRemove(t *testing.T) {
 	for i := 0; i < 4096; i += 1 {
 		tree.Remove(keys[i])
 	}
-	fmt.Println("--------------------------------------------")
-	drawChildren(0, tree.root)
-	fmt.Println("--------------------------------------------")
+	checkDump(t, tree, "leaf 0 []\n")
 }
 
 func BenchmarkTreeGet(b *testing.B) {
@@ -396,6 +383,25 @@ func BenchmarkTreeInsertSequential(b *testing.B) {
 	}
 }
 
+func BenchmarkTreeInsertSequentialAppends(b *testing.B) {
+	for _, detect := range []bool{true, false} {
+		b.Run(fmt.Sprintf("Detect=%v", detect), func(b *testing.B) {
+			tree, _ := New(WithOrder(32), WithAppendDetection(detect))
+			keys := make([]*testKey, b.N)
+			for i := 0; i < b.N; i += 1 {
+				keys[i] = &testKey{value: i}
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i += 1 {
+				tree.Insert(keys[i])
+			}
+			b.StopTimer()
+			_, fill := leafFill(tree)
+			b.ReportMetric(fill, "fill")
+		})
+	}
+}
+
 func BenchmarkTreeRemoveSequential(b *testing.B) {
 	tree := NewBTree(4)
 	keys := make([]*testKey, b.N)
@@ -410,3 +416,190 @@ func BenchmarkTreeRemoveSequential(b *testing.B) {
 		tree.Remove(keys[i])
 	}
 }
+
+func checkTree(t *testing.T, tree *BTree, want map[int]bool) {
+	var got []int
+	for it := tree.Scan(nil, nil); it.Next(); {
+		got = append(got, it.Key().(*testKey).value)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Got %d keys from the leaves instead of expected %d", len(got), len(want))
+	}
+	for i := 1; i < len(got); i++ {
+		if got[i-1] >= got[i] {
+			t.Fatalf("Got key %d after key %d", got[i], got[i-1])
+		}
+	}
+	for v := range want {
+		if k := tree.Get(&testKey{value: v}); k == nil || k.(*testKey).value != v {
+			t.Fatalf("Failed to get key %d", v)
+		}
+	}
+}
+
+func TestTreeRandomInsertRemove(t *testing.T) {
+	for _, d := range []uint{3, 4, 5, 8, 16} {
+		r := rand.New(rand.NewSource(int64(d)))
+		tree := NewBTree(d)
+		want := make(map[int]bool)
+		for i := 0; i < 5000; i++ {
+			v := r.Intn(500)
+			if r.Intn(2) == 0 {
+				if !want[v] {
+					tree.Insert(&testKey{value: v})
+					want[v] = true
+				}
+			} else {
+				tree.Remove(&testKey{value: v})
+				delete(want, v)
+			}
+			if i%50 == 0 {
+				checkTree(t, tree, want)
+			}
+		}
+		checkTree(t, tree, want)
+		if tree.Get(&testKey{value: 1000}) != nil {
+			t.Fatalf("Got a key that was never inserted")
+		}
+	}
+}
+
+func BenchmarkTreeInsertPolicies(b *testing.B) {
+	for _, bc := range []struct {
+		name   string
+		policy SplitPolicy
+	}{
+		{"Midpoint", SplitMidpoint},
+		{"Right", SplitRight},
+		{"Left", SplitLeft},
+		{"Adaptive", SplitAdaptive},
+	} {
+		for _, order := range []string{"Sequential", "Random"} {
+			b.Run(bc.name+"/"+order, func(b *testing.B) {
+				tree, _ := New(WithOrder(32), WithSplitPolicy(bc.policy))
+				keys := make([]*testKey, b.N)
+				for i := 0; i < b.N; i += 1 {
+					keys[i] = &testKey{value: i}
+				}
+				if order == "Random" {
+					rand.Shuffle(len(keys), func(i, j int) { keys[i], keys[j] = keys[j], keys[i] })
+				}
+				b.ResetTimer()
+				for i := 0; i < b.N; i += 1 {
+					tree.Insert(keys[i])
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkTreeRemovePolicies(b *testing.B) {
+	for _, bc := range []struct {
+		name   string
+		policy MergePolicy
+	}{
+		{"Eager", MergeEager},
+		{"Lazy", MergeLazy},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			tree, _ := New(WithOrder(32), WithMergePolicy(bc.policy))
+			keys := make([]*testKey, b.N)
+			for i := 0; i < b.N; i += 1 {
+				keys[i] = &testKey{value: i}
+				tree.Insert(keys[i])
+			}
+			rand.Shuffle(len(keys), func(i, j int) { keys[i], keys[j] = keys[j], keys[i] })
+			b.ResetTimer()
+			for i := 0; i < b.N; i += 1 {
+				tree.Remove(keys[i])
+			}
+		})
+	}
+}
+
+func TestTreeGetMissing(t *testing.T) {
+	tree := NewBTree(4)
+	for v := 0; v < 100; v += 2 {
+		tree.Insert(&testKey{value: v})
+	}
+	for v := 1; v < 100; v += 2 {
+		if k := tree.Get(&testKey{value: v}); k != nil {
+			t.Fatalf("Got %v for missing key %d", k, v)
+		}
+	}
+}
+
+// leafChain returns the values of the keys in the leaves of tree, following
+// the links from its first leaf, and checks that each leaf links back to the
+// one before it.
+func leafChain(t *testing.T, tree *BTree) []int {
+	n := tree.root
+	for {
+		in, ok := n.(*internalNode)
+		if !ok {
+			break
+		}
+		n = in.nodes[0]
+	}
+	var vs []int
+	var prev *leafNode
+	for l := n.(*leafNode); l != nil; prev, l = l, l.next {
+		if l.previous != prev {
+			t.Fatalf("Leaf %p links back to %p instead of %p", l, l.previous, prev)
+		}
+		for _, k := range l.keys {
+			vs = append(vs, k.(*testKey).value)
+		}
+	}
+	return vs
+}
+
+func TestTreeLeafChain(t *testing.T) {
+	tree := NewBTree(3)
+	for _, v := range rand.New(rand.NewSource(1)).Perm(200) {
+		tree.Insert(&testKey{value: v})
+	}
+	vs := leafChain(t, tree)
+	if len(vs) != 200 {
+		t.Fatalf("Got %d keys from the leaf chain instead of expected %d", len(vs), 200)
+	}
+	for i, v := range vs {
+		if v != i {
+			t.Fatalf("Got key %d at position %d of the leaf chain", v, i)
+		}
+	}
+}
+
+func TestTreeRemoveRebalance(t *testing.T) {
+	for _, d := range []uint{3, 4, 5, 8} {
+		r := rand.New(rand.NewSource(int64(d)))
+		tree := NewBTree(d)
+		want := make(map[int]bool)
+		for i := 0; i < 3000; i++ {
+			v := r.Intn(300)
+			if r.Intn(2) == 0 {
+				if !want[v] {
+					tree.Insert(&testKey{value: v})
+					want[v] = true
+				}
+			} else {
+				tree.Remove(&testKey{value: v})
+				delete(want, v)
+			}
+		}
+		vs := leafChain(t, tree)
+		if len(vs) != len(want) {
+			t.Fatalf("Got %d keys from the leaf chain instead of expected %d", len(vs), len(want))
+		}
+		for i, v := range vs {
+			if !want[v] || i > 0 && vs[i-1] >= v {
+				t.Fatalf("Got unexpected key %d at position %d of the leaf chain", v, i)
+			}
+		}
+		for v := 0; v < 300; v++ {
+			if got := tree.Get(&testKey{value: v}) != nil; got != want[v] {
+				t.Fatalf("Get(%d) found %v, want %v", v, got, want[v])
+			}
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package btree
+
+// nodePool holds the nodes a tree has dropped, ready to be reused. Nodes keep
+// their backing arrays, cleared so that they hold on to no keys.
+type nodePool struct {
+	leaves      []*leafNode
+	bytesLeaves []*bytesLeafNode
+	typed       []typedLeaf
+	internals   []*internalNode
+}
+
+// allocLeaf returns an empty leaf of order b, from the pool when there is one.
+func (c *Config) allocLeaf(b uint) *leafNode {
+	var n *leafNode
+	if p := c.nodePool(); p != nil && len(p.leaves) > 0 && cap(p.leaves[len(p.leaves)-1].keys) == int(b) {
+		n = p.leaves[len(p.leaves)-1]
+		p.leaves = p.leaves[:len(p.leaves)-1]
+	} else {
+		n = newLeafNode(b)
+	}
+	n.cfg = c
+	return n
+}
+
+// allocBytesLeaf returns an empty prefix-compressed leaf of order b.
+func (c *Config) allocBytesLeaf(b uint) *bytesLeafNode {
+	var n *bytesLeafNode
+	if p := c.nodePool(); p != nil && len(p.bytesLeaves) > 0 && cap(p.bytesLeaves[len(p.bytesLeaves)-1].suffixes) == int(b) {
+		n = p.bytesLeaves[len(p.bytesLeaves)-1]
+		p.bytesLeaves = p.bytesLeaves[:len(p.bytesLeaves)-1]
+	} else {
+		n = newBytesLeafNode(b)
+	}
+	n.cfg = c
+	return n
+}
+
+// allocInternal returns an empty internal node of order b.
+func (c *Config) allocInternal(b uint) *internalNode {
+	var n *internalNode
+	if p := c.nodePool(); p != nil && len(p.internals) > 0 && cap(p.internals[len(p.internals)-1].keys) == int(b) {
+		n = p.internals[len(p.internals)-1]
+		p.internals = p.internals[:len(p.internals)-1]
+	} else {
+		n = newInternalNode(b)
+	}
+	n.cfg = c
+	return n
+}
+
+// release puts a node the tree no longer links to back in the pool. Internal
+// nodes go without their children.
+func (c *Config) release(n node) {
+	p := c.nodePool()
+	if p == nil {
+		return
+	}
+	switch n := n.(type) {
+	case *leafNode:
+		clear(n.keys[:cap(n.keys)])
+		*n = leafNode{keys: n.keys[:0]}
+		p.leaves = append(p.leaves, n)
+	case *bytesLeafNode:
+		clear(n.suffixes[:cap(n.suffixes)])
+		*n = bytesLeafNode{suffixes: n.suffixes[:0]}
+		p.bytesLeaves = append(p.bytesLeaves, n)
+	case typedLeaf:
+		n.clearNode()
+		p.typed = append(p.typed, n)
+	case *internalNode:
+		n.sliceKeys(0, 0)
+		clear(n.keys[:cap(n.keys)])
+		clear(n.nodes[:cap(n.nodes)])
+		*n = internalNode{keys: n.keys[:0], nodes: n.nodes[:0], seps: n.seps}
+		p.internals = append(p.internals, n)
+	}
+}
+
+// releaseAll puts n and every node below it back in the pool.
+func releaseAll(c *Config, n node) {
+	if in, ok := n.(*internalNode); ok {
+		for _, child := range in.nodes {
+			releaseAll(c, child)
+		}
+	}
+	c.release(n)
+}
+
+func (c *Config) nodePool() *nodePool {
+	if c == nil {
+		return nil
+	}
+	return c.pool
+}
@@ -0,0 +1,77 @@
+package btree
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestNodePool(t *testing.T) {
+	tree, err := New(WithOrder(8), WithNodePool())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks := make([]*testKey, 10000)
+	want := make(map[int]bool)
+	for i := range ks {
+		ks[i] = &testKey{value: i}
+		want[i] = true
+	}
+	for _, i := range rand.New(rand.NewSource(1)).Perm(len(ks)) {
+		tree.Insert(ks[i])
+	}
+	for i := 0; i < len(ks); i += 2 {
+		tree.Remove(ks[i])
+		delete(want, i)
+	}
+	checkTree(t, tree, want)
+	if p := tree.cfg.pool; len(p.leaves) == 0 {
+		t.Fatalf("Got no leaves in the pool after merges")
+	}
+
+	// Refilling a cleared tree takes all of its nodes from the pool.
+	allocs := testing.AllocsPerRun(5, func() {
+		tree.Clear()
+		for _, k := range ks {
+			tree.Insert(k)
+		}
+	})
+	if allocs > 0 {
+		t.Fatalf("Got %v allocations refilling a cleared tree", allocs)
+	}
+	for i := range ks {
+		want[i] = true
+	}
+	checkTree(t, tree, want)
+
+	tree.Clear()
+	checkTree(t, tree, map[int]bool{})
+}
+
+func BenchmarkTreeRefill(b *testing.B) {
+	for _, pooled := range []bool{false, true} {
+		name := "Plain"
+		opts := []Option{WithOrder(16)}
+		if pooled {
+			name = "Pool"
+			opts = append(opts, WithNodePool())
+		}
+		b.Run(name, func(b *testing.B) {
+			tree, _ := New(opts...)
+			ks := make([]*testKey, 10000)
+			for i, v := range rand.New(rand.NewSource(1)).Perm(len(ks)) {
+				ks[i] = &testKey{value: v}
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				tree.Clear()
+				for _, k := range ks {
+					tree.Insert(k)
+				}
+				for _, k := range ks[:len(ks)/2] {
+					tree.Remove(k)
+				}
+			}
+		})
+	}
+}
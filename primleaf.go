@@ -0,0 +1,415 @@
+package btree
+
+import (
+	"encoding/binary"
+	"reflect"
+	"slices"
+)
+
+// Int64 is a key compared as a signed integer.
+type Int64 int64
+
+func (i Int64) Less(other key) bool {
+	return i < other.(Int64)
+}
+
+func (i Int64) Compare(other key) int {
+	return compareOrdered(i, other.(Int64))
+}
+
+// EncodeInt64 is an Encoder for trees of Int64 keys. Keys are stored
+// big-endian with the sign bit flipped, so that they sort bytewise.
+func EncodeInt64(k key) (kb, vb []byte) {
+	return binary.BigEndian.AppendUint64(nil, uint64(k.(Int64))^1<<63), nil
+}
+
+// Uint64 is a key compared as an unsigned integer.
+type Uint64 uint64
+
+func (u Uint64) Less(other key) bool {
+	return u < other.(Uint64)
+}
+
+func (u Uint64) Compare(other key) int {
+	return compareOrdered(u, other.(Uint64))
+}
+
+// EncodeUint64 is an Encoder for trees of Uint64 keys, stored big-endian.
+func EncodeUint64(k key) (kb, vb []byte) {
+	return binary.BigEndian.AppendUint64(nil, uint64(k.(Uint64))), nil
+}
+
+// primKey is the set of key types held in primLeafNodes.
+type primKey interface {
+	Int64 | Uint64 | String
+	key
+}
+
+func compareOrdered[T primKey](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// linearSearchMax is the longest run of integer keys searched linearly rather
+// than by bisection. Strings are always bisected, since each comparison may
+// read a whole string.
+const linearSearchMax = 32
+
+// searchOrdered returns the index of the first of ks not less than x.
+func searchOrdered[T primKey](ks []T, x T) int {
+	if _, ok := any(x).(String); !ok && len(ks) <= linearSearchMax {
+		// Counting the smaller keys compiles to a loop without branches on
+		// the keys themselves.
+		n := 0
+		for _, k := range ks {
+			if k < x {
+				n++
+			}
+		}
+		return n
+	}
+	// Bisect without branching on the comparisons, which random keys would
+	// mispredict half of the time.
+	base, n := 0, len(ks)
+	if n == 0 {
+		return 0
+	}
+	for n > 1 {
+		half := n / 2
+		if ks[base+half] < x {
+			base += half
+		}
+		n -= half
+	}
+	if ks[base] < x {
+		base++
+	}
+	return base
+}
+
+// searchKeys is keys.Search for keys all of type T. Asserting the concrete
+// type of each key is much cheaper than calling Compare through the key
+// interface.
+func searchKeys[T primKey](ks keys, x T) int {
+	lo, hi := 0, len(ks)
+	for lo < hi {
+		m := int(uint(lo+hi) >> 1)
+		if ks[m].(T) < x {
+			lo = m + 1
+		} else {
+			hi = m
+		}
+	}
+	return lo
+}
+
+// primSeps holds the separators of an internal node unboxed, so that
+// searches of trees of primitive keys do not follow a pointer per key.
+type primSeps interface {
+	search(x key) int
+	route(x key, children int) (int, int)
+	set(ks keys)
+}
+
+type primSepsOf[T primKey] struct {
+	ks []T
+}
+
+func (s *primSepsOf[T]) search(x key) int {
+	return searchOrdered(s.ks, x.(T))
+}
+
+// route is internalNode.searchKNIndex on the unboxed separators.
+func (s *primSepsOf[T]) route(x key, children int) (int, int) {
+	v := x.(T)
+	switch idx := searchOrdered(s.ks, v); {
+	case idx == len(s.ks):
+		return idx - 1, children - 1
+	case v < s.ks[idx]:
+		return idx, idx
+	default:
+		return idx, idx + 1
+	}
+}
+
+func (s *primSepsOf[T]) set(ks keys) {
+	clear(s.ks)
+	s.ks = s.ks[:0]
+	for _, k := range ks {
+		s.ks = append(s.ks, k.(T))
+	}
+}
+
+// resync copies the separators of n to its unboxed mirror. It is called by the
+// methods that change the keys of internal nodes, and by nothing else.
+func (n *internalNode) resync() {
+	if n.seps == nil {
+		if len(n.keys) == 0 {
+			return
+		}
+		switch n.keys[0].(type) {
+		case Int64:
+			n.seps = &primSepsOf[Int64]{ks: make([]Int64, 0, cap(n.keys))}
+		case Uint64:
+			n.seps = &primSepsOf[Uint64]{ks: make([]Uint64, 0, cap(n.keys))}
+		case String:
+			n.seps = &primSepsOf[String]{ks: make([]String, 0, cap(n.keys))}
+		default:
+			return
+		}
+	}
+	n.seps.set(n.keys)
+}
+
+// primLeafTypes are the key types that get primLeafNodes.
+var primLeafTypes = map[reflect.Type]bool{
+	reflect.TypeOf(Int64(0)):   true,
+	reflect.TypeOf(Uint64(0)):  true,
+	reflect.TypeOf(String("")): true,
+}
+
+// allocPrimLeaf returns an empty leaf of order b for the key type t.
+func (c *Config) allocPrimLeaf(t reflect.Type, b uint) node {
+	switch t {
+	case reflect.TypeOf(Int64(0)):
+		return allocPrimLeafNode[Int64](c, b)
+	case reflect.TypeOf(Uint64(0)):
+		return allocPrimLeafNode[Uint64](c, b)
+	}
+	return allocPrimLeafNode[String](c, b)
+}
+
+func allocPrimLeafNode[T primKey](c *Config, b uint) *primLeafNode[T] {
+	var n *primLeafNode[T]
+	if p := c.nodePool(); p != nil && len(p.typed) > 0 {
+		top, ok := p.typed[len(p.typed)-1].(*primLeafNode[T])
+		if ok && cap(top.keys) == int(b) {
+			n = top
+			p.typed = p.typed[:len(p.typed)-1]
+		}
+	}
+	if n == nil {
+		n = &primLeafNode[T]{keys: make([]T, 0, b)}
+	}
+	n.cfg = c
+	return n
+}
+
+// typedLeaf is implemented by every primLeafNode, whatever its key type, for
+// the code that handles each kind of leaf.
+type typedLeaf interface {
+	node
+	nextLeaf() node
+	previousLeaf() node
+	unlink()
+	count() int
+	capacity() int
+	at(i int) key
+	newBefore() node
+	setKeys(ks keys)
+	clearNode()
+}
+
+// primLeafNode is a leaf of Int64, Uint64 or String keys, held unboxed in a
+// contiguous array so that searches compare them directly.
+type primLeafNode[T primKey] struct {
+	keys           []T
+	next, previous *primLeafNode[T]
+	cfg            *Config
+	last           int // position of the last insert, for SplitAdaptive
+	summed
+}
+
+func (n *primLeafNode[T]) as(o node) *primLeafNode[T] {
+	on, ok := o.(*primLeafNode[T])
+	if !ok {
+		corrupt("expected a leaf node of the same key type")
+	}
+	return on
+}
+
+func (n *primLeafNode[T]) Insert(k key) {
+	x := k.(T)
+	n.last = searchOrdered(n.keys, x)
+	n.keys = slices.Insert(n.keys, n.last, x)
+	n.sum.add(n.cfg.hashKey(x))
+}
+
+func (n *primLeafNode[T]) Remove(k key) {
+	x := k.(T)
+	if i := searchOrdered(n.keys, x); i < len(n.keys) && n.keys[i] == x {
+		n.keys = slices.Delete(n.keys, i, i+1)
+		n.sum.sub(n.cfg.hashKey(x))
+	}
+}
+
+func (n *primLeafNode[T]) Search(k key) int {
+	return searchOrdered(n.keys, k.(T))
+}
+
+func (n *primLeafNode[T]) Get(k key) key {
+	x := k.(T)
+	if i := searchOrdered(n.keys, x); i < len(n.keys) && n.keys[i] == x {
+		return n.keys[i]
+	}
+	return nil
+}
+
+func (n *primLeafNode[T]) GetLowestLeaf() key {
+	return n.keys[0]
+}
+
+func (n *primLeafNode[T]) Keys() keys {
+	ks := make(keys, len(n.keys))
+	for i, k := range n.keys {
+		ks[i] = k
+	}
+	return ks
+}
+
+func (n *primLeafNode[T]) Less(o node) bool {
+	return n.keys[len(n.keys)-1] < o.GetLowestLeaf().(T)
+}
+
+func (n *primLeafNode[T]) Split() (key, node, node) {
+	if len(n.keys) < 2 {
+		corrupt("leaf node too small to split")
+	}
+
+	mid := n.cfg.splitIndex(len(n.keys), n.last, func() bool { return n.next == nil })
+	key := splitKey(n.keys[mid-1], n.keys[mid])
+
+	left := allocPrimLeafNode[T](n.cfg, uint(cap(n.keys)))
+	left.keys = append(left.keys, n.keys[:mid]...)
+	left.previous, left.next = n.previous, n
+	if n.previous != nil {
+		n.previous.next = left
+	}
+
+	right := n
+	right.previous = left
+	right.keys = slices.Delete(n.keys, 0, mid)
+	n.cfg.shift(left, right, 0, len(left.keys))
+	return key, left, right
+}
+
+func (n *primLeafNode[T]) Merge(parent key, toMerge node) key {
+	mn := n.as(toMerge)
+	// Linked siblings merge in list order; unlinked ones by their keys.
+	after := n.next == mn
+	if !after && n.previous != mn {
+		after = len(n.keys) == 0 || len(mn.keys) > 0 && n.Less(mn)
+	}
+	if after {
+		n.keys = append(n.keys, mn.keys...)
+		n.next = mn.next
+		if mn.next != nil {
+			mn.next.previous = n
+		}
+	} else {
+		n.keys = slices.Insert(n.keys, 0, mn.keys...)
+		n.previous = mn.previous
+		if mn.previous != nil {
+			mn.previous.next = n
+		}
+	}
+	n.sum.add(mn.sum)
+	return n.keys[0]
+}
+
+// Rebalances to the tail of this node, removing items from the head of other.
+func (n *primLeafNode[T]) RebalanceToTail(other node) key {
+	mn := n.as(other)
+	moveIdx := rebalanceCount(len(mn.keys), len(n.keys))
+	n.keys = append(n.keys, mn.keys[:moveIdx]...)
+	n.cfg.shift(n, mn, len(n.keys)-moveIdx, len(n.keys))
+	mn.keys = slices.Delete(mn.keys, 0, moveIdx)
+	return mn.keys[0]
+}
+
+// Rebalances to the head of this node, removing items from the tail of other.
+func (n *primLeafNode[T]) RebalanceToHead(other node) key {
+	mn := n.as(other)
+	moveIdx := len(mn.keys) - rebalanceCount(len(mn.keys), len(n.keys))
+	n.keys = slices.Insert(n.keys, 0, mn.keys[moveIdx:]...)
+	n.cfg.shift(n, mn, 0, len(mn.keys)-moveIdx)
+	mn.keys = slices.Delete(mn.keys, moveIdx, len(mn.keys))
+	return n.keys[0]
+}
+
+func (n *primLeafNode[T]) IsFull() bool {
+	return len(n.keys) == cap(n.keys)
+}
+
+func (n *primLeafNode[T]) IsEmpty() bool {
+	return n.cfg.underfull(len(n.keys), cap(n.keys))
+}
+
+func (n *primLeafNode[T]) CanMerge(other node) bool {
+	if o, ok := other.(*primLeafNode[T]); ok {
+		return len(n.keys)+len(o.keys) <= cap(n.keys)
+	}
+	return false
+}
+
+func (n *primLeafNode[T]) nextLeaf() node {
+	if n.next == nil {
+		return nil
+	}
+	return n.next
+}
+
+func (n *primLeafNode[T]) previousLeaf() node {
+	if n.previous == nil {
+		return nil
+	}
+	return n.previous
+}
+
+func (n *primLeafNode[T]) unlink() {
+	if n.previous != nil {
+		n.previous.next = n.next
+	}
+	if n.next != nil {
+		n.next.previous = n.previous
+	}
+}
+
+func (n *primLeafNode[T]) count() int {
+	return len(n.keys)
+}
+
+func (n *primLeafNode[T]) capacity() int {
+	return cap(n.keys)
+}
+
+func (n *primLeafNode[T]) at(i int) key {
+	return n.keys[i]
+}
+
+func (n *primLeafNode[T]) newBefore() node {
+	l := allocPrimLeafNode[T](n.cfg, uint(cap(n.keys)))
+	l.previous, l.next = n.previous, n
+	if n.previous != nil {
+		n.previous.next = l
+	}
+	n.previous = l
+	return l
+}
+
+func (n *primLeafNode[T]) setKeys(ks keys) {
+	n.keys = n.keys[:0]
+	for _, k := range ks {
+		n.keys = append(n.keys, k.(T))
+	}
+}
+
+func (n *primLeafNode[T]) clearNode() {
+	clear(n.keys[:cap(n.keys)])
+	*n = primLeafNode[T]{keys: n.keys[:0]}
+}
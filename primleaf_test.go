@@ -0,0 +1,199 @@
+package btree
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+	"unsafe"
+)
+
+func TestPrimLeafTrees(t *testing.T) {
+	for name, mk := range map[string]func(int) key{
+		"int64":  func(v int) key { return Int64(v - 250) },
+		"uint64": func(v int) key { return Uint64(v) },
+		"string": func(v int) key { return String(fmt.Sprintf("k%04d", v)) },
+	} {
+		tree, err := New(WithOrder(8), WithNodePool())
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := rand.New(rand.NewSource(1))
+		want := make(map[int]bool)
+		for i := 0; i < 5000; i++ {
+			v := r.Intn(500)
+			if r.Intn(2) == 0 {
+				if !want[v] {
+					tree.Insert(mk(v))
+					want[v] = true
+				}
+			} else {
+				tree.Remove(mk(v))
+				delete(want, v)
+			}
+		}
+		checkSeps(t, tree.root)
+		if _, ok := rightmostLeaf(tree.root).(typedLeaf); !ok {
+			t.Fatalf("%s: Got leaf %T instead of a typed leaf", name, rightmostLeaf(tree.root))
+		}
+
+		var vs []int
+		for v := range want {
+			vs = append(vs, v)
+		}
+		sort.Ints(vs)
+		i := 0
+		for it := tree.Scan(nil, nil); it.Next(); i++ {
+			if i >= len(vs) || it.Key() != mk(vs[i]) {
+				t.Fatalf("%s: Got key %v at position %d", name, it.Key(), i)
+			}
+		}
+		if i != len(vs) {
+			t.Fatalf("%s: Got %d keys instead of expected %d", name, i, len(vs))
+		}
+		for v := 0; v < 500; v++ {
+			if k := tree.Get(mk(v)); (k != nil) != want[v] {
+				t.Fatalf("%s: Got key %v for %v, expected it to be present: %v", name, k, mk(v), want[v])
+			}
+		}
+		if err := tree.Insert(&testKey{value: 1}); err != ErrKeyTypeMismatch {
+			t.Fatalf("%s: Got error %v instead of expected error %v", name, err, ErrKeyTypeMismatch)
+		}
+	}
+}
+
+// checkSeps checks that the unboxed separators of every internal node from n
+// down match its keys.
+func checkSeps(t *testing.T, n node) {
+	t.Helper()
+	in, ok := n.(*internalNode)
+	if !ok {
+		return
+	}
+	var got keys
+	switch s := in.seps.(type) {
+	case *primSepsOf[Int64]:
+		got = boxSeps(s)
+	case *primSepsOf[Uint64]:
+		got = boxSeps(s)
+	case *primSepsOf[String]:
+		got = boxSeps(s)
+	}
+	if len(got) != len(in.keys) {
+		t.Fatalf("Got %d unboxed separators for %d keys", len(got), len(in.keys))
+	}
+	for i, k := range in.keys {
+		if got[i] != k {
+			t.Fatalf("Got unboxed separator %v for key %v", got[i], k)
+		}
+	}
+	for _, child := range in.nodes {
+		checkSeps(t, child)
+	}
+}
+
+func boxSeps[T primKey](s *primSepsOf[T]) keys {
+	ks := make(keys, len(s.ks))
+	for i, k := range s.ks {
+		ks[i] = k
+	}
+	return ks
+}
+
+func TestPrimLeafGet(t *testing.T) {
+	tree, _ := New(WithOrder(4))
+	for i := 0; i < 100; i++ {
+		tree.Insert(String(fmt.Sprintf("k%03d", i)))
+	}
+	// Get returns the key held by the tree, not the one it was asked for.
+	probe := String(fmt.Sprintf("k%03d", 42))
+	got := tree.Get(probe).(String)
+	if got != probe || unsafe.StringData(string(got)) == unsafe.StringData(string(probe)) {
+		t.Fatalf("Get(%q) returned %q from the probe", probe, got)
+	}
+}
+
+func TestSearchOrdered(t *testing.T) {
+	for _, n := range []int{0, 1, 5, linearSearchMax, linearSearchMax + 1, 100} {
+		ks := make([]Int64, n)
+		boxed := make(keys, n)
+		for i := range ks {
+			ks[i] = Int64(i * 2)
+			boxed[i] = ks[i]
+		}
+		for x := Int64(-1); x <= Int64(n*2); x++ {
+			want := sort.Search(n, func(i int) bool { return ks[i] >= x })
+			if got := searchOrdered(ks, x); got != want {
+				t.Fatalf("Got index %d instead of expected %d for %d in %d keys", got, want, x, n)
+			}
+			if got := boxed.Search(x); got != want {
+				t.Fatalf("Got index %d instead of expected %d for %d in %d boxed keys", got, want, x, n)
+			}
+		}
+	}
+}
+
+func TestEncodeInt64(t *testing.T) {
+	vs := []Int64{math.MinInt64, -1000, -1, 0, 1, 1000, math.MaxInt64}
+	for i := 1; i < len(vs); i++ {
+		a, _ := EncodeInt64(vs[i-1])
+		b, _ := EncodeInt64(vs[i])
+		if bytes.Compare(a, b) >= 0 {
+			t.Fatalf("Encoded %d does not sort before encoded %d", vs[i-1], vs[i])
+		}
+	}
+}
+
+func BenchmarkTreeKeyTypes(b *testing.B) {
+	for _, kt := range []struct {
+		name string
+		mk   func(int) key
+	}{
+		{"Interface", func(v int) key { return &testKey{value: v} }},
+		{"Int64", func(v int) key { return Int64(v) }},
+		{"String", func(v int) key { return String(fmt.Sprintf("key:%08d", v)) }},
+	} {
+		const n = 1 << 16
+		ks := make([]key, n)
+		for i, v := range rand.New(rand.NewSource(1)).Perm(n) {
+			ks[i] = kt.mk(v)
+		}
+		b.Run(kt.name+"/Insert", func(b *testing.B) {
+			tree := NewBTree(32)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if i%n == 0 {
+					tree.Clear()
+				}
+				tree.Insert(ks[i%n])
+			}
+		})
+		b.Run(kt.name+"/Get", func(b *testing.B) {
+			tree := NewBTree(32)
+			for _, k := range ks {
+				tree.Insert(k)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				tree.Get(ks[i%n])
+			}
+		})
+		b.Run(kt.name+"/Scan", func(b *testing.B) {
+			tree := NewBTree(32)
+			for _, k := range ks {
+				tree.Insert(k)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			it := tree.Scan(nil, nil)
+			for i := 0; i < b.N; i++ {
+				if !it.Next() {
+					it = tree.Scan(nil, nil)
+					it.Next()
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package btree
+
+// Queue is a priority queue kept in a tree, such as a scheduler queue keyed
+// by deadline. Pops take keys straight from the leaf at either end of the
+// tree and only reshape the tree when that leaf runs out of keys, instead of
+// refilling it from its sibling on every other pop as Remove would.
+type Queue struct {
+	t *BTree
+	n int
+}
+
+// NewQueue returns an empty queue whose tree is configured by opts. Size
+// limits are refused with ErrInvalidLimit, since evictions would drop keys
+// behind the queue's count.
+func NewQueue(opts ...Option) (*Queue, error) {
+	t, err := New(opts...)
+	if err != nil {
+		return nil, err
+	}
+	if t.cfg.bounded() {
+		return nil, ErrInvalidLimit
+	}
+	return &Queue{t: t}, nil
+}
+
+// Len returns the number of keys in the queue.
+func (q *Queue) Len() int {
+	return q.n
+}
+
+// Push adds k to the queue. Keys equal to one already queued are kept too.
+func (q *Queue) Push(k Key) error {
+	if err := q.t.Insert(k); err != nil {
+		return err
+	}
+	q.n++
+	return nil
+}
+
+// PeekMin returns the smallest key in the queue, or nil if it is empty or
+// its tree is corrupt.
+func (q *Queue) PeekMin() Key {
+	leaf := q.t.endLeaf(false)
+	if leafLen(leaf) == 0 {
+		return nil
+	}
+	return leafAt(leaf, 0)
+}
+
+// PeekMax returns the largest key in the queue, or nil if it is empty or
+// its tree is corrupt.
+func (q *Queue) PeekMax() Key {
+	leaf := q.t.endLeaf(true)
+	if n := leafLen(leaf); n > 0 {
+		return leafAt(leaf, n-1)
+	}
+	return nil
+}
+
+// PopMin removes and returns the smallest key in the queue, or nil if it is
+// empty.
+func (q *Queue) PopMin() (Key, error) {
+	return q.pop(false)
+}
+
+// PopMax removes and returns the largest key in the queue, or nil if it is
+// empty.
+func (q *Queue) PopMax() (Key, error) {
+	return q.pop(true)
+}
+
+// PopMinN removes and returns up to n of the smallest keys in the queue, in
+// ascending order.
+func (q *Queue) PopMinN(n int) ([]Key, error) {
+	var ks []Key
+	for len(ks) < n {
+		k, err := q.PopMin()
+		if k == nil || err != nil {
+			return ks, err
+		}
+		ks = append(ks, k)
+	}
+	return ks, nil
+}
+
+// PopWhile removes and returns the smallest keys in the queue for as long as
+// pred reports true for them, in ascending order.
+func (q *Queue) PopWhile(pred func(Key) bool) ([]Key, error) {
+	var ks []Key
+	for {
+		k := q.PeekMin()
+		if k == nil || !pred(k) {
+			return ks, nil
+		}
+		if _, err := q.PopMin(); err != nil {
+			return ks, err
+		}
+		ks = append(ks, k)
+	}
+}
+
+func (q *Queue) pop(max bool) (Key, error) {
+	k, err := q.t.popEnd(max)
+	if k != nil {
+		q.n--
+	}
+	return k, err
+}
+
+// endLeaf returns the leftmost leaf of the tree, or the rightmost one when
+// max is set, or nil if the tree turns out to be corrupt.
+func (t *BTree) endLeaf(max bool) node {
+	if t.tryDrain() != nil {
+		return nil
+	}
+	if max {
+		return rightmostLeaf(t.root)
+	}
+	n := t.root
+	for {
+		in, ok := n.(*internalNode)
+		if !ok {
+			return n
+		}
+		n = in.nodes[0]
+	}
+}
+
+// popEnd removes and returns the smallest key of the tree, or the largest one
+// when max is set, or nil if the tree is empty. The key is taken straight
+// from its leaf; only a leaf left with no keys is dropped, with its
+// ancestors fixed up as after a removal.
+func (t *BTree) popEnd(max bool) (k key, err error) {
+	defer catch(&err)
+
+	t.drain()
+	var path []*internalNode
+	var idx []int
+	n := t.root
+	for {
+		in, ok := n.(*internalNode)
+		if !ok {
+			break
+		}
+		i := 0
+		if max {
+			i = len(in.nodes) - 1
+		}
+		path, idx = append(path, in), append(idx, i)
+		n = in.nodes[i]
+	}
+
+	c := leafLen(n)
+	if c == 0 {
+		if len(path) > 0 {
+			corrupt("empty leaf below the root")
+		}
+		return nil, nil
+	}
+	if max {
+		k = leafAt(n, c-1)
+	} else {
+		k = leafAt(n, 0)
+	}
+	before := *n.sums()
+	n.Remove(k)
+	d := n.sums().since(before)
+	for _, in := range path {
+		in.sum.add(d)
+	}
+	t.forget(k)
+	if c == 1 {
+		t.rightmost = nil
+		t.version++
+		for i := len(path) - 1; i >= 0; i-- {
+			path[i].fix(idx[i])
+		}
+		t.shrinkRoot()
+	}
+	t.notify(EventDelete, k, nil)
+	return k, nil
+}
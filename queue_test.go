@@ -0,0 +1,117 @@
+package btree
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestQueue(t *testing.T) {
+	for _, policy := range []MergePolicy{MergeEager, MergeLazy} {
+		q, err := NewQueue(WithOrder(4), WithMergePolicy(policy))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if q.PeekMin() != nil || q.PeekMax() != nil {
+			t.Fatalf("Peeked a key in an empty queue")
+		}
+		if k, err := q.PopMin(); k != nil || err != nil {
+			t.Fatalf("Popped key %v with error %v from an empty queue", k, err)
+		}
+
+		r := rand.New(rand.NewSource(1))
+		var want []int
+		for _, v := range r.Perm(2000) {
+			q.Push(&testKey{value: v})
+			want = append(want, v)
+		}
+		sort.Ints(want)
+
+		// Pop from both ends, with pushes in between.
+		for i := 0; i < 1500; i++ {
+			switch r.Intn(3) {
+			case 0:
+				k, err := q.PopMin()
+				if err != nil || k.(*testKey).value != want[0] {
+					t.Fatalf("Popped %v with error %v instead of minimum %d", k, err, want[0])
+				}
+				want = want[1:]
+			case 1:
+				k, err := q.PopMax()
+				if err != nil || k.(*testKey).value != want[len(want)-1] {
+					t.Fatalf("Popped %v with error %v instead of maximum %d", k, err, want[len(want)-1])
+				}
+				want = want[:len(want)-1]
+			default:
+				v := 2000 + i
+				q.Push(&testKey{value: v})
+				want = append(want, v)
+			}
+			if q.Len() != len(want) {
+				t.Fatalf("Got length %d instead of expected %d", q.Len(), len(want))
+			}
+		}
+		if q.PeekMin().(*testKey).value != want[0] || q.PeekMax().(*testKey).value != want[len(want)-1] {
+			t.Fatalf("Peeked %v and %v instead of %d and %d", q.PeekMin(), q.PeekMax(), want[0], want[len(want)-1])
+		}
+		present := make(map[int]bool)
+		for _, v := range want {
+			present[v] = true
+		}
+		checkTree(t, q.t, present)
+
+		ks, err := q.PopMinN(10)
+		if err != nil || len(ks) != 10 || ks[9].(*testKey).value != want[9] {
+			t.Fatalf("Got keys %v with error %v from PopMinN", ks, err)
+		}
+		want = want[10:]
+		limit := want[100]
+		ks, err = q.PopWhile(func(k Key) bool { return k.(*testKey).value < limit })
+		if err != nil || len(ks) != 100 {
+			t.Fatalf("Got %d keys with error %v from PopWhile instead of %d", len(ks), err, 100)
+		}
+		want = want[100:]
+
+		ks, err = q.PopMinN(len(want) + 10)
+		if err != nil || len(ks) != len(want) || q.Len() != 0 || q.PeekMin() != nil {
+			t.Fatalf("Got %d keys with error %v draining %d keys", len(ks), err, len(want))
+		}
+		checkTree(t, q.t, map[int]bool{})
+	}
+
+	// Equal keys are all kept, and counted.
+	q, _ := NewQueue(WithOrder(4))
+	for i := 0; i < 3; i++ {
+		q.Push(&testKey{value: 7})
+	}
+	if ks, _ := q.PopMinN(5); len(ks) != 3 || q.Len() != 0 {
+		t.Fatalf("Popped %d of 3 equal keys, leaving length %d", len(ks), q.Len())
+	}
+
+	if _, err := NewQueue(WithMaxKeys(10)); err != ErrInvalidLimit {
+		t.Fatalf("NewQueue with a size limit returned %v", err)
+	}
+}
+
+func BenchmarkQueuePopMin(b *testing.B) {
+	for _, queue := range []bool{false, true} {
+		name := "Remove"
+		if queue {
+			name = "PopMin"
+		}
+		b.Run(name, func(b *testing.B) {
+			q, _ := NewQueue(WithOrder(32))
+			for i := 0; i < b.N; i++ {
+				q.Push(&testKey{value: i})
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if queue {
+					q.PopMin()
+				} else {
+					q.t.Remove(q.PeekMin())
+				}
+			}
+		})
+	}
+}
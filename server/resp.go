@@ -0,0 +1,145 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+)
+
+const (
+	maxLine = 64 << 10
+	maxBulk = 64 << 20
+	maxArgs = 1 << 20
+
+	// maxAhead bounds the bytes allocated for a bulk string before they
+	// arrive, since its length is only a claim.
+	maxAhead = 64 << 10
+)
+
+var errProtocol = errors.New("protocol error")
+
+// reader reads commands in RESP, as arrays of bulk strings, or inline as
+// words separated by spaces, as typed into telnet.
+type reader struct {
+	*bufio.Reader
+}
+
+func (r reader) line() ([]byte, error) {
+	var line []byte
+	for {
+		part, err := r.ReadSlice('\n')
+		line = append(line, part...)
+		switch {
+		case err == bufio.ErrBufferFull && len(line) < maxLine:
+			continue
+		case err == bufio.ErrBufferFull:
+			return nil, errProtocol
+		case err != nil:
+			return nil, err
+		}
+		return bytes.TrimSuffix(line[:len(line)-1], []byte("\r")), nil
+	}
+}
+
+func (r reader) length(line []byte, prefix byte, max int) (int, error) {
+	if len(line) == 0 || line[0] != prefix {
+		return 0, errProtocol
+	}
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n < -1 || n > max {
+		return 0, errProtocol
+	}
+	return n, nil
+}
+
+// command returns the next command, skipping empty inline lines.
+func (r reader) command() ([][]byte, error) {
+	for {
+		line, err := r.line()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 {
+			continue
+		}
+		if line[0] != '*' {
+			if args := bytes.Fields(line); len(args) > 0 {
+				return args, nil
+			}
+			continue
+		}
+
+		n, err := r.length(line, '*', maxArgs)
+		if err != nil || n < 1 {
+			return nil, errProtocol
+		}
+		// So is the number of arguments, which grow as they arrive.
+		args := make([][]byte, 0, min(n, 16))
+		for len(args) < n {
+			line, err := r.line()
+			if err != nil {
+				return nil, err
+			}
+			size, err := r.length(line, '$', maxBulk)
+			if err != nil || size < 0 {
+				return nil, errProtocol
+			}
+			arg, err := r.bulk(size)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+		}
+		return args, nil
+	}
+}
+
+// bulk reads a bulk string of size bytes and its line ending. The string
+// grows as its bytes are read, so that a large size alone allocates little.
+func (r reader) bulk(size int) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Grow(min(size+2, maxAhead))
+	if _, err := io.CopyN(&buf, r.Reader, int64(size+2)); err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return nil, err
+	}
+	arg := buf.Bytes()
+	if !bytes.HasSuffix(arg, []byte("\r\n")) {
+		return nil, errProtocol
+	}
+	return arg[:size], nil
+}
+
+// writer writes RESP replies.
+type writer struct {
+	*bufio.Writer
+}
+
+func (w writer) simple(s string) {
+	w.WriteString("+" + s + "\r\n")
+}
+
+func (w writer) error(s string) {
+	w.WriteString("-" + s + "\r\n")
+}
+
+func (w writer) int(n int) {
+	w.WriteString(":" + strconv.Itoa(n) + "\r\n")
+}
+
+func (w writer) bulk(b []byte) {
+	w.WriteString("$" + strconv.Itoa(len(b)) + "\r\n")
+	w.Write(b)
+	w.WriteString("\r\n")
+}
+
+func (w writer) null() {
+	w.WriteString("$-1\r\n")
+}
+
+func (w writer) array(n int) {
+	w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
@@ -0,0 +1,516 @@
+// Package server serves a tree over TCP in RESP, the protocol of Redis, so
+// that Redis clients can read and write it.
+//
+// The server supports the commands GET, SET (with EX, PX, NX and XX), DEL,
+// EXISTS, DBSIZE, FLUSHDB, PING, ECHO and QUIT, and the sorted set range
+// commands ZRANGEBYLEX, ZREVRANGEBYLEX and ZLEXCOUNT. The keys of the tree
+// form a single sorted set, so the set named by the range commands is
+// ignored; they return the keys of the tree in the range.
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/highlyunavailable/btree"
+)
+
+// janitorInterval is how often a server removes expired entries.
+const janitorInterval = time.Second
+
+// A Server serves a tree of btree.Entries to RESP clients. The tree must not
+// be used by anything else while it is served.
+type Server struct {
+	mu   sync.Mutex // guards tree and n
+	tree *btree.BTree
+	n    int // entries in tree, including those expired but not yet removed
+
+	stopJanitor func()
+
+	connMu    sync.Mutex
+	listeners map[net.Listener]bool
+	conns     map[net.Conn]bool
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// New returns a server for tree, which must hold only Entries. Entries set
+// with an expiry are removed by a janitor once they expire, until Close.
+func New(tree *btree.BTree) *Server {
+	s := &Server{
+		tree:      tree,
+		listeners: map[net.Listener]bool{},
+		conns:     map[net.Conn]bool{},
+	}
+	// With the expired entries gone, a scan counts every entry.
+	tree.ExpireBefore(tree.Clock().Now())
+	for it := tree.Scan(nil, nil); it.Next(); {
+		s.n++
+	}
+	s.stopJanitor = s.startJanitor()
+	return s
+}
+
+// startJanitor starts a goroutine that removes the expired entries of the
+// tree every janitorInterval of its clock, as btree.StartJanitor does, and
+// takes them off the count. The returned function stops it and waits for it
+// to return.
+func (s *Server) startJanitor() (stop func()) {
+	clock := s.tree.Clock()
+	done, exited := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(exited)
+		for {
+			select {
+			case <-done:
+				return
+			case <-clock.After(janitorInterval):
+			}
+			s.mu.Lock()
+			n, _ := s.tree.ExpireBefore(clock.Now())
+			s.n -= n
+			s.mu.Unlock()
+		}
+	}()
+	return func() {
+		close(done)
+		<-exited
+	}
+}
+
+// ErrServerClosed is returned by Serve and ListenAndServe after Close.
+var ErrServerClosed = errors.New("server: closed")
+
+// ListenAndServe listens on the TCP address addr and serves the clients
+// that connect to it.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve serves the clients that connect to l until Close, when it returns
+// ErrServerClosed.
+func (s *Server) Serve(l net.Listener) error {
+	s.connMu.Lock()
+	if s.closed {
+		s.connMu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = true
+	s.connMu.Unlock()
+
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			s.connMu.Lock()
+			defer s.connMu.Unlock()
+			delete(s.listeners, l)
+			if s.closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		s.connMu.Lock()
+		if s.closed {
+			s.connMu.Unlock()
+			c.Close()
+			continue
+		}
+		s.conns[c] = true
+		s.wg.Add(1)
+		s.connMu.Unlock()
+		go s.serveConn(c)
+	}
+}
+
+// Close stops the server: it closes its listeners and connections, waits
+// for the commands being run to finish and stops the janitor.
+func (s *Server) Close() error {
+	s.connMu.Lock()
+	if s.closed {
+		s.connMu.Unlock()
+		return nil
+	}
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	s.connMu.Unlock()
+
+	s.wg.Wait()
+	s.stopJanitor()
+	return nil
+}
+
+func (s *Server) serveConn(c net.Conn) {
+	defer func() {
+		c.Close()
+		s.connMu.Lock()
+		delete(s.conns, c)
+		s.connMu.Unlock()
+		s.wg.Done()
+	}()
+
+	r := reader{bufio.NewReader(c)}
+	w := writer{bufio.NewWriter(c)}
+	for {
+		args, err := r.command()
+		if err != nil {
+			if errors.Is(err, errProtocol) {
+				w.error("ERR Protocol error")
+				w.Flush()
+			}
+			return
+		}
+		quit := s.run(w, args)
+		// Replies to pipelined commands go out together.
+		if r.Buffered() == 0 || quit {
+			if w.Flush() != nil {
+				return
+			}
+		}
+		if quit {
+			return
+		}
+	}
+}
+
+// commands maps each command to its function and its number of arguments,
+// counting the command itself; a negative number is a minimum.
+var commands = map[string]struct {
+	run   func(s *Server, w writer, args [][]byte)
+	arity int
+}{
+	"PING":           {(*Server).ping, -1},
+	"ECHO":           {(*Server).echo, 2},
+	"GET":            {(*Server).get, 2},
+	"SET":            {(*Server).set, -3},
+	"DEL":            {(*Server).del, -2},
+	"EXISTS":         {(*Server).exists, -2},
+	"DBSIZE":         {(*Server).dbsize, 1},
+	"FLUSHDB":        {(*Server).flushdb, -1},
+	"ZRANGEBYLEX":    {(*Server).zrangebylex, -4},
+	"ZREVRANGEBYLEX": {(*Server).zrevrangebylex, -4},
+	"ZLEXCOUNT":      {(*Server).zlexcount, 4},
+}
+
+// run runs a command and reports whether the client quit.
+func (s *Server) run(w writer, args [][]byte) bool {
+	name := strings.ToUpper(string(args[0]))
+	if name == "QUIT" {
+		w.simple("OK")
+		return true
+	}
+	cmd, ok := commands[name]
+	switch {
+	case !ok:
+		w.error("ERR unknown command '" + string(args[0]) + "'")
+	case cmd.arity > 0 && len(args) != cmd.arity, cmd.arity < 0 && len(args) < -cmd.arity:
+		w.error("ERR wrong number of arguments for '" + strings.ToLower(name) + "' command")
+	default:
+		s.mu.Lock()
+		cmd.run(s, w, args)
+		s.mu.Unlock()
+	}
+	return false
+}
+
+func (s *Server) ping(w writer, args [][]byte) {
+	switch len(args) {
+	case 1:
+		w.simple("PONG")
+	case 2:
+		w.bulk(args[1])
+	default:
+		w.error("ERR wrong number of arguments for 'ping' command")
+	}
+}
+
+func (s *Server) echo(w writer, args [][]byte) {
+	w.bulk(args[1])
+}
+
+// lookup returns the live entry for k, if there is one.
+func (s *Server) lookup(k []byte) *btree.Entry {
+	e, _ := s.tree.Get(&btree.Entry{Key: k}).(*btree.Entry)
+	return e
+}
+
+func (s *Server) get(w writer, args [][]byte) {
+	if e := s.lookup(args[1]); e != nil {
+		w.bulk(e.Value)
+	} else {
+		w.null()
+	}
+}
+
+// set runs SET key value [EX seconds | PX milliseconds] [NX | XX].
+func (s *Server) set(w writer, args [][]byte) {
+	var ttl time.Duration
+	var nx, xx bool
+	for i := 3; i < len(args); i++ {
+		switch opt := strings.ToUpper(string(args[i])); {
+		case opt == "NX":
+			nx = true
+		case opt == "XX":
+			xx = true
+		case (opt == "EX" || opt == "PX") && i+1 < len(args) && ttl == 0:
+			n, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil || n <= 0 {
+				w.error("ERR invalid expire time in 'set' command")
+				return
+			}
+			i++
+			if ttl = time.Duration(n) * time.Millisecond; opt == "EX" {
+				ttl = time.Duration(n) * time.Second
+			}
+		default:
+			w.error("ERR syntax error")
+			return
+		}
+	}
+	if nx && xx {
+		w.error("ERR syntax error")
+		return
+	}
+
+	k := bytes.Clone(args[1])
+	e := &btree.Entry{Key: k, Value: bytes.Clone(args[2])}
+	old := s.lookup(k)
+	if nx && old != nil || xx && old == nil {
+		w.null()
+		return
+	}
+	var err error
+	if _, expires := s.tree.Expiry(e); ttl == 0 && !expires {
+		var replaced btree.Key
+		if replaced, err = s.tree.ReplaceOrInsert(e); err == nil && replaced == nil {
+			s.n++
+		}
+	} else {
+		// The new entry replaces the old one's expiry as well as its value.
+		// An entry with an expiry is in the tree even once it has expired.
+		if old != nil || expires {
+			if err = s.tree.Remove(e); err == nil {
+				s.n--
+			}
+		}
+		if err == nil {
+			if ttl > 0 {
+				err = s.tree.InsertTTL(e, ttl)
+			} else {
+				err = s.tree.Insert(e)
+			}
+		}
+		if err == nil {
+			s.n++
+		}
+	}
+	if err != nil {
+		w.error("ERR " + err.Error())
+		return
+	}
+	w.simple("OK")
+}
+
+func (s *Server) del(w writer, args [][]byte) {
+	n := 0
+	for _, k := range args[1:] {
+		if s.lookup(k) != nil {
+			if err := s.tree.Remove(&btree.Entry{Key: k}); err != nil {
+				w.error("ERR " + err.Error())
+				return
+			}
+			s.n--
+			n++
+		}
+	}
+	w.int(n)
+}
+
+func (s *Server) exists(w writer, args [][]byte) {
+	n := 0
+	for _, k := range args[1:] {
+		if s.lookup(k) != nil {
+			n++
+		}
+	}
+	w.int(n)
+}
+
+// dbsize runs DBSIZE. As in Redis, the count includes the entries that have
+// expired but are not yet removed.
+func (s *Server) dbsize(w writer, args [][]byte) {
+	w.int(s.n)
+}
+
+func (s *Server) flushdb(w writer, args [][]byte) {
+	s.tree.Clear()
+	s.n = 0
+	w.simple("OK")
+}
+
+// lexBound parses a bound of a lexicographical range: - or + for no bound,
+// or a key prefixed by [ when the bound includes it or ( when it does not.
+// It returns the bound as the key that starts or ends a half-open range.
+func lexBound(b []byte, min bool) (k []byte, ok bool) {
+	switch {
+	case len(b) == 1 && b[0] == '-' && min, len(b) == 1 && b[0] == '+' && !min:
+		return nil, true
+	case len(b) == 0 || b[0] != '[' && b[0] != '(':
+		return nil, false
+	}
+	k = b[1:]
+	// The smallest key after k starts or ends the range when k is left out
+	// of the start or kept in the end.
+	if (b[0] == '(') == min {
+		k = append(bytes.Clone(k), 0)
+	}
+	return k, true
+}
+
+// lexRange parses the arguments of a lexicographical range command: min,
+// max and an optional LIMIT offset count, where a negative count means all.
+func lexRange(args [][]byte) (lo, hi *btree.Entry, offset, count int, err string) {
+	count = -1
+	// A min of + or a max of - leaves the range empty.
+	if string(args[0]) == "+" || string(args[1]) == "-" {
+		count = 0
+		args = [][]byte{[]byte("-"), []byte("+")}
+	}
+	lk, ok1 := lexBound(args[0], true)
+	hk, ok2 := lexBound(args[1], false)
+	if !ok1 || !ok2 {
+		return nil, nil, 0, 0, "ERR min or max not valid string range item"
+	}
+	if lk != nil {
+		lo = &btree.Entry{Key: lk}
+	}
+	if hk != nil {
+		hi = &btree.Entry{Key: hk}
+	}
+
+	switch {
+	case len(args) == 2:
+	case len(args) == 5 && strings.EqualFold(string(args[2]), "LIMIT"):
+		o, err1 := strconv.Atoi(string(args[3]))
+		c, err2 := strconv.Atoi(string(args[4]))
+		if err1 != nil || err2 != nil {
+			return nil, nil, 0, 0, "ERR value is not an integer or out of range"
+		}
+		if offset = o; count != 0 {
+			count = c
+		}
+	default:
+		return nil, nil, 0, 0, "ERR syntax error"
+	}
+	return lo, hi, offset, count, ""
+}
+
+// scanRange calls fn with the keys from lo to hi in order, until it returns
+// false.
+func (s *Server) scanRange(lo, hi *btree.Entry, fn func(k []byte) bool) {
+	if lo != nil && hi != nil && !lo.Less(hi) {
+		return
+	}
+	var blo, bhi btree.Key
+	if lo != nil {
+		blo = lo
+	}
+	if hi != nil {
+		bhi = hi
+	}
+	for it := s.tree.Scan(blo, bhi); it.Next() && fn(it.Key().(*btree.Entry).Key); {
+	}
+}
+
+// rangeKeys returns the keys from lo to hi, skipping offset of them and
+// returning at most count, or all if count is negative.
+func (s *Server) rangeKeys(lo, hi *btree.Entry, offset, count int) [][]byte {
+	var ks [][]byte
+	if offset < 0 || count == 0 {
+		return nil
+	}
+	s.scanRange(lo, hi, func(k []byte) bool {
+		if offset > 0 {
+			offset--
+			return true
+		}
+		ks = append(ks, k)
+		return len(ks) != count
+	})
+	return ks
+}
+
+// zrangebylex runs ZRANGEBYLEX set min max [LIMIT offset count].
+func (s *Server) zrangebylex(w writer, args [][]byte) {
+	lo, hi, offset, count, err := lexRange(args[2:])
+	if err != "" {
+		w.error(err)
+		return
+	}
+	ks := s.rangeKeys(lo, hi, offset, count)
+	w.array(len(ks))
+	for _, k := range ks {
+		w.bulk(k)
+	}
+}
+
+// zrevrangebylex runs ZREVRANGEBYLEX set max min [LIMIT offset count].
+func (s *Server) zrevrangebylex(w writer, args [][]byte) {
+	rev := append([][]byte{args[3], args[2]}, args[4:]...)
+	lo, hi, offset, count, err := lexRange(rev)
+	if err != "" {
+		w.error(err)
+		return
+	}
+	// Scans only go forwards, so keep the last offset+count keys of the
+	// range, or all of them without a count, and skip from the end.
+	var ks [][]byte
+	if offset >= 0 && count != 0 {
+		s.scanRange(lo, hi, func(k []byte) bool {
+			if count > 0 && len(ks) == offset+count {
+				ks = ks[1:]
+			}
+			ks = append(ks, k)
+			return true
+		})
+		ks = ks[:max(len(ks)-offset, 0)]
+	}
+	if count >= 0 && count < len(ks) {
+		ks = ks[len(ks)-count:]
+	}
+	w.array(len(ks))
+	for i := len(ks) - 1; i >= 0; i-- {
+		w.bulk(ks[i])
+	}
+}
+
+// zlexcount runs ZLEXCOUNT set min max.
+func (s *Server) zlexcount(w writer, args [][]byte) {
+	lo, hi, _, _, err := lexRange(args[2:])
+	if err != "" {
+		w.error(err)
+		return
+	}
+	n := 0
+	s.scanRange(lo, hi, func([]byte) bool {
+		n++
+		return true
+	})
+	w.int(n)
+}
@@ -0,0 +1,270 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/highlyunavailable/btree"
+)
+
+// testClock is a btree.Clock that only moves when told to.
+type testClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []testTimer
+}
+
+type testTimer struct {
+	at time.Time
+	c  chan time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := testTimer{at: c.now.Add(d), c: make(chan time.Time, 1)}
+	c.timers = append(c.timers, t)
+	return t.c
+}
+
+// Advance moves the clock on by d and fires the timers it passes.
+func (c *testClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	timers := c.timers[:0]
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			timers = append(timers, t)
+		} else {
+			t.c <- c.now
+		}
+	}
+	c.timers = timers
+}
+
+// client sends commands to a server and reads its replies.
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// startServer serves a new tree on a local listener and connects to it.
+func startServer(t *testing.T, opts ...btree.Option) *client {
+	tree, err := btree.New(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(tree)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(l) }()
+	t.Cleanup(func() {
+		s.Close()
+		if err := <-done; err != ErrServerClosed {
+			t.Errorf("Serve returned %v", err)
+		}
+	})
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &client{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+func (c *client) send(args ...string) {
+	c.t.Helper()
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := c.conn.Write([]byte(b.String())); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// reply reads a reply, returning simple strings and errors with their
+// prefix, integers as ints, null bulk strings as nil, bulk strings as
+// strings and arrays as []any.
+func (c *client) reply() any {
+	c.t.Helper()
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	switch line[0] {
+	case '+', '-':
+		return line
+	case ':':
+		n, _ := strconv.Atoi(line[1:])
+		return n
+	case '$':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return nil
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, b); err != nil {
+			c.t.Fatal(err)
+		}
+		return string(b[:n])
+	case '*':
+		n, _ := strconv.Atoi(line[1:])
+		a := []any{}
+		for i := 0; i < n; i++ {
+			a = append(a, c.reply())
+		}
+		return a
+	}
+	c.t.Fatalf("Unexpected reply %q", line)
+	return nil
+}
+
+// do sends a command and checks its reply.
+func (c *client) do(want any, args ...string) {
+	c.t.Helper()
+	c.send(args...)
+	if got := c.reply(); !reflect.DeepEqual(got, want) {
+		c.t.Fatalf("%v replied %#v, want %#v", args, got, want)
+	}
+}
+
+func TestCommands(t *testing.T) {
+	c := startServer(t)
+	c.do("+PONG", "PING")
+	c.do(nil, "GET", "a")
+	c.do("+OK", "SET", "a", "1")
+	c.do("1", "GET", "a")
+	c.do("+OK", "set", "a", "2")
+	c.do("2", "GET", "a")
+	c.do(nil, "SET", "a", "3", "NX")
+	c.do("+OK", "SET", "b", "3", "NX")
+	c.do(nil, "SET", "c", "3", "XX")
+	c.do(2, "EXISTS", "a", "b", "c")
+	c.do(2, "DBSIZE")
+	c.do(1, "DEL", "a", "c")
+	c.do(0, "EXISTS", "a")
+	c.do("+OK", "FLUSHDB")
+	c.do(0, "DBSIZE")
+
+	c.do("-ERR unknown command 'NOPE'", "NOPE")
+	c.do("-ERR wrong number of arguments for 'get' command", "GET")
+	c.do("-ERR syntax error", "SET", "a", "1", "NX", "XX")
+	c.do("-ERR invalid expire time in 'set' command", "SET", "a", "1", "EX", "0")
+
+	// Inline commands and pipelines work too.
+	if _, err := c.conn.Write([]byte("SET k v\r\nGET k\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if a, b := c.reply(), c.reply(); a != "+OK" || b != "v" {
+		t.Fatalf("Pipelined inline commands replied %v, %v", a, b)
+	}
+	c.do("+OK", "QUIT")
+}
+
+func TestLexRanges(t *testing.T) {
+	c := startServer(t)
+	for _, k := range []string{"a", "b", "ba", "c", "d"} {
+		c.do("+OK", "SET", k, "v")
+	}
+	for _, tc := range []struct {
+		args []string
+		want []any
+	}{
+		{[]string{"ZRANGEBYLEX", "z", "-", "+"}, []any{"a", "b", "ba", "c", "d"}},
+		{[]string{"ZRANGEBYLEX", "z", "[b", "(c"}, []any{"b", "ba"}},
+		{[]string{"ZRANGEBYLEX", "z", "(b", "[c"}, []any{"ba", "c"}},
+		{[]string{"ZRANGEBYLEX", "z", "-", "+", "LIMIT", "1", "2"}, []any{"b", "ba"}},
+		{[]string{"ZRANGEBYLEX", "z", "+", "-"}, []any{}},
+		{[]string{"ZRANGEBYLEX", "z", "[c", "[b"}, []any{}},
+		{[]string{"ZREVRANGEBYLEX", "z", "+", "-"}, []any{"d", "c", "ba", "b", "a"}},
+		{[]string{"ZREVRANGEBYLEX", "z", "[c", "[b", "LIMIT", "1", "5"}, []any{"ba", "b"}},
+		{[]string{"ZREVRANGEBYLEX", "z", "+", "-", "LIMIT", "1", "2"}, []any{"c", "ba"}},
+		{[]string{"ZREVRANGEBYLEX", "z", "+", "-", "LIMIT", "4", "2"}, []any{"a"}},
+		{[]string{"ZREVRANGEBYLEX", "z", "+", "-", "LIMIT", "2", "-1"}, []any{"ba", "b", "a"}},
+		{[]string{"ZREVRANGEBYLEX", "z", "+", "-", "LIMIT", "5", "2"}, []any{}},
+		{[]string{"ZREVRANGEBYLEX", "z", "+", "-", "LIMIT", "-1", "2"}, []any{}},
+	} {
+		c.do(tc.want, tc.args...)
+	}
+	c.do(3, "ZLEXCOUNT", "z", "[b", "[c")
+	c.do("-ERR min or max not valid string range item", "ZRANGEBYLEX", "z", "b", "+")
+}
+
+func TestExpiry(t *testing.T) {
+	clock := &testClock{now: time.Unix(1000, 0)}
+	c := startServer(t, btree.WithClock(clock))
+	c.do("+OK", "SET", "a", "1", "EX", "10")
+	c.do("+OK", "SET", "b", "2", "PX", "500")
+	c.do("+OK", "SET", "c", "3", "EX", "1")
+	// Setting c again without an expiry keeps it.
+	c.do("+OK", "SET", "c", "4")
+	// Expired entries count until the janitor removes them.
+	clock.Advance(600 * time.Millisecond)
+	c.do(nil, "GET", "b")
+	c.do(3, "DBSIZE")
+	clock.Advance(400 * time.Millisecond)
+	c.do(nil, "GET", "b")
+	c.do("1", "GET", "a")
+	c.do("4", "GET", "c")
+	c.do([]any{"a", "c"}, "ZRANGEBYLEX", "z", "-", "+")
+	clock.Advance(10 * time.Second)
+	c.do(0, "EXISTS", "a")
+	for start := time.Now(); ; clock.Advance(janitorInterval) {
+		c.send("DBSIZE")
+		if n := c.reply(); n == 1 {
+			break
+		} else if time.Since(start) > 5*time.Second {
+			t.Fatalf("DBSIZE is %v after the janitor ran, want 1", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestReaderClaims(t *testing.T) {
+	// Lengths claimed in headers allocate little until their data arrives.
+	for _, in := range []string{
+		"*1048576\r\n$3\r\nGET\r\n",
+		"*1\r\n$67108864\r\nGET",
+	} {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		r := reader{bufio.NewReader(strings.NewReader(in))}
+		if _, err := r.command(); err == nil {
+			t.Fatalf("Reading %q returned %v", in, err)
+		}
+		runtime.ReadMemStats(&after)
+		if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+			t.Fatalf("Reading %q allocated %d bytes", in, n)
+		}
+	}
+
+	// A full command still reads back as sent.
+	r := reader{bufio.NewReader(strings.NewReader("*2\r\n$3\r\nGET\r\n$1\r\na\r\n"))}
+	if args, err := r.command(); err != nil || len(args) != 2 || string(args[0]) != "GET" || string(args[1]) != "a" {
+		t.Fatalf("Read %q, %v", args, err)
+	}
+}
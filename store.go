@@ -0,0 +1,658 @@
+package btree
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"os"
+	"slices"
+	"sync"
+)
+
+// maxGrowth caps how far a Store grows its file past the pages it needs at
+// once; below it, the file doubles.
+const maxGrowth = 64 << 20
+
+// A Store is a tree file open for changes. Put and Delete write the leaf they
+// change and the nodes above it to free pages, splitting those that outgrow a
+// page, and then commit the new root to one of the two meta records in the
+// first page, so that a crash leaves either the old tree or the new one. The
+// pages a commit replaces go on a persistent free list and are reused by the
+// commits after it, once no View still reads them. Compact packs the tree
+// into the front of the file and truncates the rest.
+//
+// A Store is safe for concurrent use, and its Views may be read while it
+// changes. Files written by Write can be opened as a Store, and Open reads the
+// files of a Store.
+type Store struct {
+	mu    sync.Mutex
+	name  string
+	f     *os.File
+	size  int64    // length of the file, which may run past meta.end
+	m     *mapping // mapping of the file that cur reads
+	cur   *File    // tree of the last commit
+	meta  meta     // last commit
+	err   error    // set once the Store can no longer read its own commits
+	views map[*File]uint64
+
+	free    []pageRun // free pages that no View reads, by offset
+	pending []pageRun // pages freed by commits that open Views may still read
+	freed   []pageRun // pages freed by the change in progress
+
+	// appending makes alloc take pages from the end of the file only.
+	appending bool
+}
+
+// pageRun is a run of n consecutive free pages, freed by commit txn.
+type pageRun struct {
+	off, n uint64
+	txn    uint64
+}
+
+// mapping is a mapping of a Store's file, kept until neither the Store nor
+// any View of it reads from it.
+type mapping struct {
+	data  []byte
+	unmap func() error
+	refs  int
+}
+
+// storeStep is a node on the path from the root to a leaf being changed, and
+// the index of the child taken below it.
+type storeStep struct {
+	off, n uint64
+	rec    *pageRecord
+	i      int
+}
+
+// OpenStore opens the named tree file for changes, creating an empty tree if
+// the file does not exist.
+func OpenStore(name string) (*Store, error) {
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{name: name, f: f, views: make(map[*File]uint64)}
+	if err := s.open(); err != nil {
+		if s.m != nil {
+			s.m.unmap()
+		}
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Store) open() error {
+	fi, err := s.f.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Size() == 0 {
+		if err := s.create(); err != nil {
+			return err
+		}
+	} else {
+		s.size = fi.Size()
+	}
+	if err := s.remap(); err != nil {
+		return err
+	}
+	f, err := newFile(s.m.data)
+	if err != nil {
+		return err
+	}
+	s.cur = f
+	if s.meta, _ = latestMeta(s.m.data); s.meta.txn == 0 {
+		// A file written by Write becomes the first commit, and its footer
+		// the start of the free space.
+		s.meta = meta{txn: 1, root: f.root, count: f.count, end: uint64(len(f.data))}
+		if err := s.writeMeta(s.meta); err != nil {
+			return err
+		}
+		return s.refresh()
+	}
+	runs, err := f.freeRuns()
+	if err != nil {
+		return err
+	}
+	s.free = coalesce(runs)
+	return nil
+}
+
+// create writes an empty tree to the new file.
+func (s *Store) create() error {
+	leaf, err := (&pageRecord{kind: leafPage}).encode()
+	if err != nil {
+		return err
+	}
+	b := make([]byte, 2*pageSize)
+	binary.LittleEndian.PutUint32(b[0:], fileMagic)
+	binary.LittleEndian.PutUint32(b[4:], fileVersion)
+	copy(b[pageSize:], leaf)
+	s.meta = meta{txn: 1, root: pageSize, end: 2 * pageSize}
+	copy(b[metaOffset+metaSize:], s.meta.encode())
+	if _, err := s.f.WriteAt(b, 0); err != nil {
+		return err
+	}
+	s.size = int64(len(b))
+	return s.f.Sync()
+}
+
+// Len returns the number of entries in the store.
+func (s *Store) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return int(s.meta.count)
+}
+
+// Get returns a copy of the value stored for k.
+func (s *Store) Get(k []byte) (v []byte, ok bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.check(); err != nil {
+		return nil, false, err
+	}
+	v, ok, err = s.cur.Get(k)
+	return bytes.Clone(v), ok, err
+}
+
+// Stats reports the page usage of the last commit.
+func (s *Store) Stats() (FileStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.check(); err != nil {
+		return FileStats{}, err
+	}
+	return s.cur.Stats()
+}
+
+// View returns the tree of the last commit as a read-only File, which keeps
+// reading the same entries however the Store changes. Its pages are not
+// reused until it is closed, so it should not be kept open for long.
+func (s *Store) View() (*File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.check(); err != nil {
+		return nil, err
+	}
+	m := s.m
+	m.refs++
+	v := metaFile(m.data, s.meta)
+	s.views[v] = s.meta.txn
+	v.unmap = func() error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.views, v)
+		return s.unref(m)
+	}
+	return v, nil
+}
+
+// Close truncates the file past the last page in use and closes it. Views
+// stay readable until they are closed themselves.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return ErrClosed
+	}
+	err := s.f.Truncate(int64(s.meta.end))
+	if e := s.f.Close(); err == nil {
+		err = e
+	}
+	if e := s.unref(s.m); err == nil {
+		err = e
+	}
+	s.f, s.m, s.cur = nil, nil, nil
+	return err
+}
+
+// Put stores v as the value of k, replacing the value it had, and commits.
+func (s *Store) Put(k, v []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.change(func(m *meta) (bool, error) {
+		path, err := s.descend(k)
+		if err != nil {
+			return false, err
+		}
+		leaf := path[len(path)-1].rec
+		i := leaf.search(k)
+		if i < leaf.count() && bytes.Equal(leaf.items[2*i], k) {
+			leaf.items[2*i+1] = v
+		} else {
+			leaf.items = slices.Insert(leaf.items, 2*i, k, v)
+			m.count++
+		}
+		return true, s.rewrite(m, path)
+	})
+}
+
+// Delete removes k and commits, and reports whether k was stored.
+func (s *Store) Delete(k []byte) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	found := false
+	err := s.change(func(m *meta) (bool, error) {
+		path, err := s.descend(k)
+		if err != nil {
+			return false, err
+		}
+		leaf := path[len(path)-1].rec
+		i := leaf.search(k)
+		if found = i < leaf.count() && bytes.Equal(leaf.items[2*i], k); !found {
+			return false, nil
+		}
+		leaf.items = slices.Delete(leaf.items, 2*i, 2*i+2)
+		m.count--
+
+		// Nodes left empty are dropped from their parents, and the root
+		// gives way to its only child.
+		for len(path) > 1 && path[len(path)-1].rec.count() == 0 && len(path[len(path)-1].rec.children) == 0 {
+			s.freeNode(path[len(path)-1])
+			path = path[:len(path)-1]
+			up := path[len(path)-1].rec
+			i := path[len(path)-1].i
+			up.children = slices.Delete(up.children, i, i+1)
+			if len(up.items) > 0 {
+				up.items = slices.Delete(up.items, max(i-1, 0), max(i, 1))
+			}
+		}
+		if root := path[0].rec; root.kind == internalPage && len(path) == 1 {
+			s.freeNode(path[0])
+			switch len(root.children) {
+			case 0:
+				off, err := s.put(m, &pageRecord{kind: leafPage})
+				m.root = off
+				return true, err
+			case 1:
+				m.root = root.children[0]
+				return true, nil
+			}
+			path[0].off, path[0].n = 0, 0
+		}
+		return true, s.rewrite(m, path)
+	})
+	return found, err
+}
+
+// Compact rewrites the tree with its nodes filled as far as a page allows,
+// first past the end of the file and then, unless a View still reads the old
+// pages, over them at its start, and truncates the file past the last page
+// in use. The pages of open Views are left where they are.
+func (s *Store) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, appending := range []bool{true, false} {
+		s.appending = appending
+		err := s.change(func(m *meta) (bool, error) {
+			err := s.cur.nodes(func(off uint64, p filePage) error {
+				s.freed = append(s.freed, pageRun{off: off, n: pages(len(p))})
+				return nil
+			})
+			if err != nil {
+				return false, err
+			}
+			recs, firsts, err := s.cur.denseLeaves()
+			if err != nil {
+				return false, err
+			}
+			offsets := make([]uint64, len(recs))
+			for i, rec := range recs {
+				if offsets[i], err = s.put(m, rec); err != nil {
+					return false, err
+				}
+			}
+			m.root, err = denseLevels(offsets, firsts, func(rec *pageRecord) (uint64, error) {
+				return s.put(m, rec)
+			})
+			return true, err
+		})
+		s.appending = false
+		if err != nil {
+			return err
+		}
+	}
+	// A last commit gives the pages of the copy past the end back, if no
+	// View still reads them, and records where the file now ends.
+	if err := s.change(func(*meta) (bool, error) { return true, nil }); err != nil {
+		return err
+	}
+	if s.size > int64(s.meta.end) {
+		if err := s.f.Truncate(int64(s.meta.end)); err != nil {
+			return err
+		}
+		s.size = int64(s.meta.end)
+		if err := s.remap(); err != nil {
+			s.err = err
+			return err
+		}
+		s.cur = metaFile(s.m.data, s.meta)
+	}
+	return nil
+}
+
+func (s *Store) check() error {
+	if s.f == nil {
+		return ErrClosed
+	}
+	return s.err
+}
+
+// change runs fn as one commit. fn changes a copy of the last commit's meta
+// and reports whether it changed anything; if it fails, the pages it wrote
+// are left free and the Store goes on from the last commit.
+func (s *Store) change(fn func(m *meta) (bool, error)) error {
+	if err := s.check(); err != nil {
+		return err
+	}
+	s.reclaim()
+	free := slices.Clone(s.free)
+	m := s.meta
+	m.txn++
+	// Free pages at the end of the file are dropped from it.
+	for len(s.free) > 0 && s.free[len(s.free)-1].off+s.free[len(s.free)-1].n*pageSize == m.end {
+		m.end = s.free[len(s.free)-1].off
+		s.free = s.free[:len(s.free)-1]
+	}
+	changed, err := fn(&m)
+	if err == nil && changed {
+		err = s.commit(&m)
+	}
+	if err != nil || !changed {
+		s.free, s.freed = free, nil
+	}
+	return err
+}
+
+// reclaim moves the pages freed by commits that every open View follows to
+// the free list.
+func (s *Store) reclaim() {
+	oldest := s.meta.txn + 1
+	for _, txn := range s.views {
+		oldest = min(oldest, txn)
+	}
+	// The pages freed by commit txn belong to the trees before it.
+	keep := s.pending[:0]
+	for _, r := range s.pending {
+		if r.txn <= oldest {
+			s.free = append(s.free, r)
+		} else {
+			keep = append(keep, r)
+		}
+	}
+	s.pending = keep
+	s.free = coalesce(s.free)
+}
+
+// commit writes the free list and then m, syncing the file after each.
+func (s *Store) commit(m *meta) error {
+	if m.free != 0 {
+		p, err := s.cur.page(m.free)
+		if err != nil {
+			return err
+		}
+		s.freed = append(s.freed, pageRun{off: m.free, n: pages(len(p))})
+		m.free = 0
+	}
+	if n := len(s.free) + len(s.pending) + len(s.freed); n > 0 {
+		// The free list takes its pages before it is encoded, and so lists
+		// at most n runs.
+		off, err := s.alloc(m, pages(pageHeaderSize+20*n))
+		if err != nil {
+			return err
+		}
+		runs := coalesce(slices.Concat(s.free, s.pending, s.freed))
+		rec := &pageRecord{kind: freePage, items: make([][]byte, len(runs))}
+		for i, r := range runs {
+			b := make([]byte, 16)
+			binary.LittleEndian.PutUint64(b, r.off)
+			binary.LittleEndian.PutUint64(b[8:], r.n)
+			rec.items[i] = b
+		}
+		if err := s.write(off, rec); err != nil {
+			return err
+		}
+		m.free = off
+	}
+	if err := s.f.Sync(); err != nil {
+		return err
+	}
+	if err := s.writeMeta(*m); err != nil {
+		return err
+	}
+
+	s.meta = *m
+	for _, r := range s.freed {
+		r.txn = m.txn
+		s.pending = append(s.pending, r)
+	}
+	s.freed = nil
+	return s.refresh()
+}
+
+func (s *Store) writeMeta(m meta) error {
+	if _, err := s.f.WriteAt(m.encode(), int64(metaOffset+m.txn%2*metaSize)); err != nil {
+		return err
+	}
+	return s.f.Sync()
+}
+
+// refresh points the Store at its last commit, mapping the file again if the
+// commit runs past the mapping or the mapping does not see writes. Without a
+// mapping of its own commits the Store is of no further use.
+func (s *Store) refresh() error {
+	if !mmapShared || s.meta.end > uint64(len(s.m.data)) {
+		if err := s.remap(); err != nil {
+			s.err = err
+			return err
+		}
+	}
+	s.cur = metaFile(s.m.data, s.meta)
+	return nil
+}
+
+func (s *Store) remap() error {
+	data, unmap, err := mmapFile(s.name)
+	if err != nil {
+		return err
+	}
+	if s.m != nil {
+		s.unref(s.m)
+	}
+	s.m = &mapping{data: data, unmap: unmap, refs: 1}
+	return nil
+}
+
+func (s *Store) unref(m *mapping) error {
+	if m.refs--; m.refs == 0 {
+		return m.unmap()
+	}
+	return nil
+}
+
+// descend returns the path from the root to the leaf where k belongs, with
+// every node decoded for changing.
+func (s *Store) descend(k []byte) ([]storeStep, error) {
+	var path []storeStep
+	off := s.meta.root
+	for {
+		p, err := s.cur.page(off)
+		if err != nil {
+			return nil, err
+		}
+		st := storeStep{off: off, n: pages(len(p)), rec: decodePage(p)}
+		switch p.kind() {
+		case leafPage:
+			return append(path, st), nil
+		case internalPage:
+			st.i = p.route(k)
+			path = append(path, st)
+			off = p.child(st.i)
+		default:
+			return nil, ErrCorrupt
+		}
+	}
+}
+
+// rewrite writes the changed last node of path and the nodes above it to new
+// pages, splitting those that outgrow a page, and frees the pages they were
+// on.
+func (s *Store) rewrite(m *meta, path []storeStep) error {
+	for _, st := range path {
+		s.freeNode(st)
+	}
+	recs, seps := splitRecord(path[len(path)-1].rec)
+	for l := len(path) - 2; ; l-- {
+		offs := make([]uint64, len(recs))
+		for i, rec := range recs {
+			var err error
+			if offs[i], err = s.put(m, rec); err != nil {
+				return err
+			}
+		}
+		if l < 0 && len(offs) == 1 {
+			m.root = offs[0]
+			return nil
+		}
+		up := &pageRecord{kind: internalPage, items: seps, children: offs}
+		if l >= 0 {
+			st := path[l]
+			up = st.rec
+			up.children = slices.Replace(up.children, st.i, st.i+1, offs...)
+			up.items = slices.Insert(up.items, st.i, seps...)
+		}
+		recs, seps = splitRecord(up)
+	}
+}
+
+func (s *Store) freeNode(st storeStep) {
+	if st.n > 0 {
+		s.freed = append(s.freed, pageRun{off: st.off, n: st.n})
+	}
+}
+
+// put writes rec to free pages and returns their offset.
+func (s *Store) put(m *meta, rec *pageRecord) (uint64, error) {
+	b, err := rec.encode()
+	if err != nil {
+		return 0, err
+	}
+	off, err := s.alloc(m, pages(len(b)))
+	if err != nil {
+		return 0, err
+	}
+	_, err = s.f.WriteAt(b, int64(off))
+	return off, err
+}
+
+func (s *Store) write(off uint64, rec *pageRecord) error {
+	b, err := rec.encode()
+	if err != nil {
+		return err
+	}
+	_, err = s.f.WriteAt(b, int64(off))
+	return err
+}
+
+// alloc returns the offset of n free pages: the start of the first free run
+// long enough, or else the end of the file, which it grows as needed.
+func (s *Store) alloc(m *meta, n uint64) (uint64, error) {
+	if !s.appending {
+		for i, r := range s.free {
+			if r.n < n {
+				continue
+			}
+			if r.n == n {
+				s.free = slices.Delete(s.free, i, i+1)
+			} else {
+				s.free[i].off += n * pageSize
+				s.free[i].n -= n
+			}
+			return r.off, nil
+		}
+	}
+	off := m.end
+	m.end += n * pageSize
+	if int64(m.end) > s.size {
+		size := max(int64(m.end), s.size+min(s.size, maxGrowth))
+		if err := s.f.Truncate(size); err != nil {
+			return 0, err
+		}
+		s.size = size
+	}
+	return off, nil
+}
+
+// decodePage returns the record of p, sharing its keys and values.
+func decodePage(p filePage) *pageRecord {
+	rec := &pageRecord{kind: p.kind(), items: make([][]byte, p.items())}
+	for i := range rec.items {
+		rec.items[i] = p.item(i)
+	}
+	if rec.kind == internalPage {
+		rec.children = make([]uint64, p.count()+1)
+		for i := range rec.children {
+			rec.children[i] = p.child(i)
+		}
+	}
+	return rec
+}
+
+// search returns the index of the first entry of a leaf record whose key is
+// not less than k.
+func (rec *pageRecord) search(k []byte) int {
+	lo, hi := 0, rec.count()
+	for lo < hi {
+		mid := int(uint(lo+hi) >> 1)
+		if bytes.Compare(rec.items[2*mid], k) < 0 {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo
+}
+
+// splitRecord splits a record that outgrows a page into halves until each
+// fits, and returns them with the separators between them. A leaf's right
+// half is separated by its first key; an internal node's middle separator
+// moves up.
+func splitRecord(rec *pageRecord) ([]*pageRecord, [][]byte) {
+	n := rec.count()
+	if rec.size() <= pageSize && n <= 0xffff || n < 2 {
+		return []*pageRecord{rec}, nil
+	}
+	mid := n / 2
+	var left, right *pageRecord
+	var sep []byte
+	if rec.kind == leafPage {
+		left = &pageRecord{kind: leafPage, items: rec.items[: 2*mid : 2*mid]}
+		right = &pageRecord{kind: leafPage, items: rec.items[2*mid:]}
+		sep = right.items[0]
+	} else {
+		left = &pageRecord{kind: internalPage, items: rec.items[:mid:mid], children: rec.children[: mid+1 : mid+1]}
+		right = &pageRecord{kind: internalPage, items: rec.items[mid+1:], children: rec.children[mid+1:]}
+		sep = rec.items[mid]
+	}
+	lrecs, lseps := splitRecord(left)
+	rrecs, rseps := splitRecord(right)
+	return append(lrecs, rrecs...), slices.Concat(lseps, [][]byte{sep}, rseps)
+}
+
+// coalesce sorts runs by offset and joins those that touch.
+func coalesce(runs []pageRun) []pageRun {
+	slices.SortFunc(runs, func(a, b pageRun) int {
+		return cmp.Compare(a.off, b.off)
+	})
+	out := runs[:0]
+	for _, r := range runs {
+		if l := len(out) - 1; l >= 0 && out[l].off+out[l].n*pageSize == r.off {
+			out[l].n += r.n
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
@@ -0,0 +1,246 @@
+package btree
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// checkStore compares every entry of a View of s with want, and verifies it.
+func checkStore(t *testing.T, s *Store, want map[int][]byte) {
+	t.Helper()
+	v, err := s.View()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+	if err := v.Verify(); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	n, prev := 0, []byte(nil)
+	err = v.Range(nil, nil, func(k, val []byte) bool {
+		i, _ := strconv.Atoi(string(k[1:]))
+		if prev != nil && bytes.Compare(prev, k) >= 0 || !bytes.Equal(val, want[i]) {
+			t.Fatalf("Range got %q out of order or with the wrong value", k)
+		}
+		prev = bytes.Clone(k)
+		n++
+		return true
+	})
+	if err != nil || n != len(want) || s.Len() != len(want) {
+		t.Fatalf("Range got %d entries, %v; Len %d; want %d", n, err, s.Len(), len(want))
+	}
+}
+
+func storeKey(i int) []byte {
+	return []byte("k" + strconv.Itoa(i))
+}
+
+func TestStore(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "store")
+	s, err := OpenStore(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := rand.New(rand.NewSource(1))
+	want := make(map[int][]byte)
+	for i := 0; i < 5000; i++ {
+		k := r.Intn(1000)
+		if r.Intn(3) == 0 {
+			found, err := s.Delete(storeKey(k))
+			if err != nil || found != (want[k] != nil) {
+				t.Fatalf("Delete(%d) = %v, %v", k, found, err)
+			}
+			delete(want, k)
+		} else {
+			// Values up to a page long make leaves split unevenly.
+			v := bytes.Repeat([]byte{byte(i)}, r.Intn(pageSize))
+			if err := s.Put(storeKey(k), v); err != nil {
+				t.Fatalf("Put(%d) failed: %v", k, err)
+			}
+			want[k] = v
+		}
+		if v, ok, err := s.Get(storeKey(k)); err != nil || ok != (want[k] != nil) || !bytes.Equal(v, want[k]) {
+			t.Fatalf("Get(%d) after op %d = %d bytes, %v, %v", k, i, len(v), ok, err)
+		}
+		if i%1000 == 0 {
+			checkStore(t, s, want)
+		}
+	}
+	checkStore(t, s, want)
+
+	// The file grows with the tree, not with the number of commits.
+	st, err := s.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi, _ := os.Stat(name); fi.Size() > int64(4*(st.Pages+st.FreePages+2)*pageSize) {
+		t.Fatalf("File of %d bytes holds %+v", fi.Size(), st)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Get(storeKey(1)); err != ErrClosed {
+		t.Fatalf("Get on a closed store returned %v", err)
+	}
+
+	// The free list is kept across opens, and Open reads the same tree.
+	s, err = OpenStore(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	checkStore(t, s, want)
+	if st2, err := s.Stats(); err != nil || st2.FreePages != st.FreePages {
+		t.Fatalf("Reopened store has %+v, %v; want %+v", st2, err, st)
+	}
+	f, err := Open(name)
+	if err != nil {
+		t.Fatalf("Failed to open store file: %v", err)
+	}
+	defer f.Close()
+	if f.Len() != len(want) {
+		t.Fatalf("Open read %d entries, want %d", f.Len(), len(want))
+	}
+}
+
+func TestStoreView(t *testing.T) {
+	s, err := OpenStore(filepath.Join(t.TempDir(), "store"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for i := 0; i < 2000; i++ {
+		s.Put(storeKey(i), []byte("old"))
+	}
+	v, err := s.View()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Changes after the View leave its pages alone.
+	for i := 0; i < 2000; i++ {
+		if i%2 == 0 {
+			s.Delete(storeKey(i))
+		} else {
+			s.Put(storeKey(i), []byte("new"))
+		}
+	}
+	if err := v.Verify(); err != nil {
+		t.Fatalf("View no longer verifies: %v", err)
+	}
+	n := 0
+	v.Range(nil, nil, func(k, val []byte) bool {
+		if string(val) != "old" {
+			t.Fatalf("View read %q = %q", k, val)
+		}
+		n++
+		return true
+	})
+	if n != 2000 || v.Len() != 2000 {
+		t.Fatalf("View read %d entries, want 2000", n)
+	}
+
+	// Once it is closed, its pages are reused and the file stops growing.
+	if err := v.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s.Put(storeKey(1), []byte("newer"))
+	before, _ := s.Stats()
+	for i := 1; i < 2000; i += 2 {
+		s.Put(storeKey(i), []byte("newest"))
+	}
+	after, _ := s.Stats()
+	if after.Pages+after.FreePages > before.Pages+before.FreePages {
+		t.Fatalf("File grew from %+v to %+v", before, after)
+	}
+}
+
+func TestStoreCompact(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "store")
+	s, err := OpenStore(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	want := make(map[int][]byte)
+	for _, i := range rand.Perm(4000) {
+		s.Put(storeKey(i), []byte("value"))
+		want[i] = []byte("value")
+	}
+	for i := 0; i < 4000; i++ {
+		if i%10 != 0 {
+			s.Delete(storeKey(i))
+			delete(want, i)
+		}
+	}
+	before, _ := os.Stat(name)
+	v, _ := s.View()
+
+	// With a View open, the copy stays past the end of the file.
+	if err := s.Compact(); err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	checkStore(t, s, want)
+	if err := v.Verify(); err != nil || v.Len() != len(want) {
+		t.Fatalf("View of %d entries no longer verifies: %v", v.Len(), err)
+	}
+	v.Close()
+
+	if err := s.Compact(); err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	checkStore(t, s, want)
+	after, _ := os.Stat(name)
+	st, err := s.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Size() >= before.Size()/4 || st.LeafNodes > 2 {
+		t.Fatalf("Compacted file is %d bytes, down from %d, with %+v", after.Size(), before.Size(), st)
+	}
+	if after.Size() > int64((st.Pages+st.FreePages+2)*pageSize) {
+		t.Fatalf("Compacted file of %d bytes holds %+v", after.Size(), st)
+	}
+}
+
+func TestStoreWrittenFile(t *testing.T) {
+	tree := NewBTree(4)
+	for i := 0; i < 300; i++ {
+		tree.Insert(&testKey{value: i})
+	}
+	name := writeTestFile(t, tree)
+	s, err := OpenStore(name)
+	if err != nil {
+		t.Fatalf("Failed to open tree file as a store: %v", err)
+	}
+	defer s.Close()
+	if err := s.Put(testFileKey(1000), []byte("1000")); err != nil {
+		t.Fatal(err)
+	}
+	if found, err := s.Delete(testFileKey(0)); !found || err != nil {
+		t.Fatalf("Delete = %v, %v", found, err)
+	}
+	v, err := s.View()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+	if err := v.Verify(); err != nil || v.Len() != 300 {
+		t.Fatalf("Store of %d entries fails to verify: %v", v.Len(), err)
+	}
+	if val, ok, _ := v.Get(testFileKey(1000)); !ok || string(val) != "1000" {
+		t.Fatalf("Get(1000) = %q, %v", val, ok)
+	}
+	if _, ok, _ := v.Get(testFileKey(0)); ok {
+		t.Fatal("Get(0) found a deleted key")
+	}
+
+	if _, err := OpenStore(filepath.Join(t.TempDir(), "missing", "store")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("OpenStore in a missing directory returned %v", err)
+	}
+}
@@ -0,0 +1,222 @@
+package btree
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sort"
+)
+
+// Diff and ServeDiff find the ranges of keys where two replicas of a tree
+// differ. Diff sends rounds of requests, each a uvarint count of ranges
+// followed by their bounds, and ServeDiff answers each round with the hash
+// and number of the keys of every range. A count of 0 ends the exchange.
+// Bounds are a 0 byte for an open end, or a 1 byte followed by the uvarint
+// length of the key bytes and the bytes themselves.
+const (
+	maxDiffRanges = 1 << 16
+	maxDiffKey    = 1 << 20
+)
+
+var errDiffProtocol = errors.New("btree: malformed diff message")
+
+// A Range holds the keys k with Lo <= k < Hi. A nil bound leaves that end
+// open.
+type Range struct {
+	Lo, Hi Key
+}
+
+// A Decoder returns the key encoded as kb by an Encoder.
+type Decoder func(kb []byte) (Key, error)
+
+// Diff compares the tree with a replica answering ServeDiff on rw, and
+// returns the ranges, in ascending order, that hold keys one of them lacks
+// or keys whose values differ. Both trees must be built WithMerkle with
+// the same encoding. Ranges whose hashes differ are split at the separators
+// of the tree's nodes, so that each round descends a level and the exchange
+// visits O(d log n) ranges for d differences. The ranges returned are as
+// narrow as the leaves of the tree, and may hold keys that do not differ.
+// Neither tree may change during the exchange.
+func (t *BTree) Diff(rw io.ReadWriter) ([]Range, error) {
+	m := t.cfg.merkle
+	if m == nil {
+		return nil, ErrNotHashed
+	}
+	if err := t.tryDrain(); err != nil {
+		return nil, err
+	}
+	r, w := bufio.NewReader(rw), bufio.NewWriter(rw)
+
+	var diffs []Range
+	pending := []Range{{}}
+	for len(pending) > 0 {
+		var next []Range
+		for len(pending) > 0 {
+			round := pending[:min(len(pending), maxDiffRanges)]
+			pending = pending[len(round):]
+			w.Write(binary.AppendUvarint(nil, uint64(len(round))))
+			for _, rg := range round {
+				m.writeBound(w, rg.Lo)
+				m.writeBound(w, rg.Hi)
+			}
+			if err := w.Flush(); err != nil {
+				return nil, err
+			}
+
+			for _, rg := range round {
+				remote, err := readSum(r)
+				if err != nil {
+					return nil, err
+				}
+				local := m.rangeSum(t.root, rg.Lo, rg.Hi, nil, nil)
+				if local == remote {
+					continue
+				}
+				splits := t.splitRange(rg.Lo, rg.Hi)
+				if local.n == 0 || remote.n == 0 || len(splits) == 0 {
+					diffs = append(diffs, rg)
+					continue
+				}
+				lo := rg.Lo
+				for _, s := range splits {
+					next = append(next, Range{lo, s})
+					lo = s
+				}
+				next = append(next, Range{lo, rg.Hi})
+			}
+		}
+		pending = next
+	}
+	w.Write([]byte{0})
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	return mergeRanges(diffs), nil
+}
+
+// ServeDiff answers the requests of a replica calling Diff on rw until it
+// ends the exchange, decoding the bounds of ranges with dec.
+func (t *BTree) ServeDiff(rw io.ReadWriter, dec Decoder) error {
+	if t.cfg.merkle == nil {
+		return ErrNotHashed
+	}
+	r, w := bufio.NewReader(rw), bufio.NewWriter(rw)
+	for {
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+		if n > maxDiffRanges {
+			return errDiffProtocol
+		}
+
+		// The whole round is read before answering, so that neither side
+		// blocks writing while the other does.
+		round := make([]Range, n)
+		for i := range round {
+			if round[i].Lo, err = readBound(r, dec); err != nil {
+				return err
+			}
+			if round[i].Hi, err = readBound(r, dec); err != nil {
+				return err
+			}
+		}
+		for _, rg := range round {
+			h, count, err := t.RangeHash(rg.Lo, rg.Hi)
+			if err != nil {
+				return err
+			}
+			w.Write(h[:])
+			w.Write(binary.AppendUvarint(nil, uint64(count)))
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+}
+
+func (m *merkle) writeBound(w *bufio.Writer, k key) {
+	if k == nil {
+		w.WriteByte(0)
+		return
+	}
+	kb, _ := m.enc(k)
+	w.WriteByte(1)
+	w.Write(binary.AppendUvarint(nil, uint64(len(kb))))
+	w.Write(kb)
+}
+
+func readBound(r *bufio.Reader, dec Decoder) (key, error) {
+	open, err := r.ReadByte()
+	switch {
+	case err != nil:
+		return nil, err
+	case open == 0:
+		return nil, nil
+	case open != 1:
+		return nil, errDiffProtocol
+	}
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > maxDiffKey {
+		return nil, errDiffProtocol
+	}
+	kb := make([]byte, n)
+	if _, err := io.ReadFull(r, kb); err != nil {
+		return nil, err
+	}
+	return dec(kb)
+}
+
+func readSum(r *bufio.Reader) (nodeSum, error) {
+	var s nodeSum
+	if _, err := io.ReadFull(r, s.h[:]); err != nil {
+		return s, err
+	}
+	n, err := binary.ReadUvarint(r)
+	s.n = int(n)
+	return s, err
+}
+
+// splitRange returns the separators strictly between lo and hi of the
+// highest node whose children the range spans, or nil if it lies within a
+// single leaf.
+func (t *BTree) splitRange(lo, hi key) keys {
+	n := t.root
+	for {
+		in, ok := n.(*internalNode)
+		if !ok {
+			return nil
+		}
+		a, b := in.span(lo, hi)
+		if a < b {
+			return in.keys[a:b]
+		}
+		n = in.nodes[a]
+	}
+}
+
+// mergeRanges sorts rs and joins the ranges that touch.
+func mergeRanges(rs []Range) []Range {
+	sort.Slice(rs, func(i, j int) bool {
+		if rs[i].Lo == nil || rs[j].Lo == nil {
+			return rs[i].Lo == nil && rs[j].Lo != nil
+		}
+		return rs[i].Lo.Less(rs[j].Lo)
+	})
+	var out []Range
+	for _, rg := range rs {
+		if l := len(out) - 1; l >= 0 && out[l].Hi != nil && rg.Lo != nil && out[l].Hi.Compare(rg.Lo) == 0 {
+			out[l].Hi = rg.Hi
+			continue
+		}
+		out = append(out, rg)
+	}
+	return out
+}
@@ -0,0 +1,96 @@
+package btree
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+func decodeInt64(kb []byte) (Key, error) {
+	return Int64(binary.BigEndian.Uint64(kb) ^ 1<<63), nil
+}
+
+// diff runs Diff on a against ServeDiff on b over a pipe.
+func diff(t *testing.T, a, b *BTree) []Range {
+	t.Helper()
+	ca, cb := net.Pipe()
+	defer ca.Close()
+	done := make(chan error, 1)
+	go func() {
+		defer cb.Close()
+		done <- b.ServeDiff(cb, decodeInt64)
+	}()
+	rs, err := a.Diff(ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	return rs
+}
+
+func inRange(k Key, r Range) bool {
+	return (r.Lo == nil || !k.Less(r.Lo)) && (r.Hi == nil || k.Less(r.Hi))
+}
+
+func TestDiff(t *testing.T) {
+	a, _ := New(WithOrder(4), WithMerkle(encodeInt64))
+	b, _ := New(WithOrder(8), WithMerkle(encodeInt64))
+	for k := Int64(0); k < 5000; k++ {
+		a.Insert(k)
+		b.Insert(4999 - k)
+	}
+	if rs := diff(t, a, b); len(rs) != 0 {
+		t.Fatalf("Equal trees differ in %v", rs)
+	}
+
+	changed := []Key{Int64(-5), Int64(17), Int64(2500), Int64(2501), Int64(4999), Int64(6000)}
+	a.Insert(Int64(-5))
+	a.Remove(Int64(17))
+	b.Remove(Int64(2500))
+	b.Insert(Int64(2500))
+	b.Remove(Int64(2501))
+	a.Remove(Int64(4999))
+	b.Insert(Int64(6000))
+
+	rs := diff(t, a, b)
+	for _, k := range changed {
+		found := false
+		for _, r := range rs {
+			found = found || inRange(k, r)
+		}
+		if !found {
+			t.Fatalf("Diff ranges %v miss %v", rs, k)
+		}
+	}
+	// The ranges are no wider than a few leaves of a.
+	covered := 0
+	for it := a.Scan(nil, nil); it.Next(); {
+		for _, r := range rs {
+			if inRange(it.Key(), r) {
+				covered++
+			}
+		}
+	}
+	if covered > 4*len(changed) {
+		t.Fatalf("Diff ranges %v cover %d keys", rs, covered)
+	}
+	for i := 1; i < len(rs); i++ {
+		if rs[i-1].Hi == nil || rs[i].Lo == nil || rs[i].Lo.Less(rs[i-1].Hi) {
+			t.Fatalf("Diff ranges %v are not in order", rs)
+		}
+	}
+
+	// Either side may be empty.
+	empty, _ := New(WithMerkle(encodeInt64))
+	if rs := diff(t, empty, a); len(rs) != 1 || rs[0] != (Range{}) {
+		t.Fatalf("Empty tree differs in %v", rs)
+	}
+	if rs := diff(t, a, empty); len(rs) != 1 || rs[0] != (Range{}) {
+		t.Fatalf("Tree differs from an empty one in %v", rs)
+	}
+	if _, err := NewBTree(3).Diff(nil); err != ErrNotHashed {
+		t.Fatalf("Diff without hashing returned %v", err)
+	}
+}
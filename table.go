@@ -0,0 +1,342 @@
+package btree
+
+import (
+	"errors"
+	"reflect"
+)
+
+var (
+	// ErrDuplicate is returned when a record would give a unique index a key
+	// that another record already has.
+	ErrDuplicate = errors.New("btree: duplicate key in unique index")
+
+	// ErrIndexExists is returned by AddIndex for a name already in use.
+	ErrIndexExists = errors.New("btree: index already exists")
+)
+
+// A Table holds records in a primary tree, ordered by their own Compare, and
+// keeps any number of secondary indexes over them up to date as records are
+// put and deleted. A record is typically a struct keyed by its ID, such as
+//
+//	type User struct {
+//		ID      int64
+//		Email   string
+//		Created time.Time
+//	}
+//
+// with indexes extracting Email and Created. Like a BTree, a Table is not
+// safe for concurrent use.
+type Table struct {
+	primary *BTree
+	indexes []*Index
+}
+
+// An Index orders the records of a table by a key extracted from each. Its
+// entries pair the extracted key with the record, so that records with equal
+// extracted keys are ordered by the primary order.
+type Index struct {
+	name    string
+	extract func(Key) Key
+	unique  bool
+	keyType reflect.Type
+	tree    *BTree // of indexKeys
+}
+
+// indexKey is an entry of an index. A nil rec sorts before every record with
+// the same k, so that bounds of scans can leave it out.
+type indexKey struct {
+	k   key
+	rec key
+}
+
+func (x indexKey) Less(other key) bool {
+	return x.Compare(other) < 0
+}
+
+func (x indexKey) Compare(other key) int {
+	o := other.(indexKey)
+	if c := x.k.Compare(o.k); c != 0 {
+		return c
+	}
+	switch {
+	case x.rec == nil && o.rec == nil:
+		return 0
+	case x.rec == nil:
+		return -1
+	case o.rec == nil:
+		return 1
+	}
+	return x.rec.Compare(o.rec)
+}
+
+// NewTable returns an empty table whose primary tree is configured by opts.
+// Size limits are refused with ErrInvalidLimit, since the records they evict
+// would be left in the indexes.
+func NewTable(opts ...Option) (*Table, error) {
+	primary, err := New(opts...)
+	if err != nil {
+		return nil, err
+	}
+	if primary.cfg.bounded() {
+		return nil, ErrInvalidLimit
+	}
+	return &Table{primary: primary}, nil
+}
+
+// AddIndex adds an index named name, ordering records by the key extract
+// returns for them, and fills it with the records already in the table.
+// Records for which extract returns nil are left out of the index. The keys
+// of an index must all be of one type. A unique index refuses records whose
+// key another record already has with ErrDuplicate.
+func (t *Table) AddIndex(name string, extract func(Key) Key, unique bool) (*Index, error) {
+	if t.Index(name) != nil {
+		return nil, ErrIndexExists
+	}
+	tree, err := New()
+	if err != nil {
+		return nil, err
+	}
+	x := &Index{name: name, extract: extract, unique: unique, tree: tree}
+	for it := t.primary.Scan(nil, nil); it.Next(); {
+		rec := it.Key()
+		k, err := x.key(rec)
+		if err == nil && k != nil {
+			err = x.check(k, rec)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if k != nil {
+			if err := x.insert(k, rec); err != nil {
+				return nil, err
+			}
+		}
+	}
+	t.indexes = append(t.indexes, x)
+	return x, nil
+}
+
+// Index returns the index named name, or nil if there is none.
+func (t *Table) Index(name string) *Index {
+	for _, x := range t.indexes {
+		if x.name == name {
+			return x
+		}
+	}
+	return nil
+}
+
+// Get returns the record equal to rec, or nil if there is none.
+func (t *Table) Get(rec Key) Key {
+	return t.primary.Get(rec)
+}
+
+// Scan returns an iterator over the records rec with lo <= rec < hi in the
+// primary order.
+func (t *Table) Scan(lo, hi Key) *Iterator {
+	return t.primary.Scan(lo, hi)
+}
+
+// Put adds rec to the table, replacing the record equal to it if there is
+// one, and returns the record it replaced. The primary tree and every index
+// are checked before any of them changes, so a record refused with
+// ErrDuplicate or ErrKeyTypeMismatch leaves the table as it was.
+func (t *Table) Put(rec Key) (old Key, err error) {
+	if err := t.primary.checkKey(rec); err != nil {
+		return nil, err
+	}
+	old = t.primary.Get(rec)
+	ks := make([]key, len(t.indexes))
+	for i, x := range t.indexes {
+		if ks[i], err = x.key(rec); err != nil {
+			return nil, err
+		}
+		if ks[i] != nil {
+			if err := x.check(ks[i], rec); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if _, err := t.primary.ReplaceOrInsert(rec); err != nil {
+		return nil, err
+	}
+	for i, x := range t.indexes {
+		var oldKey key
+		if old != nil {
+			oldKey = x.extract(old)
+		}
+		switch {
+		case oldKey != nil && ks[i] != nil && oldKey.Compare(ks[i]) == 0:
+			_, err = x.tree.ReplaceOrInsert(indexKey{ks[i], rec})
+		case oldKey != nil:
+			if err = x.tree.Remove(indexKey{oldKey, old}); err == nil && ks[i] != nil {
+				err = x.insert(ks[i], rec)
+			}
+		case ks[i] != nil:
+			err = x.insert(ks[i], rec)
+		}
+		if err != nil {
+			t.undo(rec, old, ks[:i+1])
+			return nil, err
+		}
+	}
+	return old, nil
+}
+
+// undo puts old back in place of rec in the primary tree and in the first
+// len(ks) indexes, in which rec has the keys ks, after Put failed part way.
+// Its own errors are dropped for the one that made Put fail.
+func (t *Table) undo(rec, old key, ks []key) {
+	for i, k := range ks {
+		x := t.indexes[i]
+		if k != nil {
+			x.tree.Remove(indexKey{k, rec})
+		}
+		if old != nil {
+			if k := x.extract(old); k != nil {
+				x.tree.ReplaceOrInsert(indexKey{k, old})
+			}
+		}
+	}
+	if old != nil {
+		t.primary.ReplaceOrInsert(old)
+	} else {
+		t.primary.Remove(rec)
+	}
+}
+
+// Delete removes the record equal to rec from the table and its indexes,
+// and returns it, or nil if there was none. If removing it from an index
+// fails, the record is put back in the table and the indexes before it.
+func (t *Table) Delete(rec Key) (Key, error) {
+	if err := t.primary.checkKey(rec); err != nil {
+		return nil, err
+	}
+	old := t.primary.Get(rec)
+	if old == nil {
+		return nil, nil
+	}
+	if err := t.primary.Remove(old); err != nil {
+		return nil, err
+	}
+	for i, x := range t.indexes {
+		if k := x.extract(old); k != nil {
+			if err := x.tree.Remove(indexKey{k, old}); err != nil {
+				// Put the record back where it was already removed from.
+				for _, x := range t.indexes[:i] {
+					if k := x.extract(old); k != nil {
+						x.tree.ReplaceOrInsert(indexKey{k, old})
+					}
+				}
+				t.primary.ReplaceOrInsert(old)
+				return nil, err
+			}
+		}
+	}
+	return old, nil
+}
+
+// Name returns the name of the index.
+func (x *Index) Name() string {
+	return x.name
+}
+
+// key returns the key of rec in the index, making sure it is of the same
+// type as the keys already in it.
+func (x *Index) key(rec key) (key, error) {
+	k := x.extract(rec)
+	if k != nil && x.keyType != nil && reflect.TypeOf(k) != x.keyType {
+		return nil, ErrKeyTypeMismatch
+	}
+	return k, nil
+}
+
+// insert adds the entry of rec, whose key in the index is k.
+func (x *Index) insert(k, rec key) error {
+	if x.keyType == nil {
+		x.keyType = reflect.TypeOf(k)
+	}
+	return x.tree.Insert(indexKey{k, rec})
+}
+
+// check makes sure a unique index holds no record other than rec with the
+// key k.
+func (x *Index) check(k, rec key) error {
+	if !x.unique {
+		return nil
+	}
+	it := x.tree.Scan(indexKey{k: k}, nil)
+	if it.Next() {
+		e := it.Key().(indexKey)
+		if e.k.Compare(k) == 0 && e.rec.Compare(rec) != 0 {
+			return ErrDuplicate
+		}
+	}
+	return nil
+}
+
+// Get returns the first record, in the primary order, whose key in the
+// index is k, or nil if there is none.
+func (x *Index) Get(k Key) Key {
+	if k == nil || x.keyType == nil || reflect.TypeOf(k) != x.keyType {
+		return nil
+	}
+	it := x.tree.Scan(indexKey{k: k}, nil)
+	if it.Next() {
+		if e := it.Key().(indexKey); e.k.Compare(k) == 0 {
+			return e.rec
+		}
+	}
+	return nil
+}
+
+// Scan returns an iterator over the records whose key k in the index has
+// lo <= k < hi, in the order of the index. A nil lo or hi leaves that end of
+// the range open.
+func (x *Index) Scan(lo, hi Key) *IndexIterator {
+	for _, k := range []key{lo, hi} {
+		if k != nil && x.keyType != nil && reflect.TypeOf(k) != x.keyType {
+			return &IndexIterator{it: &Iterator{err: ErrKeyTypeMismatch}}
+		}
+	}
+	if x.keyType == nil {
+		// The index is empty, and bounds of any type would fail the
+		// tree's key check.
+		lo, hi = nil, nil
+	}
+	var l, h key
+	if lo != nil {
+		l = indexKey{k: lo}
+	}
+	if hi != nil {
+		h = indexKey{k: hi}
+	}
+	return &IndexIterator{it: x.tree.Scan(l, h)}
+}
+
+// IndexIterator walks the records of a range of an index. The table must not
+// be modified while an IndexIterator is in use.
+type IndexIterator struct {
+	it *Iterator
+}
+
+// Next advances the iterator and reports whether there is a record to read.
+func (it *IndexIterator) Next() bool {
+	return it.it.Next()
+}
+
+// Key returns the key in the index of the current record.
+func (it *IndexIterator) Key() Key {
+	return it.it.Key().(indexKey).k
+}
+
+// Record returns the current record.
+func (it *IndexIterator) Record() Key {
+	return it.it.Key().(indexKey).rec
+}
+
+// Err returns the error that stopped the iterator, if any.
+func (it *IndexIterator) Err() error {
+	return it.it.Err()
+}
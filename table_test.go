@@ -0,0 +1,242 @@
+package btree
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// user is a record keyed by id.
+type user struct {
+	id      int64
+	email   string
+	created int64
+}
+
+func (u *user) Less(other Key) bool {
+	return u.id < other.(*user).id
+}
+
+func (u *user) Compare(other Key) int {
+	return Int64(u.id).Compare(Int64(other.(*user).id))
+}
+
+func newUserTable(t *testing.T) (*Table, *Index, *Index) {
+	table, err := NewTable(WithOrder(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	byEmail, err := table.AddIndex("email", func(k Key) Key {
+		if u := k.(*user); u.email != "" {
+			return String(u.email)
+		}
+		return nil
+	}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byCreated, err := table.AddIndex("created", func(k Key) Key {
+		return Int64(k.(*user).created)
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return table, byEmail, byCreated
+}
+
+// scanIDs returns the ids of the records in [lo, hi) of an index.
+func scanIDs(t *testing.T, x *Index, lo, hi Key) []int64 {
+	t.Helper()
+	ids := []int64{}
+	it := x.Scan(lo, hi)
+	for it.Next() {
+		ids = append(ids, it.Record().(*user).id)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+func TestTableIndexes(t *testing.T) {
+	table, byEmail, byCreated := newUserTable(t)
+	for i, email := range []string{"e", "c", "", "a", "d", "b"} {
+		if _, err := table.Put(&user{id: int64(i), email: email, created: int64(10 - i%3)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := byEmail.Get(String("d")); got == nil || got.(*user).id != 4 {
+		t.Fatalf("Get by email returned %v", got)
+	}
+	if got := scanIDs(t, byEmail, nil, nil); !reflect.DeepEqual(got, []int64{3, 5, 1, 4, 0}) {
+		t.Fatalf("Email index holds %v", got)
+	}
+	// Records with equal keys in the index follow the primary order.
+	if got := scanIDs(t, byCreated, Int64(9), nil); !reflect.DeepEqual(got, []int64{1, 4, 0, 3}) {
+		t.Fatalf("Created index from 9 holds %v", got)
+	}
+
+	// Updates move records within the indexes.
+	old, err := table.Put(&user{id: 4, email: "f", created: 1})
+	if err != nil || old.(*user).email != "d" {
+		t.Fatalf("Put returned %v, %v", old, err)
+	}
+	if byEmail.Get(String("d")) != nil || byEmail.Get(String("f")).(*user).id != 4 {
+		t.Fatal("Email index was not updated")
+	}
+	if got := scanIDs(t, byCreated, nil, Int64(9)); !reflect.DeepEqual(got, []int64{4, 2, 5}) {
+		t.Fatalf("Created index below 9 holds %v", got)
+	}
+
+	if old, err := table.Delete(&user{id: 0}); err != nil || old.(*user).email != "e" {
+		t.Fatalf("Delete returned %v, %v", old, err)
+	}
+	if old, err := table.Delete(&user{id: 0}); err != nil || old != nil {
+		t.Fatalf("Delete of a missing record returned %v, %v", old, err)
+	}
+	if byEmail.Get(String("e")) != nil || len(scanIDs(t, byCreated, nil, nil)) != 5 {
+		t.Fatal("Delete left the record in the indexes")
+	}
+
+	// An index added later picks up the records already in the table.
+	byID, err := table.AddIndex("id", func(k Key) Key { return Int64(-k.(*user).id) }, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := scanIDs(t, byID, nil, nil); !reflect.DeepEqual(got, []int64{5, 4, 3, 2, 1}) {
+		t.Fatalf("New index holds %v", got)
+	}
+	if _, err := table.AddIndex("id", nil, false); err != ErrIndexExists {
+		t.Fatalf("AddIndex of an existing name returned %v", err)
+	}
+	if table.Index("created") != byCreated || table.Index("nope") != nil {
+		t.Fatal("Index returned the wrong index")
+	}
+}
+
+func TestTableUnique(t *testing.T) {
+	table, byEmail, byCreated := newUserTable(t)
+	if _, err := table.Put(&user{id: 1, email: "a", created: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := table.Put(&user{id: 2, email: "b", created: 2}); err != nil {
+		t.Fatal(err)
+	}
+	// A record may keep its own unique key.
+	if _, err := table.Put(&user{id: 1, email: "a", created: 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A refused record changes nothing.
+	if _, err := table.Put(&user{id: 2, email: "a", created: 4}); err != ErrDuplicate {
+		t.Fatalf("Put of a duplicate email returned %v", err)
+	}
+	if _, err := table.Put(&user{id: 3, email: "a"}); err != ErrDuplicate {
+		t.Fatalf("Put of a new record with a duplicate email returned %v", err)
+	}
+	if got := table.Get(&user{id: 2}).(*user); got.email != "b" || got.created != 2 {
+		t.Fatalf("Refused Put changed the record to %+v", got)
+	}
+	if table.Get(&user{id: 3}) != nil {
+		t.Fatal("Refused Put added the record")
+	}
+	if got := scanIDs(t, byCreated, nil, nil); !reflect.DeepEqual(got, []int64{2, 1}) {
+		t.Fatalf("Created index holds %v", got)
+	}
+	if got := scanIDs(t, byEmail, String("a"), String("c")); !reflect.DeepEqual(got, []int64{1, 2}) {
+		t.Fatalf("Email index holds %v", got)
+	}
+
+	if _, err := table.AddIndex("constant", func(k Key) Key { return Int64(0) }, true); err != ErrDuplicate {
+		t.Fatalf("AddIndex of a unique index over duplicates returned %v", err)
+	}
+	if table.Index("constant") != nil {
+		t.Fatal("Refused index was added")
+	}
+	if it := byEmail.Scan(Int64(1), nil); it.Next() || it.Err() != ErrKeyTypeMismatch {
+		t.Fatalf("Scan with bounds of the wrong type returned %v", it.Err())
+	}
+	if _, err := NewTable(WithMaxKeys(10)); err != ErrInvalidLimit {
+		t.Fatalf("NewTable with a size limit returned %v", err)
+	}
+}
+
+func TestTablePutUndo(t *testing.T) {
+	table, byEmail, byCreated := newUserTable(t)
+	for i := 0; i < 20; i++ {
+		if _, err := table.Put(&user{id: int64(i), email: fmt.Sprint("a", i), created: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Rebuild the email index with small nodes and move one of its leaves down
+	// a level, so that removing from it fails once a removal merges across it.
+	small := NewBTree(3)
+	for it := byEmail.tree.Scan(nil, nil); it.Next(); {
+		small.Insert(it.Key())
+	}
+	byEmail.tree = small
+	root := small.root.(*internalNode)
+	in := newInternalNode(3)
+	in.nodes = append(in.nodes, root.nodes[1])
+	root.nodes[1] = in
+
+	var err error
+	i := 0
+	for ; i < 20 && err == nil; i++ {
+		_, err = table.Put(&user{id: int64(i), email: fmt.Sprint("b", i), created: 2})
+	}
+	if !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("Put into a corrupt index returned %v", err)
+	}
+	// The failed Put left the record as it was in the table and the other
+	// index.
+	failed := int64(i - 1)
+	if got := table.Get(&user{id: failed}).(*user); got.email != fmt.Sprint("a", failed) || got.created != 1 {
+		t.Fatalf("Failed Put changed the record to %+v", got)
+	}
+	if got := scanIDs(t, byCreated, Int64(1), Int64(2)); len(got) != 20-int(failed) || got[0] != failed {
+		t.Fatalf("Created index holds %v at 1", got)
+	}
+
+	if _, err := table.Delete(String("a")); err != ErrKeyTypeMismatch {
+		t.Fatalf("Delete of a key of the wrong type returned %v", err)
+	}
+}
+
+func TestTableDeleteUndo(t *testing.T) {
+	table, byEmail, byCreated := newUserTable(t)
+	for i := 0; i < 20; i++ {
+		if _, err := table.Put(&user{id: int64(i), email: fmt.Sprint("a", i), created: int64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Corrupt the created index as in TestTablePutUndo, so that Delete fails
+	// after removing the record from the table and the email index.
+	small := NewBTree(3)
+	for it := byCreated.tree.Scan(nil, nil); it.Next(); {
+		small.Insert(it.Key())
+	}
+	byCreated.tree = small
+	root := small.root.(*internalNode)
+	in := newInternalNode(3)
+	in.nodes = append(in.nodes, root.nodes[1])
+	root.nodes[1] = in
+
+	var err error
+	i := 0
+	for ; i < 20 && err == nil; i++ {
+		_, err = table.Delete(&user{id: int64(i)})
+	}
+	if !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("Delete from a corrupt index returned %v", err)
+	}
+	// The failed Delete left the record in the table and the email index.
+	failed := int64(i - 1)
+	if table.Get(&user{id: failed}) == nil {
+		t.Fatalf("Failed Delete removed record %d from the table", failed)
+	}
+	if got := byEmail.Get(String(fmt.Sprint("a", failed))); got == nil || got.(*user).id != failed {
+		t.Fatalf("Email index holds %v for record %d", got, failed)
+	}
+}
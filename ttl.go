@@ -0,0 +1,195 @@
+package btree
+
+import (
+	"sync"
+	"time"
+)
+
+// A Clock tells the time to a tree holding expiring keys. Tests can pass a
+// fake one to WithClock to control when keys expire and when the janitor
+// runs.
+type Clock interface {
+	Now() time.Time
+	// After returns a channel that receives the time once d has passed.
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// expiries indexes the deadlines of the expiring keys of a tree twice: by
+// key, to find the deadline of a key, and by time, so that ExpireBefore
+// visits the expired keys in order without scanning the whole tree.
+type expiries struct {
+	byKey  *BTree // of deadlineKeys
+	byTime *BTree // of expiryKeys
+}
+
+// deadlineKey orders the deadlines of keys by key.
+type deadlineKey struct {
+	k  key
+	at time.Time
+}
+
+func (d deadlineKey) Less(other key) bool {
+	return d.k.Less(other.(deadlineKey).k)
+}
+
+func (d deadlineKey) Compare(other key) int {
+	return d.k.Compare(other.(deadlineKey).k)
+}
+
+// expiryKey orders the deadlines of keys by time, then by key.
+type expiryKey struct {
+	at time.Time
+	k  key
+}
+
+func (e expiryKey) Less(other key) bool {
+	return e.Compare(other) < 0
+}
+
+func (e expiryKey) Compare(other key) int {
+	o := other.(expiryKey)
+	if c := e.at.Compare(o.at); c != 0 {
+		return c
+	}
+	return e.k.Compare(o.k)
+}
+
+func newExpiries() *expiries {
+	byKey, _ := New()
+	byTime, _ := New()
+	return &expiries{byKey: byKey, byTime: byTime}
+}
+
+// deadline returns the deadline of k, if it has one.
+func (x *expiries) deadline(k key) (time.Time, bool) {
+	if x == nil {
+		return time.Time{}, false
+	}
+	d, ok := x.byKey.Get(deadlineKey{k: k}).(deadlineKey)
+	return d.at, ok
+}
+
+// expired reports whether k had a deadline before now.
+func (x *expiries) expired(k key, now time.Time) bool {
+	at, ok := x.deadline(k)
+	return ok && at.Before(now)
+}
+
+// forget drops the deadline of k, if it has one.
+func (x *expiries) forget(k key) {
+	if at, ok := x.deadline(k); ok {
+		x.byKey.Remove(deadlineKey{k: k})
+		x.byTime.Remove(expiryKey{at: at, k: k})
+	}
+}
+
+// InsertExpiring adds k to the tree with a deadline of at, after which
+// lookups and scans treat it as absent until ExpireBefore or the janitor
+// removes it. If k is already in the tree, only its deadline changes.
+// Removing k by any other means drops its deadline, and a k evicted as it
+// goes in gets none.
+func (t *BTree) InsertExpiring(k Key, at time.Time) error {
+	if err := t.checkKey(k); err != nil {
+		return err
+	}
+	if t.expiries == nil {
+		t.expiries = newExpiries()
+	}
+	x := t.expiries
+	if t.keyType != nil && t.lookup(k) != nil {
+		x.forget(k)
+	} else if err := t.Insert(k); err != nil {
+		return err
+	} else if t.accesses != nil && t.lookup(k) == nil {
+		// A key too big for the tree's limits is evicted as it goes in.
+		return nil
+	}
+	if err := x.byKey.Insert(deadlineKey{k: k, at: at}); err != nil {
+		return err
+	}
+	return x.byTime.Insert(expiryKey{at: at, k: k})
+}
+
+// InsertTTL adds k to the tree like InsertExpiring, with a deadline of ttl
+// from the time of the tree's clock.
+func (t *BTree) InsertTTL(k Key, ttl time.Duration) error {
+	return t.InsertExpiring(k, t.cfg.clock().Now().Add(ttl))
+}
+
+// Expiry returns the deadline of k, or false if k is not in the tree or does
+// not expire.
+func (t *BTree) Expiry(k Key) (time.Time, bool) {
+	if t.checkKey(k) != nil {
+		return time.Time{}, false
+	}
+	return t.expiries.deadline(k)
+}
+
+// Clock returns the clock the tree reads the time from.
+func (t *BTree) Clock() Clock {
+	return t.cfg.clock()
+}
+
+// ExpireBefore removes every key with a deadline before at and returns the
+// number of keys removed.
+func (t *BTree) ExpireBefore(at time.Time) (int, error) {
+	x := t.expiries
+	if x == nil {
+		return 0, nil
+	}
+	n := 0
+	for {
+		leaf := x.byTime.endLeaf(false)
+		if leafLen(leaf) == 0 {
+			return n, nil
+		}
+		e := leafAt(leaf, 0).(expiryKey)
+		if !e.at.Before(at) {
+			return n, nil
+		}
+		// Remove drops the deadline from both indexes.
+		if err := t.Remove(e.k); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+// live reports whether k, found in the tree, has not expired.
+func (t *BTree) live(k key) bool {
+	return t.expiries == nil || !t.expiries.expired(k, t.cfg.clock().Now())
+}
+
+// StartJanitor starts a goroutine that removes the expired keys of the tree
+// every interval of its clock. Trees are not safe for concurrent use, so the
+// janitor holds mu while it works, and mu must guard every other use of the
+// tree as well. The returned function stops the janitor and waits for it to
+// return.
+func (t *BTree) StartJanitor(interval time.Duration, mu sync.Locker) (stop func()) {
+	clock := t.cfg.clock()
+	done, exited := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(exited)
+		for {
+			select {
+			case <-done:
+				return
+			case <-clock.After(interval):
+			}
+			mu.Lock()
+			t.ExpireBefore(clock.Now())
+			mu.Unlock()
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+		<-exited
+	}
+}
@@ -0,0 +1,192 @@
+package btree
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock that only moves when advanced.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+	at time.Time
+	c  chan time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w := fakeWaiter{at: c.now.Add(d), c: make(chan time.Time, 1)}
+	c.waiters = append(c.waiters, w)
+	return w.c
+}
+
+// Advance moves the clock by d and fires the waiters that are due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	waiters := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			waiters = append(waiters, w)
+		} else {
+			w.c <- c.now
+		}
+	}
+	c.waiters = waiters
+}
+
+func TestExpiry(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	tree, err := New(WithOrder(4), WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Even keys expire after their value in seconds, odd keys never do.
+	for v := 0; v < 200; v++ {
+		if v%2 == 0 {
+			err = tree.InsertTTL(Int64(v), time.Duration(v)*time.Second)
+		} else {
+			err = tree.Insert(Int64(v))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Reinserting a key only moves its deadline.
+	if err := tree.InsertTTL(Int64(10), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if at, ok := tree.Expiry(Int64(10)); !ok || !at.Equal(clock.Now().Add(time.Hour)) {
+		t.Fatalf("Key 10 expires at %v, %v", at, ok)
+	}
+
+	clock.Advance(100*time.Second + time.Millisecond)
+	live := func(v int) bool { return v%2 == 1 || v > 100 || v == 10 }
+	count := 0
+	for v := 0; v < 200; v++ {
+		if got := tree.Get(Int64(v)) != nil; got != live(v) {
+			t.Fatalf("Get(%d) found the key: %v", v, got)
+		}
+		if live(v) {
+			count++
+		}
+	}
+	n := 0
+	for it := tree.Scan(nil, nil); it.Next(); n++ {
+		if v := int(it.Key().(Int64)); !live(v) {
+			t.Fatalf("Scan returned expired key %d", v)
+		}
+	}
+	if n != count {
+		t.Fatalf("Scan returned %d keys, want %d", n, count)
+	}
+
+	// The expired keys are still in the tree until they are swept.
+	if tree.root.Get(Int64(0)) == nil {
+		t.Fatalf("Expired key removed before a sweep")
+	}
+	removed, err := tree.ExpireBefore(clock.Now())
+	if err != nil || removed != 200-count {
+		t.Fatalf("ExpireBefore removed %d keys with error %v, want %d", removed, err, 200-count)
+	}
+	if tree.root.Get(Int64(0)) != nil {
+		t.Fatalf("Expired key left in the tree by ExpireBefore")
+	}
+	if removed, _ := tree.ExpireBefore(clock.Now()); removed != 0 {
+		t.Fatalf("Second sweep removed %d keys", removed)
+	}
+
+	// Removing a key drops its deadline.
+	if err := tree.Remove(Int64(102)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := tree.Expiry(Int64(102)); ok {
+		t.Fatalf("Removed key kept its deadline")
+	}
+	if tree.expiries.byKey.Get(deadlineKey{k: Int64(102)}) != nil ||
+		leafLen(tree.expiries.byTime.endLeaf(false)) == 0 {
+		t.Fatalf("Deadline indexes out of step with the tree")
+	}
+}
+
+func TestExpiryEvicted(t *testing.T) {
+	tree, err := New(WithMaxBytes(8, func(k Key) int { return len(k.(String)) }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A key over the limit is evicted as it goes in, and keeps no deadline.
+	if err := tree.InsertTTL(String("too long a key"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if tree.Get(String("too long a key")) != nil {
+		t.Fatalf("Key over the limit stayed in the tree")
+	}
+	if _, ok := tree.Expiry(String("too long a key")); ok || leafLen(tree.expiries.byTime.endLeaf(false)) != 0 {
+		t.Fatalf("Evicted key kept its deadline")
+	}
+}
+
+func TestJanitor(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	tree, err := New(WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	for v := 0; v < 10; v++ {
+		tree.InsertTTL(Int64(v), time.Duration(v)*time.Minute)
+	}
+
+	stop := tree.StartJanitor(time.Minute, &mu)
+	defer stop()
+
+	stored := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		n := 0
+		for v := 0; v < 10; v++ {
+			if tree.root.Get(Int64(v)) != nil {
+				n++
+			}
+		}
+		return n
+	}
+	for i := 1; i <= 3; i++ {
+		// Wait for the janitor to wait on the clock before moving it.
+		for {
+			clock.mu.Lock()
+			waiting := len(clock.waiters) > 0
+			clock.mu.Unlock()
+			if waiting {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		clock.Advance(time.Minute + time.Second)
+
+		// Keys up to i minutes old have expired; wait for the sweep.
+		want := 10 - (i + 1)
+		deadline := time.Now().Add(5 * time.Second)
+		for stored() != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("Janitor left %d keys after %d minutes, want %d", stored(), i, want)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	stop()
+}
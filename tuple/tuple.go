@@ -0,0 +1,259 @@
+// Package tuple encodes tuples of values into byte strings whose
+// bytes.Compare order matches the order of the tuples, so composite keys can
+// be stored in a tree as plain bytes and compared with a single memcmp.
+//
+// Tuples are compared element by element. Elements of different types are
+// ordered by type: nil, bools, integers, floats, times, byte slices and then
+// strings. Within a type, elements are ordered by value, and a tuple that is
+// a prefix of another sorts first. Wrapping an element in Desc reverses its
+// order.
+package tuple
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	nilCode    = 0x05
+	falseCode  = 0x10
+	trueCode   = 0x11
+	intCode    = 0x20
+	uintCode   = 0x21 // unsigned values above math.MaxInt64
+	floatCode  = 0x30
+	timeCode   = 0x40
+	bytesCode  = 0x50
+	stringCode = 0x51
+
+	// Descending elements are stored complemented, so their type codes have
+	// the high bit set.
+	descBit = 0x80
+)
+
+// ErrInvalid is returned by Unpack for input that was not produced by Pack.
+var ErrInvalid = errors.New("tuple: invalid encoding")
+
+// Tuple is a list of elements. Supported element types are nil, bool, the
+// integer types, float32 and float64, string, []byte, time.Time and Desc.
+type Tuple []interface{}
+
+// Desc wraps an element so that it sorts in descending order.
+type Desc struct {
+	V interface{}
+}
+
+// Pack returns the encoding of t.
+func (t Tuple) Pack() []byte {
+	return t.Append(nil)
+}
+
+// Append appends the encoding of t to dst and returns the extended buffer.
+// It panics if t holds an element of an unsupported type.
+func (t Tuple) Append(dst []byte) []byte {
+	for _, e := range t {
+		if d, ok := e.(Desc); ok {
+			start := len(dst)
+			dst = appendElem(dst, d.V)
+			for i := start; i < len(dst); i++ {
+				dst[i] = ^dst[i]
+			}
+			continue
+		}
+		dst = appendElem(dst, e)
+	}
+	return dst
+}
+
+func appendElem(dst []byte, e interface{}) []byte {
+	switch v := e.(type) {
+	case nil:
+		return append(dst, nilCode)
+	case bool:
+		if v {
+			return append(dst, trueCode)
+		}
+		return append(dst, falseCode)
+	case int:
+		return appendInt(dst, int64(v))
+	case int8:
+		return appendInt(dst, int64(v))
+	case int16:
+		return appendInt(dst, int64(v))
+	case int32:
+		return appendInt(dst, int64(v))
+	case int64:
+		return appendInt(dst, v)
+	case uint:
+		return appendUint(dst, uint64(v))
+	case uint8:
+		return appendUint(dst, uint64(v))
+	case uint16:
+		return appendUint(dst, uint64(v))
+	case uint32:
+		return appendUint(dst, uint64(v))
+	case uint64:
+		return appendUint(dst, v)
+	case float32:
+		return appendFloat(dst, float64(v))
+	case float64:
+		return appendFloat(dst, v)
+	case time.Time:
+		dst = append(dst, timeCode)
+		dst = binary.BigEndian.AppendUint64(dst, uint64(v.Unix())^1<<63)
+		return binary.BigEndian.AppendUint32(dst, uint32(v.Nanosecond()))
+	case []byte:
+		return appendBytes(append(dst, bytesCode), v)
+	case string:
+		return appendBytes(append(dst, stringCode), []byte(v))
+	case Desc:
+		panic("tuple: nested Desc")
+	default:
+		panic(fmt.Sprintf("tuple: unsupported element type %T", e))
+	}
+}
+
+func appendInt(dst []byte, v int64) []byte {
+	dst = append(dst, intCode)
+	return binary.BigEndian.AppendUint64(dst, uint64(v)^1<<63)
+}
+
+func appendUint(dst []byte, v uint64) []byte {
+	if v <= math.MaxInt64 {
+		return appendInt(dst, int64(v))
+	}
+	dst = append(dst, uintCode)
+	return binary.BigEndian.AppendUint64(dst, v)
+}
+
+func appendFloat(dst []byte, v float64) []byte {
+	bits := math.Float64bits(v)
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+	dst = append(dst, floatCode)
+	return binary.BigEndian.AppendUint64(dst, bits)
+}
+
+// appendBytes escapes each 0x00 as 0x00 0xff and terminates the string with
+// 0x00 0x01, so that no encoded string is a prefix of another.
+func appendBytes(dst, b []byte) []byte {
+	for _, c := range b {
+		if c == 0 {
+			dst = append(dst, 0, 0xff)
+		} else {
+			dst = append(dst, c)
+		}
+	}
+	return append(dst, 0, 0x01)
+}
+
+// Unpack decodes a tuple produced by Pack. Integers are returned as int64,
+// or uint64 when they do not fit, floats as float64 and times in UTC.
+// Descending elements are returned wrapped in Desc.
+func Unpack(b []byte) (Tuple, error) {
+	var t Tuple
+	for len(b) > 0 {
+		var mask byte
+		if b[0]&descBit != 0 {
+			mask = 0xff
+		}
+		e, n, err := decodeElem(b, mask)
+		if err != nil {
+			return nil, err
+		}
+		if mask != 0 {
+			e = Desc{V: e}
+		}
+		t = append(t, e)
+		b = b[n:]
+	}
+	return t, nil
+}
+
+// decodeElem decodes the element at the start of b, whose bytes are XORed
+// with mask, returning it and its encoded length.
+func decodeElem(b []byte, mask byte) (interface{}, int, error) {
+	fixed := func(n int) ([]byte, error) {
+		if len(b) < 1+n {
+			return nil, ErrInvalid
+		}
+		out := make([]byte, n)
+		for i := range out {
+			out[i] = b[1+i] ^ mask
+		}
+		return out, nil
+	}
+
+	switch b[0] ^ mask {
+	case nilCode:
+		return nil, 1, nil
+	case falseCode:
+		return false, 1, nil
+	case trueCode:
+		return true, 1, nil
+	case intCode:
+		p, err := fixed(8)
+		if err != nil {
+			return nil, 0, err
+		}
+		return int64(binary.BigEndian.Uint64(p) ^ 1<<63), 9, nil
+	case uintCode:
+		p, err := fixed(8)
+		if err != nil {
+			return nil, 0, err
+		}
+		return binary.BigEndian.Uint64(p), 9, nil
+	case floatCode:
+		p, err := fixed(8)
+		if err != nil {
+			return nil, 0, err
+		}
+		bits := binary.BigEndian.Uint64(p)
+		if bits&(1<<63) != 0 {
+			bits &^= 1 << 63
+		} else {
+			bits = ^bits
+		}
+		return math.Float64frombits(bits), 9, nil
+	case timeCode:
+		p, err := fixed(12)
+		if err != nil {
+			return nil, 0, err
+		}
+		sec := int64(binary.BigEndian.Uint64(p) ^ 1<<63)
+		nsec := int64(binary.BigEndian.Uint32(p[8:]))
+		return time.Unix(sec, nsec).UTC(), 13, nil
+	case bytesCode, stringCode:
+		var out []byte
+		for i := 1; i+1 < len(b); i++ {
+			c := b[i] ^ mask
+			if c != 0 {
+				out = append(out, c)
+				continue
+			}
+			switch b[i+1] ^ mask {
+			case 0xff:
+				out = append(out, 0)
+				i++
+			case 0x01:
+				if b[0]^mask == stringCode {
+					return string(out), i + 2, nil
+				}
+				if out == nil {
+					out = []byte{}
+				}
+				return out, i + 2, nil
+			default:
+				return nil, 0, ErrInvalid
+			}
+		}
+		return nil, 0, ErrInvalid
+	default:
+		return nil, 0, ErrInvalid
+	}
+}
@@ -0,0 +1,124 @@
+package tuple
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestPackOrder(t *testing.T) {
+	// Each tuple sorts strictly after the one before it.
+	ordered := []Tuple{
+		{},
+		{nil},
+		{false},
+		{true},
+		{math.MinInt64},
+		{-1000},
+		{-1},
+		{0},
+		{0, nil},
+		{0, 1},
+		{1},
+		{uint8(2)},
+		{int64(math.MaxInt64)},
+		{uint64(math.MaxInt64) + 1},
+		{uint64(math.MaxUint64)},
+		{math.Inf(-1)},
+		{-1.5},
+		{-0.25},
+		{0.0},
+		{0.25},
+		{float32(1.5)},
+		{math.Inf(1)},
+		{time.Unix(-10, 0)},
+		{time.Unix(0, 0)},
+		{time.Unix(0, 1)},
+		{time.Unix(1700000000, 0)},
+		{[]byte{}},
+		{[]byte{0}},
+		{[]byte{0, 0}},
+		{[]byte{0, 1}},
+		{[]byte{1}},
+		{[]byte{0xff}},
+		{""},
+		{"", 1},
+		{"a"},
+		{"a", "b"},
+		{"a\x00"},
+		{"a\x00b"},
+		{"ab"},
+		{"b"},
+	}
+	for i := 1; i < len(ordered); i++ {
+		a, b := ordered[i-1].Pack(), ordered[i].Pack()
+		if bytes.Compare(a, b) >= 0 {
+			t.Fatalf("Encoding of %v did not sort before encoding of %v", ordered[i-1], ordered[i])
+		}
+	}
+}
+
+func TestPackDescending(t *testing.T) {
+	values := []interface{}{nil, false, true, -5, 0, 7, uint64(math.MaxUint64), -2.5, 3.0,
+		time.Unix(5, 0), []byte{0}, []byte{0, 1}, "", "a", "a\x00", "ab"}
+	for i := 1; i < len(values); i++ {
+		a := Tuple{"user", Desc{values[i-1]}, 1}.Pack()
+		b := Tuple{"user", Desc{values[i]}, 1}.Pack()
+		if bytes.Compare(a, b) <= 0 {
+			t.Fatalf("Descending encoding of %v did not sort after encoding of %v", values[i-1], values[i])
+		}
+	}
+}
+
+func TestUnpack(t *testing.T) {
+	ts := time.Unix(1700000000, 12345).UTC()
+	in := Tuple{nil, true, false, int64(-3), uint64(math.MaxUint64), 2.5, ts, []byte{0, 1, 0}, "x\x00y",
+		Desc{"z"}, Desc{int64(9)}, Desc{[]byte{}}, Desc{ts}}
+	out, err := Unpack(in.Pack())
+	if err != nil {
+		t.Fatalf("Failed to unpack: %v", err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("Got %#v instead of expected %#v", out, in)
+	}
+}
+
+func TestUnpackInvalid(t *testing.T) {
+	for _, b := range [][]byte{
+		{0x99},
+		{intCode, 1, 2},
+		{stringCode, 'a'},
+		{stringCode, 'a', 0, 0x02},
+	} {
+		if _, err := Unpack(b); err != ErrInvalid {
+			t.Fatalf("Got error %v instead of expected error %v for %x", err, ErrInvalid, b)
+		}
+	}
+}
+
+func TestPackSortsLikeTuples(t *testing.T) {
+	type row struct {
+		name string
+		age  int
+	}
+	rows := []row{{"bob", 30}, {"alice", 40}, {"bob", 25}, {"alice", 3}, {"carol", -1}, {"al", 99}}
+	packed := make([][]byte, len(rows))
+	for i, r := range rows {
+		packed[i] = Tuple{r.name, r.age}.Pack()
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].name != rows[j].name {
+			return rows[i].name < rows[j].name
+		}
+		return rows[i].age < rows[j].age
+	})
+	sort.Slice(packed, func(i, j int) bool { return bytes.Compare(packed[i], packed[j]) < 0 })
+	for i, r := range rows {
+		if !bytes.Equal(packed[i], Tuple{r.name, r.age}.Pack()) {
+			t.Fatalf("Got a different order at position %d", i)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package btree
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// An EventKind tells what a change did to a key.
+type EventKind int
+
+const (
+	// EventInsert reports a key added to the tree.
+	EventInsert EventKind = iota
+	// EventReplace reports a key replaced by ReplaceOrInsert with an equal
+	// one.
+	EventReplace
+	// EventDelete reports a key removed from the tree, including by
+	// eviction, expiry and Clear.
+	EventDelete
+)
+
+// An Event describes a change to a key of the tree. Old is the key that was
+// in the tree before the change and New the key after it; Old is nil for
+// inserts and New for deletes.
+type Event struct {
+	Kind     EventKind
+	Old, New Key
+}
+
+// Key returns the key that changed.
+func (e Event) Key() Key {
+	if e.New != nil {
+		return e.New
+	}
+	return e.Old
+}
+
+// A WatchPolicy decides what happens to the events of a watcher whose
+// buffer is full.
+type WatchPolicy int
+
+const (
+	// WatchDrop drops the events that do not fit in the buffer and counts
+	// them in Dropped.
+	WatchDrop WatchPolicy = iota
+	// WatchBlock blocks the change until the event fits in the buffer.
+	WatchBlock
+	// WatchCoalesce merges the pending events of each key into one, such
+	// as an insert and a replace into a single insert, and blocks only when
+	// the buffer is full of events for distinct keys.
+	WatchCoalesce
+)
+
+// A Watcher receives the events of the changes to the keys of a range of the
+// tree, from Watch until Unwatch.
+type Watcher struct {
+	lo, hi  key
+	policy  WatchPolicy
+	c       chan Event
+	dropped atomic.Int64
+
+	// WatchCoalesce keeps its events pending until a goroutine delivers them
+	// to c, so that they can still be merged.
+	mu      sync.Mutex
+	room    *sync.Cond
+	pending []Event
+	size    int
+	wake    chan struct{}
+	done    chan struct{}
+	closed  bool
+}
+
+// Watch returns a watcher receiving the events of the keys k with
+// lo <= k < hi, where a nil lo or hi leaves that end of the range open. The
+// events of each change are sent after it completes, through a buffer of
+// size events handled by policy when full.
+func (t *BTree) Watch(lo, hi Key, size int, policy WatchPolicy) (*Watcher, error) {
+	for _, k := range []key{lo, hi} {
+		if k != nil && t.checkKey(k) != nil {
+			return nil, ErrKeyTypeMismatch
+		}
+	}
+	if size < 1 {
+		size = 1
+	}
+
+	w := &Watcher{lo: lo, hi: hi, policy: policy}
+	if policy == WatchCoalesce {
+		w.c = make(chan Event)
+		w.room = sync.NewCond(&w.mu)
+		w.size = size
+		w.wake = make(chan struct{}, 1)
+		w.done = make(chan struct{})
+		go w.deliver()
+	} else {
+		w.c = make(chan Event, size)
+	}
+	t.watchers = append(t.watchers, w)
+	return w, nil
+}
+
+// Unwatch stops w from receiving events and closes its channel. Events
+// still pending under WatchCoalesce are dropped.
+func (t *BTree) Unwatch(w *Watcher) {
+	for i, o := range t.watchers {
+		if o != w {
+			continue
+		}
+		t.watchers = append(t.watchers[:i], t.watchers[i+1:]...)
+		if w.policy == WatchCoalesce {
+			w.mu.Lock()
+			w.closed = true
+			w.room.Broadcast()
+			w.mu.Unlock()
+			close(w.done)
+		} else {
+			close(w.c)
+		}
+		return
+	}
+}
+
+// Events returns the channel that receives the events of w. It is closed by
+// Unwatch.
+func (w *Watcher) Events() <-chan Event {
+	return w.c
+}
+
+// Dropped returns the number of events dropped by WatchDrop.
+func (w *Watcher) Dropped() int {
+	return int(w.dropped.Load())
+}
+
+func (w *Watcher) covers(k key) bool {
+	return (w.lo == nil || !k.Less(w.lo)) && (w.hi == nil || k.Less(w.hi))
+}
+
+func (w *Watcher) send(e Event) {
+	switch w.policy {
+	case WatchDrop:
+		select {
+		case w.c <- e:
+		default:
+			w.dropped.Add(1)
+		}
+	case WatchBlock:
+		w.c <- e
+	default:
+		w.coalesce(e)
+	}
+}
+
+// coalesce queues e for delivery, merged into the pending event of its key
+// if there is one.
+func (w *Watcher) coalesce(e Event) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	k := e.Key()
+	for i, p := range w.pending {
+		if p.Key().Compare(k) != 0 {
+			continue
+		}
+		if m, keep, ok := mergeEvents(p, e); ok {
+			if keep {
+				w.pending[i] = m
+			} else {
+				w.pending = append(w.pending[:i], w.pending[i+1:]...)
+				w.room.Signal()
+			}
+			return
+		}
+	}
+	for len(w.pending) >= w.size && !w.closed {
+		w.room.Wait()
+	}
+	if w.closed {
+		return
+	}
+	w.pending = append(w.pending, e)
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// mergeEvents merges the event a with b, a later event of the same key. It
+// reports whether they merge at all, and if so whether the merged event
+// remains or they cancel out.
+func mergeEvents(a, b Event) (m Event, keep, ok bool) {
+	switch {
+	case a.Kind == EventInsert && b.Kind == EventReplace:
+		return Event{Kind: EventInsert, New: b.New}, true, true
+	case a.Kind == EventInsert && b.Kind == EventDelete:
+		return Event{}, false, true
+	case a.Kind == EventReplace && b.Kind != EventInsert:
+		return Event{Kind: b.Kind, Old: a.Old, New: b.New}, true, true
+	case a.Kind == EventDelete && b.Kind == EventInsert:
+		return Event{Kind: EventReplace, Old: a.Old, New: b.New}, true, true
+	}
+	return Event{}, false, false
+}
+
+// deliver sends the pending events of a WatchCoalesce watcher to its channel
+// until Unwatch.
+func (w *Watcher) deliver() {
+	defer close(w.c)
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-w.wake:
+		}
+		for {
+			w.mu.Lock()
+			if len(w.pending) == 0 {
+				w.mu.Unlock()
+				break
+			}
+			e := w.pending[0]
+			w.pending = w.pending[1:]
+			w.room.Signal()
+			w.mu.Unlock()
+
+			select {
+			case w.c <- e:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
+
+// notify sends an event for k to the watchers of its range.
+func (t *BTree) notify(kind EventKind, old, new key) {
+	e := Event{Kind: kind, Old: old, New: new}
+	k := e.Key()
+	for _, w := range t.watchers {
+		if w.covers(k) {
+			w.send(e)
+		}
+	}
+}
+
+// watched returns the key equal to k in the tree if the tree has watchers,
+// which need it for the events of removals.
+func (t *BTree) watched(k key) key {
+	if len(t.watchers) == 0 || t.keyType == nil {
+		return nil
+	}
+	return t.lookup(k)
+}
+
+// ReplaceOrInsert replaces the key in the tree equal to k with k, such as to
+// update the rest of a key that compares only by part of itself, and returns
+// the key replaced. If there is none, it adds k like Insert and returns nil.
+func (t *BTree) ReplaceOrInsert(k Key) (old Key, err error) {
+	if err := t.checkKey(k); err != nil {
+		return nil, err
+	}
+	if t.keyType == nil {
+		return nil, t.Insert(k)
+	}
+	defer catch(&err)
+
+	t.drain()
+	var path []*internalNode
+	n := t.root
+	for {
+		in, ok := n.(*internalNode)
+		if !ok {
+			break
+		}
+		_, i := in.searchKNIndex(k)
+		path, n = append(path, in), in.nodes[i]
+	}
+	i := n.Search(k)
+	if i == leafLen(n) || leafAt(n, i).Compare(k) != 0 {
+		return nil, t.Insert(k)
+	}
+
+	// k may hash differently from the key it replaces.
+	old = leafAt(n, i)
+	before := *n.sums()
+	setLeafAt(n, i, k)
+	d := n.sums().since(before)
+	for _, in := range path {
+		in.sum.add(d)
+	}
+	if !t.live(old) {
+		// An expired key is as good as gone, so k takes its place afresh.
+		t.expiries.forget(old)
+		t.accesses.touch(k)
+		t.notify(EventInsert, nil, k)
+		return nil, nil
+	}
+	t.accesses.touch(k)
+	t.notify(EventReplace, old, k)
+	return old, nil
+}
+
+// setLeafAt replaces the key at position i of the leaf n with k, which must
+// be equal to it. Only plain leaves hold keys that can differ while being
+// equal; the others hold their keys' values.
+func setLeafAt(n node, i int, k key) {
+	if l, ok := n.(*leafNode); ok {
+		l.sum.sub(l.cfg.hashKey(l.keys[i]))
+		l.keys[i] = k
+		l.sum.add(l.cfg.hashKey(k))
+	}
+}
@@ -0,0 +1,128 @@
+package btree
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// expectEvents reads len(want) events from w and compares them with want,
+// including the identity of their keys.
+func expectEvents(t *testing.T, w *Watcher, want []Event) {
+	t.Helper()
+	for i, e := range want {
+		got, ok := <-w.Events()
+		if !ok {
+			t.Fatalf("Channel closed before event %d", i)
+		}
+		if got != e {
+			t.Fatalf("Event %d is %+v, want %+v", i, got, e)
+		}
+	}
+	select {
+	case e := <-w.Events():
+		t.Fatalf("Unexpected event %+v", e)
+	default:
+	}
+}
+
+func TestWatch(t *testing.T) {
+	tree := NewBTree(4)
+	w, err := tree.Watch(&testKey{10}, &testKey{20}, 100, WatchBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k5, k15, k16, k17 := &testKey{5}, &testKey{15}, &testKey{16}, &testKey{17}
+	tree.Insert(k5)
+	tree.Insert(k15)
+	k15b := &testKey{15}
+	if old, err := tree.ReplaceOrInsert(k15b); old != k15 || err != nil {
+		t.Fatalf("ReplaceOrInsert returned %v, %v", old, err)
+	}
+	if tree.Get(&testKey{15}) != k15b {
+		t.Fatalf("Key not replaced")
+	}
+	tree.Remove(k16)
+	tree.InsertBatch([]Key{k16, k17})
+	tree.RemoveBatch([]Key{&testKey{15}, &testKey{17}, &testKey{18}})
+	tree.Remove(&testKey{16})
+	expectEvents(t, w, []Event{
+		{Kind: EventInsert, New: k15},
+		{Kind: EventReplace, Old: k15, New: k15b},
+		{Kind: EventInsert, New: k16},
+		{Kind: EventInsert, New: k17},
+		{Kind: EventDelete, Old: k15b},
+		{Kind: EventDelete, Old: k17},
+		{Kind: EventDelete, Old: k16},
+	})
+
+	for v := 0; v < 30; v++ {
+		tree.Insert(&testKey{v})
+	}
+	for i := 0; i < 10; i++ {
+		<-w.Events()
+	}
+	tree.Clear()
+	if n := len(w.Events()); n != 10 {
+		t.Fatalf("Clear sent %d events, want 10", n)
+	}
+
+	tree.Unwatch(w)
+	for range w.Events() {
+	}
+	tree.Insert(k15)
+	if _, err := tree.Watch(String("a"), nil, 1, WatchDrop); !errors.Is(err, ErrKeyTypeMismatch) {
+		t.Fatalf("Watch with a mismatched key returned %v", err)
+	}
+}
+
+func TestWatchDrop(t *testing.T) {
+	tree := NewBTree(4)
+	w, _ := tree.Watch(nil, nil, 2, WatchDrop)
+	for v := 0; v < 5; v++ {
+		tree.Insert(&testKey{v})
+	}
+	if len(w.Events()) != 2 || w.Dropped() != 3 {
+		t.Fatalf("Buffered %d events and dropped %d", len(w.Events()), w.Dropped())
+	}
+	tree.Unwatch(w)
+}
+
+func TestWatchCoalesce(t *testing.T) {
+	tree := NewBTree(4)
+	w, _ := tree.Watch(nil, nil, 4, WatchCoalesce)
+
+	// Nothing reads until all the changes are made, so once the first event
+	// is taken for delivery, all the others stay pending.
+	a, b, b2, c := &testKey{1}, &testKey{2}, &testKey{2}, &testKey{3}
+	tree.Insert(a)
+	for {
+		w.mu.Lock()
+		n := len(w.pending)
+		w.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	tree.Insert(b)
+	tree.ReplaceOrInsert(b2)
+	tree.Insert(c)
+	tree.Remove(c)
+	tree.Remove(a)
+	tree.Insert(c)
+	tree.ReplaceOrInsert(&testKey{1})
+	tree.Remove(&testKey{1})
+
+	expectEvents(t, w, []Event{
+		{Kind: EventInsert, New: a},
+		{Kind: EventInsert, New: b2},
+		{Kind: EventDelete, Old: a},
+		{Kind: EventInsert, New: c},
+	})
+	tree.Unwatch(w)
+	if _, ok := <-w.Events(); ok {
+		t.Fatalf("Channel left open by Unwatch")
+	}
+}